@@ -0,0 +1,197 @@
+package sendmail
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+var ErrInvalidAddress = errors.New("sendmail: invalid email address")
+var ErrInvalidLocalPart = errors.New("sendmail: invalid local part")
+var ErrInvalidDomain = errors.New("sendmail: invalid domain")
+var ErrAddressTooLong = errors.New("sendmail: email address too long")
+
+// AddressError reports an invalid address of a message. Field names the address, e.g. "from", "to[1]" or
+// "personalizations[0].to", Err is one of ErrInvalidAddress, ErrInvalidLocalPart, ErrInvalidDomain or
+// ErrAddressTooLong.
+type AddressError struct {
+	Field   string
+	Address string
+	Reason  string
+	Err     error
+}
+
+func (e *AddressError) Error() string {
+	return fmt.Sprintf("%s: %s %q: %s", e.Err, e.Field, e.Address, e.Reason)
+}
+
+func (e *AddressError) Unwrap() error {
+	return e.Err
+}
+
+// RFC 5321 limits
+const (
+	maxAddressLength   = 254
+	maxLocalPartLength = 64
+	maxDomainLength    = 253
+	maxLabelLength     = 63
+)
+
+// NormalizeAddress validates a bare address, user@example.com, and returns it with the domain converted to
+// lowercase punycode. The local part must be a dot-atom: quoted local parts, comments, domain literals and
+// single label domains are rejected, although RFC 5322 allows them, as providers do not accept them either.
+// The returned error is an *AddressError.
+func NormalizeAddress(address string) (string, error) {
+	normalized, reason, err := normalizeAddress(strings.TrimSpace(address))
+	if err != nil {
+		return "", &AddressError{Address: address, Reason: reason, Err: err}
+	}
+	return normalized, nil
+}
+
+func normalizeAddress(address string) (string, string, error) {
+	if address == "" {
+		return "", "empty address", ErrInvalidAddress
+	}
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", strings.TrimPrefix(err.Error(), "mail: "), ErrInvalidAddress
+	}
+	if parsed.Name != "" {
+		return "", "display names belong in Email.Name", ErrInvalidAddress
+	}
+	if parsed.Address != address {
+		return "", "quoted local parts and comments are not supported", ErrInvalidLocalPart
+	}
+
+	at := strings.LastIndexByte(address, '@')
+	local, domain := address[:at], address[at+1:]
+	if reason := checkLocalPart(local); reason != "" {
+		return "", reason, ErrInvalidLocalPart
+	}
+	ascii, reason := normalizeDomain(domain)
+	if reason != "" {
+		return "", reason, ErrInvalidDomain
+	}
+
+	normalized := local + "@" + ascii
+	if len(normalized) > maxAddressLength {
+		return "", fmt.Sprintf("longer than %d characters", maxAddressLength), ErrAddressTooLong
+	}
+	return normalized, "", nil
+}
+
+// checkLocalPart returns why local is not a dot-atom, non-ASCII characters are allowed as in RFC 6531
+func checkLocalPart(local string) string {
+	if len(local) > maxLocalPartLength {
+		return fmt.Sprintf("longer than %d characters", maxLocalPartLength)
+	}
+	if strings.HasPrefix(local, ".") || strings.HasSuffix(local, ".") || strings.Contains(local, "..") {
+		return "dots must separate characters"
+	}
+	for _, r := range local {
+		if r < utf8.RuneSelf && r != '.' && !isAtext(byte(r)) {
+			return fmt.Sprintf("character %q is not allowed", r)
+		}
+	}
+	return ""
+}
+
+// isAtext reports whether c is an RFC 5322 atext character
+func isAtext(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-/=?^_`{|}~", c) >= 0
+}
+
+// normalizeDomain converts an internationalized domain to lowercase punycode, returning why it is invalid
+func normalizeDomain(domain string) (string, string) {
+	if strings.HasPrefix(domain, "[") {
+		return "", "domain literals are not supported"
+	}
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", strings.TrimPrefix(err.Error(), "idna: ")
+	}
+	ascii = strings.ToLower(ascii)
+	if len(ascii) > maxDomainLength {
+		return "", fmt.Sprintf("longer than %d characters", maxDomainLength)
+	}
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		return "", "a top level domain is required"
+	}
+	for _, label := range labels {
+		switch {
+		case label == "":
+			return "", "empty label"
+		case len(label) > maxLabelLength:
+			return "", fmt.Sprintf("label %q longer than %d characters", label, maxLabelLength)
+		case label[0] == '-' || label[len(label)-1] == '-':
+			return "", fmt.Sprintf("label %q starts or ends with a hyphen", label)
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-') {
+				return "", fmt.Sprintf("character %q is not allowed", c)
+			}
+		}
+	}
+	return ascii, ""
+}
+
+// checkAddress validates the address of field without changing it
+func checkAddress(field string, email *Email) error {
+	if email == nil {
+		return &AddressError{Field: field, Reason: "empty address", Err: ErrInvalidAddress}
+	}
+	if _, err := NormalizeAddress(email.Address); err != nil {
+		addressErr := err.(*AddressError)
+		addressErr.Field = field
+		return addressErr
+	}
+	return nil
+}
+
+// normalizeAddresses rewrites the addresses of the message in normalized form and drops repeated recipients,
+// addresses are compared ignoring case
+func (m *Message) normalizeAddresses() {
+	normalize := func(email *Email) {
+		if normalized, err := NormalizeAddress(email.Address); err == nil {
+			email.Address = normalized
+		}
+	}
+	if m.FromEmail != nil {
+		normalize(m.FromEmail)
+	}
+
+	seen := map[string]bool{}
+	recipients := m.Recipients[:0]
+	for _, recipient := range m.Recipients {
+		normalize(recipient)
+		key := strings.ToLower(recipient.Address)
+		if !seen[key] {
+			seen[key] = true
+			recipients = append(recipients, recipient)
+		}
+	}
+	m.Recipients = recipients
+
+	seen = map[string]bool{}
+	personalizations := m.Personalizations[:0]
+	for _, personalization := range m.Personalizations {
+		normalize(personalization.To)
+		key := strings.ToLower(personalization.To.Address)
+		if !seen[key] {
+			seen[key] = true
+			personalizations = append(personalizations, personalization)
+		}
+	}
+	m.Personalizations = personalizations
+}
@@ -0,0 +1,93 @@
+package sendmail
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	valid := map[string]string{
+		"jane@example.com":            "jane@example.com",
+		"  Jane.Doe@EXAMPLE.Com ":     "Jane.Doe@example.com",
+		"jane+tag@mail.example.co.uk": "jane+tag@mail.example.co.uk",
+		"o'brien@example.com":         "o'brien@example.com",
+		"jane@bücher.example":         "jane@xn--bcher-kva.example",
+		"jane@BÜCHER.example":         "jane@xn--bcher-kva.example",
+		"用户@例子.广告":                    "用户@xn--fsqu00a.xn--4rr70v",
+	}
+	for address, expected := range valid {
+		normalized, err := NormalizeAddress(address)
+		require.NoError(t, err, address)
+		assert.Equal(t, expected, normalized, address)
+	}
+
+	invalid := map[string]error{
+		"":                                 ErrInvalidAddress,
+		"jane":                             ErrInvalidAddress,
+		"Jane <jane@example.com>":          ErrInvalidAddress,
+		`"jane doe"@example.com`:           ErrInvalidLocalPart,
+		"jane..doe@example.com":            ErrInvalidAddress,
+		"jane@localhost":                   ErrInvalidDomain,
+		"jane@[192.168.0.1]":               ErrInvalidDomain,
+		"jane@-example.com":                ErrInvalidDomain,
+		"jane@exa_mple.com":                ErrInvalidDomain,
+		"jane@example..com":                ErrInvalidAddress,
+		strings.Repeat("a", 65) + "@x.com": ErrInvalidLocalPart,
+		"jane@" + strings.Repeat("a", 64) + ".com":                       ErrInvalidDomain,
+		strings.Repeat("a", 64) + "@" + strings.Repeat("b.", 94) + "com": ErrAddressTooLong,
+	}
+	for address, expected := range invalid {
+		_, err := NormalizeAddress(address)
+		assert.ErrorIs(t, err, expected, address)
+		var addressErr *AddressError
+		require.ErrorAs(t, err, &addressErr, address)
+		assert.Equal(t, address, addressErr.Address)
+		assert.NotEmpty(t, addressErr.Reason, address)
+	}
+}
+
+func TestMessage_ValidateAddresses(t *testing.T) {
+	message := testMessage(t)
+	message.Recipients = append(message.Recipients, &Email{Name: "Joe", Address: "joe@localhost"})
+	err := message.Validate()
+
+	var addressErr *AddressError
+	require.ErrorAs(t, err, &addressErr)
+	assert.Equal(t, "to[1]", addressErr.Field)
+	assert.Equal(t, "joe@localhost", addressErr.Address)
+	assert.ErrorIs(t, err, ErrInvalidDomain)
+	assert.EqualError(t, err, `sendmail: invalid domain: to[1] "joe@localhost": a top level domain is required`)
+
+	message = testMessage(t)
+	message.FromEmail = &Email{Address: "not an address"}
+	require.ErrorAs(t, message.Validate(), &addressErr)
+	assert.Equal(t, "from", addressErr.Field)
+}
+
+func TestMessageBuilder_NormalizesAddresses(t *testing.T) {
+	message, err := NewEmailMessage().
+		FromEmail("Shop", "Shop@Example.COM").
+		AddRecipient("Jane", "jane@EXAMPLE.com").
+		AddRecipient("Jane again", " JANE@example.com").
+		AddRecipient("Joe", "joe@bücher.example").
+		Subject("Hello").
+		PlainTextContent("Hi").
+		Build()
+	require.NoError(t, err)
+
+	assert.Equal(t, "Shop@example.com", message.FromEmail.Address)
+	assert.Equal(t, []*Email{
+		{Name: "Jane", Address: "jane@example.com"},
+		{Name: "Joe", Address: "joe@xn--bcher-kva.example"},
+	}, message.Recipients)
+
+	_, err = NewEmailMessage().
+		FromEmail("Shop", "shop@example.com").
+		AddRecipient("Jane", "jane@example").
+		Subject("Hello").
+		Build()
+	assert.ErrorIs(t, err, ErrInvalidDomain)
+}
@@ -0,0 +1,376 @@
+package sendmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUndeliverableAddress = errors.New("sendmail: address domain does not accept mail")
+var ErrDisposableAddress = errors.New("sendmail: disposable email address")
+
+// Resolver looks up the DNS records used to verify a domain, *net.Resolver implements it
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// AddressVerification is the outcome of verifying an address. Deliverable reports the domain has MX records, or
+// an A/AAAA record to fall back to. Suggestion is a likely intended address when the domain looks like a typo of a
+// well known one, e.g. jane@gmail.com for jane@gmial.com.
+type AddressVerification struct {
+	Address     string
+	MX          []string
+	Deliverable bool
+	Disposable  bool
+	Suggestion  string
+}
+
+// AddressVerifier checks that recipient domains can receive mail, flags disposable domains and suggests corrections
+// for common typos. It can be used on its own, e.g. in a signup form, or before sending with NewVerifyingSender.
+// Domain lookups are cached for 10 minutes, up to the cache size.
+type AddressVerifier struct {
+	resolver        Resolver
+	disposable      map[string]bool
+	knownDomains    []string
+	allowDisposable bool
+	cacheSize       int
+
+	mu    sync.Mutex
+	cache map[string]*domainLookup
+	now   func() time.Time
+}
+
+type domainLookup struct {
+	mx          []string
+	deliverable bool
+	expires     time.Time
+}
+
+const domainLookupTTL = 10 * time.Minute
+
+// DefaultDisposableDomains is the list of disposable domains used unless WithDisposableDomains is given
+var DefaultDisposableDomains = []string{
+	"10minutemail.com", "discard.email", "dispostable.com", "fakeinbox.com", "getnada.com", "guerrillamail.com",
+	"guerrillamail.net", "maildrop.cc", "mailinator.com", "mailnesia.com", "mintemail.com", "mohmal.com",
+	"sharklasers.com", "temp-mail.org", "tempmail.com", "throwawaymail.com", "trashmail.com", "yopmail.com",
+}
+
+// DefaultKnownDomains are the domains typos are checked against unless WithKnownDomains is given
+var DefaultKnownDomains = []string{
+	"aol.com", "comcast.net", "gmail.com", "googlemail.com", "gmx.com", "gmx.de", "hotmail.com", "icloud.com",
+	"live.com", "mail.com", "me.com", "msn.com", "outlook.com", "proton.me", "protonmail.com", "web.de",
+	"yahoo.com", "yandex.ru",
+}
+
+// AddressVerifierOption configures an AddressVerifier
+type AddressVerifierOption func(*AddressVerifier)
+
+// WithResolver replaces net.DefaultResolver, e.g. with a fake resolver in tests
+func WithResolver(resolver Resolver) AddressVerifierOption {
+	return func(v *AddressVerifier) {
+		v.resolver = resolver
+	}
+}
+
+// WithDisposableDomains replaces the disposable domain list, subdomains of the domains are disposable too
+func WithDisposableDomains(domains ...string) AddressVerifierOption {
+	return func(v *AddressVerifier) {
+		v.disposable = map[string]bool{}
+		for _, domain := range domains {
+			v.disposable[strings.ToLower(strings.TrimSpace(domain))] = true
+		}
+	}
+}
+
+// WithKnownDomains replaces the domains typos are checked against
+func WithKnownDomains(domains ...string) AddressVerifierOption {
+	return func(v *AddressVerifier) {
+		v.knownDomains = domains
+	}
+}
+
+// WithDomainCacheSize sets the number of domain lookups cached, default 10000. When the cache is full expired
+// lookups are dropped, then the oldest ones.
+func WithDomainCacheSize(size int) AddressVerifierOption {
+	return func(v *AddressVerifier) {
+		v.cacheSize = size
+	}
+}
+
+// WithAllowDisposable makes Check accept disposable addresses, they are still flagged by Verify
+func WithAllowDisposable() AddressVerifierOption {
+	return func(v *AddressVerifier) {
+		v.allowDisposable = true
+	}
+}
+
+func NewAddressVerifier(opts ...AddressVerifierOption) *AddressVerifier {
+	v := &AddressVerifier{
+		resolver:     net.DefaultResolver,
+		knownDomains: DefaultKnownDomains,
+		cacheSize:    10000,
+		cache:        map[string]*domainLookup{},
+		now:          time.Now,
+	}
+	WithDisposableDomains(DefaultDisposableDomains...)(v)
+	for _, opt := range opts {
+		opt(v)
+	}
+	if v.cacheSize < 1 {
+		v.cacheSize = 1
+	}
+	return v
+}
+
+// Verify normalizes the address and looks up its domain. An invalid address returns an *AddressError, a failed
+// lookup other than a missing domain returns the resolver error.
+func (v *AddressVerifier) Verify(ctx context.Context, address string) (*AddressVerification, error) {
+	normalized, err := NormalizeAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	at := strings.LastIndexByte(normalized, '@')
+	local, domain := normalized[:at], normalized[at+1:]
+
+	verification := &AddressVerification{Address: normalized, Disposable: v.isDisposable(domain)}
+	lookup, err := v.lookupDomain(ctx, domain)
+	if err == nil {
+		verification.MX = lookup.mx
+		verification.Deliverable = lookup.deliverable
+	}
+	// a domain with MX records is meant to receive mail, however close to a known domain it is
+	if len(verification.MX) == 0 {
+		if suggestion := v.suggestDomain(domain); suggestion != "" {
+			verification.Suggestion = local + "@" + suggestion
+		}
+	}
+	return verification, err
+}
+
+// Check verifies the address, returning an *AddressError wrapping ErrUndeliverableAddress or ErrDisposableAddress
+// when it should not be mailed
+func (v *AddressVerifier) Check(ctx context.Context, address string) error {
+	verification, err := v.Verify(ctx, address)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !verification.Deliverable:
+		reason := "no MX or A records"
+		if verification.Suggestion != "" {
+			reason += ", did you mean " + verification.Suggestion + "?"
+		}
+		return &AddressError{Address: address, Reason: reason, Err: ErrUndeliverableAddress}
+	case verification.Disposable && !v.allowDisposable:
+		return &AddressError{Address: address, Reason: "disposable domain", Err: ErrDisposableAddress}
+	}
+	return nil
+}
+
+func (v *AddressVerifier) isDisposable(domain string) bool {
+	for {
+		if v.disposable[domain] {
+			return true
+		}
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			return false
+		}
+		domain = parent
+	}
+}
+
+// suggestDomain returns the closest known domain within reach of domain. Short domains are a single edit away
+// from many others, so they allow one edit and longer domains two.
+func (v *AddressVerifier) suggestDomain(domain string) string {
+	maxDistance := 1
+	if len(domain) >= 10 {
+		maxDistance = 2
+	}
+	best, bestDistance := "", maxDistance+1
+	for _, known := range v.knownDomains {
+		if known == domain {
+			return ""
+		}
+		if distance := editDistance(domain, known); distance < bestDistance {
+			best, bestDistance = known, distance
+		}
+	}
+	return best
+}
+
+// editDistance is the optimal string alignment distance, counting a swap of adjacent characters as one edit
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// lookupDomain finds the MX hosts of domain, falling back to its address records as RFC 5321 does. A null MX,
+// RFC 7505, makes the domain undeliverable.
+func (v *AddressVerifier) lookupDomain(ctx context.Context, domain string) (*domainLookup, error) {
+	v.mu.Lock()
+	cached, ok := v.cache[domain]
+	v.mu.Unlock()
+	if ok && v.now().Before(cached.expires) {
+		return cached, nil
+	}
+
+	lookup := &domainLookup{expires: v.now().Add(domainLookupTTL)}
+	records, err := v.resolver.LookupMX(ctx, domain)
+	switch {
+	case err == nil && len(records) > 0:
+		for _, record := range records {
+			if host := strings.TrimSuffix(record.Host, "."); host != "" {
+				lookup.mx = append(lookup.mx, host)
+			}
+		}
+		lookup.deliverable = len(lookup.mx) > 0
+	case err != nil && !isNotFound(err):
+		return nil, err
+	default:
+		hosts, err := v.resolver.LookupHost(ctx, domain)
+		if err != nil && !isNotFound(err) {
+			return nil, err
+		}
+		lookup.deliverable = len(hosts) > 0
+	}
+
+	v.mu.Lock()
+	v.store(domain, lookup)
+	v.mu.Unlock()
+	return lookup, nil
+}
+
+// store caches the lookup, making room when the cache is full. Callers hold v.mu.
+func (v *AddressVerifier) store(domain string, lookup *domainLookup) {
+	if _, ok := v.cache[domain]; !ok && len(v.cache) >= v.cacheSize {
+		now := v.now()
+		for cached, entry := range v.cache {
+			if !now.Before(entry.expires) {
+				delete(v.cache, cached)
+			}
+		}
+		// every lookup has the same TTL, the one expiring first is the oldest
+		if len(v.cache) >= v.cacheSize {
+			oldest := ""
+			for cached, entry := range v.cache {
+				if oldest == "" || entry.expires.Before(v.cache[oldest].expires) {
+					oldest = cached
+				}
+			}
+			delete(v.cache, oldest)
+		}
+	}
+	v.cache[domain] = lookup
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// VerifyingSender checks the recipients with an AddressVerifier before sending, a message with an undeliverable
+// or disposable recipient fails with the *AddressError of the first one, which wraps ErrInvalidMessage like the errors
+// of Validate. Lookup failures other than a missing domain do not block sending.
+type VerifyingSender struct {
+	sender   SendMail
+	verifier *AddressVerifier
+}
+
+func NewVerifyingSender(sender SendMail, verifier *AddressVerifier) *VerifyingSender {
+	return &VerifyingSender{sender: sender, verifier: verifier}
+}
+
+func (s *VerifyingSender) SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent string) (*Response, error) {
+	if err := s.check(context.Background(), "to[0]", toEmail); err != nil {
+		return nil, err
+	}
+	return s.sender.SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent)
+}
+
+func (s *VerifyingSender) SendMessage(message *Message) (*Response, error) {
+	return s.SendMessageContext(context.Background(), message)
+}
+
+// SendMessageContext passes the context to the DNS lookups
+func (s *VerifyingSender) SendMessageContext(ctx context.Context, message *Message) (*Response, error) {
+	if err := s.checkMessage(ctx, message); err != nil {
+		return nil, err
+	}
+	return s.sender.SendMessage(message)
+}
+
+// SendBatch verifies the recipients of each message and forwards the accepted ones to the wrapped sender, which keeps
+// its native batch endpoint
+func (s *VerifyingSender) SendBatch(ctx context.Context, messages []*Message, opts ...BatchOption) ([]BatchResult, error) {
+	results := newBatchResults(messages)
+	prepared := make([]*Message, len(messages))
+	for i, message := range messages {
+		if err := s.checkMessage(ctx, message); err != nil {
+			results[i].Err = err
+			continue
+		}
+		prepared[i] = message
+	}
+	return results, forwardBatch(ctx, s.sender, prepared, results, opts...)
+}
+
+// checkMessage checks every recipient of the message. Missing recipients are left to the validation of the sender.
+func (s *VerifyingSender) checkMessage(ctx context.Context, message *Message) error {
+	if message == nil {
+		return nil
+	}
+	for i, recipient := range message.Recipients {
+		if recipient == nil {
+			continue
+		}
+		if err := s.check(ctx, fmt.Sprintf("to[%d]", i), recipient.Address); err != nil {
+			return err
+		}
+	}
+	for i, personalization := range message.Personalizations {
+		if personalization == nil || personalization.To == nil {
+			continue
+		}
+		if err := s.check(ctx, fmt.Sprintf("personalizations[%d].to", i), personalization.To.Address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check returns the *AddressError of a rejected address as an invalid message, nil when the address passes or could
+// not be looked up
+func (s *VerifyingSender) check(ctx context.Context, field, address string) error {
+	err := s.verifier.Check(ctx, address)
+	var addressErr *AddressError
+	if !errors.As(err, &addressErr) {
+		return nil
+	}
+	addressErr.Field = field
+	return &invalidMessageError{err: addressErr}
+}
@@ -0,0 +1,203 @@
+package sendmail
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeResolver answers from static records, unknown names are not found
+type fakeResolver struct {
+	mx      map[string][]*net.MX
+	hosts   map[string][]string
+	err     error
+	lookups int
+}
+
+func (r *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	r.lookups++
+	if r.err != nil {
+		return nil, r.err
+	}
+	if records, ok := r.mx[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addresses, ok := r.hosts[host]; ok {
+		return addresses, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func newFakeResolver() *fakeResolver {
+	return &fakeResolver{
+		mx: map[string][]*net.MX{
+			"gmail.com":        {{Host: "gmail-smtp-in.l.google.com.", Pref: 5}},
+			"mac.com":          {{Host: "mx01.mail.icloud.com.", Pref: 10}},
+			"mailinator.com":   {{Host: "mail.mailinator.com.", Pref: 10}},
+			"nomail.example":   {{Host: ".", Pref: 0}},
+			"xn--bcher-kva.de": {{Host: "mx.xn--bcher-kva.de.", Pref: 10}},
+		},
+		hosts: map[string][]string{"a-only.example": {"192.0.2.1"}},
+	}
+}
+
+func TestAddressVerifier_Verify(t *testing.T) {
+	ctx := context.Background()
+	resolver := newFakeResolver()
+	verifier := NewAddressVerifier(WithResolver(resolver))
+
+	verification, err := verifier.Verify(ctx, "Jane@GMAIL.com")
+	require.NoError(t, err)
+	assert.Equal(t, &AddressVerification{Address: "Jane@gmail.com", MX: []string{"gmail-smtp-in.l.google.com"}, Deliverable: true}, verification)
+
+	verification, err = verifier.Verify(ctx, "jane@bücher.de")
+	require.NoError(t, err)
+	assert.True(t, verification.Deliverable)
+
+	verification, err = verifier.Verify(ctx, "jane@a-only.example")
+	require.NoError(t, err)
+	assert.True(t, verification.Deliverable)
+	assert.Empty(t, verification.MX)
+
+	verification, err = verifier.Verify(ctx, "jane@nomail.example")
+	require.NoError(t, err)
+	assert.False(t, verification.Deliverable)
+
+	verification, err = verifier.Verify(ctx, "jane@gmial.com")
+	require.NoError(t, err)
+	assert.False(t, verification.Deliverable)
+	assert.Equal(t, "jane@gmail.com", verification.Suggestion)
+
+	verification, err = verifier.Verify(ctx, "jane@yaho.com")
+	require.NoError(t, err)
+	assert.Equal(t, "jane@yahoo.com", verification.Suggestion)
+
+	// short domains are not matched two edits away, domains with MX records are not corrected
+	verification, err = verifier.Verify(ctx, "jane@qq.com")
+	require.NoError(t, err)
+	assert.Empty(t, verification.Suggestion)
+	verification, err = verifier.Verify(ctx, "jane@mac.com")
+	require.NoError(t, err)
+	assert.True(t, verification.Deliverable)
+	assert.Empty(t, verification.Suggestion)
+	verification, err = verifier.Verify(ctx, "jane@hotmial.con")
+	require.NoError(t, err)
+	assert.Equal(t, "jane@hotmail.com", verification.Suggestion)
+
+	verification, err = verifier.Verify(ctx, "bot@eu.mailinator.com")
+	require.NoError(t, err)
+	assert.True(t, verification.Disposable)
+
+	_, err = verifier.Verify(ctx, "jane@localhost")
+	assert.ErrorIs(t, err, ErrInvalidDomain)
+
+	lookups := resolver.lookups
+	_, err = verifier.Verify(ctx, "joe@gmail.com")
+	require.NoError(t, err)
+	assert.Equal(t, lookups, resolver.lookups, "the domain lookup is cached")
+}
+
+func TestAddressVerifier_Check(t *testing.T) {
+	ctx := context.Background()
+	verifier := NewAddressVerifier(WithResolver(newFakeResolver()))
+
+	assert.NoError(t, verifier.Check(ctx, "jane@gmail.com"))
+	err := verifier.Check(ctx, "jane@gmial.com")
+	assert.ErrorIs(t, err, ErrUndeliverableAddress)
+	assert.ErrorContains(t, err, "did you mean jane@gmail.com?")
+	assert.ErrorIs(t, verifier.Check(ctx, "bot@mailinator.com"), ErrDisposableAddress)
+
+	verifier = NewAddressVerifier(WithResolver(newFakeResolver()), WithAllowDisposable(), WithDisposableDomains("trash.example"))
+	assert.NoError(t, verifier.Check(ctx, "bot@mailinator.com"))
+
+	failing := &fakeResolver{err: errors.New("i/o timeout")}
+	verifier = NewAddressVerifier(WithResolver(failing))
+	assert.EqualError(t, verifier.Check(ctx, "jane@gmail.com"), "i/o timeout")
+}
+
+func TestAddressVerifier_CacheSize(t *testing.T) {
+	ctx := context.Background()
+	resolver := newFakeResolver()
+	verifier := NewAddressVerifier(WithResolver(resolver), WithDomainCacheSize(2))
+	now := time.Now()
+	verifier.now = func() time.Time { return now }
+
+	for i, domain := range []string{"gmail.com", "a-only.example", "nomail.example"} {
+		now = now.Add(time.Second)
+		_, err := verifier.Verify(ctx, "jane@"+domain)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(verifier.cache), 2, "lookup %d", i)
+	}
+	assert.NotContains(t, verifier.cache, "gmail.com", "the oldest lookup is dropped")
+	assert.Contains(t, verifier.cache, "nomail.example")
+
+	// expired lookups are dropped first
+	now = now.Add(domainLookupTTL)
+	_, err := verifier.Verify(ctx, "jane@gmail.com")
+	require.NoError(t, err)
+	assert.Len(t, verifier.cache, 1)
+}
+
+func TestVerifyingSender(t *testing.T) {
+	backend := &fakeSender{}
+	send := NewVerifyingSender(backend, NewAddressVerifier(WithResolver(newFakeResolver())))
+
+	message := testMessage(t)
+	message.Recipients = []*Email{{Address: "jane@gmail.com"}, {Address: "joe@gmial.com"}}
+	_, err := send.SendMessage(message)
+	var addressErr *AddressError
+	require.ErrorAs(t, err, &addressErr)
+	assert.Equal(t, "to[1]", addressErr.Field)
+	assert.ErrorIs(t, err, ErrUndeliverableAddress)
+	assert.ErrorIs(t, err, ErrInvalidMessage)
+	assert.False(t, IsProviderFailure(nil, err))
+	assert.Equal(t, 0, backend.count())
+
+	// a nil recipient is left to the validation of the wrapped sender
+	message.Recipients = []*Email{nil}
+	assert.NotPanics(t, func() { send.SendMessage(message) })
+	message.Personalizations = []*Personalization{nil}
+	assert.NotPanics(t, func() { send.SendMessage(message) })
+	message.Personalizations = nil
+	sent := backend.count()
+
+	message.Recipients = []*Email{{Address: "jane@gmail.com"}}
+	_, err = send.SendMessage(message)
+	require.NoError(t, err)
+	assert.Equal(t, sent+1, backend.count())
+
+	batch := batchMessages(t, 3)
+	for _, message := range batch {
+		message.Recipients[0].Address = "jane@gmail.com"
+	}
+	batch[1].Recipients[0].Address = "joe@gmial.com"
+	results, err := SendBatch(context.Background(), send, batch)
+	require.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	assert.ErrorIs(t, results[1].Err, ErrUndeliverableAddress)
+	assert.NoError(t, results[2].Err)
+	assert.Equal(t, sent+3, backend.count())
+
+	// lookup failures do not block sending
+	send = NewVerifyingSender(backend, NewAddressVerifier(WithResolver(&fakeResolver{err: errors.New("i/o timeout")})))
+	_, err = send.SendMail("", "shop@example.com", "", "jane@gmail.com", "subject", "text", "")
+	require.NoError(t, err)
+	assert.Equal(t, sent+4, backend.count())
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("gmail.com", "gmail.com"))
+	assert.Equal(t, 1, editDistance("gmial.com", "gmail.com"))
+	assert.Equal(t, 1, editDistance("gmail.con", "gmail.com"))
+	assert.Equal(t, 1, editDistance("gmai.com", "gmail.com"))
+	assert.Equal(t, 2, editDistance("gmaill.con", "gmail.com"))
+}
@@ -0,0 +1,214 @@
+package sendmail
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrQueueFull = errors.New("sendmail: send queue is full")
+var ErrSenderClosed = errors.New("sendmail: sender is shut down")
+
+// AsyncResult is the outcome of a message sent by an AsyncSender
+type AsyncResult struct {
+	Message  *Message
+	Response *Response
+	Err      error
+}
+
+// AsyncSender sends messages in the background. Messages are accepted into a bounded queue and sent by a pool of
+// workers, the result is delivered on the channel returned by Enqueue and to the optional result callback.
+// When the queue is full Enqueue blocks until there is room, TryEnqueue returns ErrQueueFull instead.
+type AsyncSender struct {
+	sender   SendMail
+	workers  int
+	size     int
+	onResult func(AsyncResult)
+
+	queue   chan *asyncJob
+	pending sync.WaitGroup
+
+	mu       sync.RWMutex
+	closed   bool
+	closing  chan struct{}
+	abort    chan struct{}
+	stopped  chan struct{}
+	shutdown sync.Once
+}
+
+type asyncJob struct {
+	message *Message
+	result  chan AsyncResult
+}
+
+// AsyncOption configures an AsyncSender
+type AsyncOption func(*AsyncSender)
+
+// WithWorkers sets the number of messages sent concurrently, default 4
+func WithWorkers(workers int) AsyncOption {
+	return func(a *AsyncSender) {
+		a.workers = workers
+	}
+}
+
+// WithQueueSize sets the number of messages waiting to be sent before Enqueue blocks, default 100
+func WithQueueSize(size int) AsyncOption {
+	return func(a *AsyncSender) {
+		a.size = size
+	}
+}
+
+// WithResultCallback registers a callback invoked by the worker with the result of every message
+func WithResultCallback(onResult func(AsyncResult)) AsyncOption {
+	return func(a *AsyncSender) {
+		a.onResult = onResult
+	}
+}
+
+// NewAsyncSender starts the workers sending through sender, they run until Shutdown is called
+func NewAsyncSender(sender SendMail, opts ...AsyncOption) *AsyncSender {
+	a := &AsyncSender{
+		sender:  sender,
+		workers: 4,
+		size:    100,
+		closing: make(chan struct{}),
+		abort:   make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.workers < 1 {
+		a.workers = 1
+	}
+	if a.size < 0 {
+		a.size = 0
+	}
+	a.queue = make(chan *asyncJob, a.size)
+
+	var workers sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < a.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			a.work(stop)
+		}()
+	}
+	go func() {
+		<-a.closing
+		a.pending.Wait()
+		close(stop)
+		workers.Wait()
+		close(a.stopped)
+	}()
+	return a
+}
+
+// Enqueue queues the message, blocking while the queue is full until the context is done.
+// The returned channel receives the result once the message has been sent.
+func (a *AsyncSender) Enqueue(ctx context.Context, message *Message) (<-chan AsyncResult, error) {
+	job, err := a.accept(message)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case a.queue <- job:
+		return job.result, nil
+	case <-ctx.Done():
+		a.pending.Done()
+		return nil, ctx.Err()
+	case <-a.closing:
+		a.pending.Done()
+		return nil, ErrSenderClosed
+	}
+}
+
+// TryEnqueue queues the message without blocking, returning ErrQueueFull when there is no room
+func (a *AsyncSender) TryEnqueue(message *Message) (<-chan AsyncResult, error) {
+	job, err := a.accept(message)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case a.queue <- job:
+		return job.result, nil
+	default:
+		a.pending.Done()
+		return nil, ErrQueueFull
+	}
+}
+
+// Len returns the number of messages waiting in the queue
+func (a *AsyncSender) Len() int {
+	return len(a.queue)
+}
+
+// Shutdown stops accepting messages and waits for the queued and in-flight messages to be sent.
+// If the context is done first, messages still queued are failed with ErrSenderClosed and the context error is returned,
+// sends already in progress are left to complete in the background.
+func (a *AsyncSender) Shutdown(ctx context.Context) error {
+	a.shutdown.Do(func() {
+		a.mu.Lock()
+		a.closed = true
+		a.mu.Unlock()
+		close(a.closing)
+	})
+
+	select {
+	case <-a.stopped:
+		return nil
+	case <-ctx.Done():
+		a.mu.Lock()
+		select {
+		case <-a.abort:
+		default:
+			close(a.abort)
+		}
+		a.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// accept validates the message and registers it as pending, so Shutdown waits for it.
+// A missing from address is left to the WithDefaultFrom of the wrapped sender.
+func (a *AsyncSender) accept(message *Message) (*asyncJob, error) {
+	if err := message.validateDeferred(); err != nil {
+		return nil, err
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return nil, ErrSenderClosed
+	}
+	a.pending.Add(1)
+	return &asyncJob{message: message, result: make(chan AsyncResult, 1)}, nil
+}
+
+func (a *AsyncSender) work(stop <-chan struct{}) {
+	for {
+		select {
+		case job := <-a.queue:
+			a.send(job)
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (a *AsyncSender) send(job *asyncJob) {
+	defer a.pending.Done()
+
+	result := AsyncResult{Message: job.message}
+	select {
+	case <-a.abort:
+		result.Err = ErrSenderClosed
+	default:
+		result.Response, result.Err = a.sender.SendMessage(job.message)
+	}
+
+	job.result <- result
+	if a.onResult != nil {
+		a.onResult(result)
+	}
+}
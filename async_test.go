@@ -0,0 +1,136 @@
+package sendmail
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsyncSender_SendsAndReportsResults(t *testing.T) {
+	backend := &fakeSender{}
+	var callbacks atomic.Int32
+	async := NewAsyncSender(backend, WithWorkers(3), WithResultCallback(func(result AsyncResult) {
+		callbacks.Add(1)
+	}))
+
+	var results []<-chan AsyncResult
+	for i := 0; i < 10; i++ {
+		result, err := async.Enqueue(context.Background(), testMessage(t))
+		require.NoError(t, err)
+		results = append(results, result)
+	}
+	for _, result := range results {
+		r := <-result
+		assert.NoError(t, r.Err)
+		assert.Equal(t, 200, r.Response.StatusCode)
+	}
+
+	require.NoError(t, async.Shutdown(context.Background()))
+	assert.Equal(t, 10, backend.count())
+	assert.Equal(t, int32(10), callbacks.Load())
+
+	_, err := async.Enqueue(context.Background(), testMessage(t))
+	assert.ErrorIs(t, err, ErrSenderClosed)
+}
+
+func TestAsyncSender_Backpressure(t *testing.T) {
+	release := make(chan struct{})
+	backend := &fakeSender{sendFunc: func(message *Message) (*Response, error) {
+		<-release
+		return &Response{StatusCode: 202}, nil
+	}}
+	async := NewAsyncSender(backend, WithWorkers(1), WithQueueSize(1))
+
+	// one message in flight, one queued
+	_, err := async.Enqueue(context.Background(), testMessage(t))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return backend.count() == 1 }, time.Second, time.Millisecond)
+	_, err = async.TryEnqueue(testMessage(t))
+	require.NoError(t, err)
+
+	_, err = async.TryEnqueue(testMessage(t))
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = async.Enqueue(ctx, testMessage(t))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+	require.NoError(t, async.Shutdown(context.Background()))
+	assert.Equal(t, 2, backend.count())
+}
+
+func TestAsyncSender_ShutdownDrainsQueue(t *testing.T) {
+	backend := &fakeSender{sendFunc: func(message *Message) (*Response, error) {
+		time.Sleep(5 * time.Millisecond)
+		return nil, errors.New("rejected")
+	}}
+	async := NewAsyncSender(backend, WithWorkers(2), WithQueueSize(10))
+
+	var results []<-chan AsyncResult
+	for i := 0; i < 6; i++ {
+		result, err := async.TryEnqueue(testMessage(t))
+		require.NoError(t, err)
+		results = append(results, result)
+	}
+
+	require.NoError(t, async.Shutdown(context.Background()))
+	assert.Equal(t, 6, backend.count())
+	for _, result := range results {
+		assert.EqualError(t, (<-result).Err, "rejected")
+	}
+}
+
+func TestAsyncSender_ShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	backend := &fakeSender{sendFunc: func(message *Message) (*Response, error) {
+		<-release
+		return &Response{StatusCode: 202}, nil
+	}}
+	async := NewAsyncSender(backend, WithWorkers(1), WithQueueSize(5))
+
+	first, err := async.Enqueue(context.Background(), testMessage(t))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return backend.count() == 1 }, time.Second, time.Millisecond)
+	queued, err := async.Enqueue(context.Background(), testMessage(t))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, async.Shutdown(ctx), context.DeadlineExceeded)
+
+	// the in-flight send completes, the queued message is abandoned
+	close(release)
+	assert.NoError(t, (<-first).Err)
+	assert.ErrorIs(t, (<-queued).Err, ErrSenderClosed)
+	assert.Equal(t, 1, backend.count())
+	require.NoError(t, async.Shutdown(context.Background()))
+}
+
+func TestAsyncSender_RejectsInvalidMessage(t *testing.T) {
+	async := NewAsyncSender(&fakeSender{})
+	defer async.Shutdown(context.Background())
+
+	_, err := async.Enqueue(context.Background(), &Message{})
+	assert.ErrorIs(t, err, ErrMissingRecipients)
+	assert.ErrorIs(t, err, ErrInvalidMessage)
+}
+
+func TestAsyncSender_DefaultFrom(t *testing.T) {
+	fileSender, err := NewFileSender(t.TempDir(), WithDefaultFrom("Sender", "sender@example.com"))
+	require.NoError(t, err)
+	async := NewAsyncSender(fileSender)
+	defer async.Shutdown(context.Background())
+
+	message := testMessage(t)
+	message.FromEmail = nil
+	result, err := async.Enqueue(context.Background(), message)
+	require.NoError(t, err)
+	assert.NoError(t, (<-result).Err)
+}
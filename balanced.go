@@ -0,0 +1,241 @@
+package sendmail
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+var ErrNoBackendAvailable = errors.New("sendmail: no backend available")
+
+// BalancedBackend is a sender taking part in load balancing. Traffic is spread in proportion to Weight.
+type BalancedBackend struct {
+	Name   string
+	Sender SendMail
+	Weight int
+}
+
+// BackendStatus reports the health of a balanced backend
+type BackendStatus struct {
+	Name                string
+	Weight              int
+	Healthy             bool
+	ConsecutiveFailures int
+	EjectedUntil        time.Time
+}
+
+// BalancedSender spreads messages across several senders by weight. A backend failing FailureThreshold times in a row
+// is ejected for the probe interval, after which a single message is let through as a probe: success re-admits the
+// backend, failure ejects it again. A message that fails on one backend is retried on the remaining healthy backends.
+// Failures are classified with IsProviderFailure, a message rejected for its content is returned at once and does not
+// count against the backend.
+type BalancedSender struct {
+	mu               sync.Mutex
+	backends         []*balancedBackend
+	failureThreshold int
+	probeInterval    time.Duration
+	logger           func(string, ...interface{})
+	now              func() time.Time
+}
+
+type balancedBackend struct {
+	BalancedBackend
+	failures     int
+	ejectedUntil time.Time
+	probing      bool
+}
+
+// BalancedOption configures a BalancedSender
+type BalancedOption func(*BalancedSender)
+
+// WithFailureThreshold sets the number of consecutive failures after which a backend is ejected, default 3
+func WithFailureThreshold(failures int) BalancedOption {
+	return func(b *BalancedSender) {
+		b.failureThreshold = failures
+	}
+}
+
+// WithProbeInterval sets how long a backend stays ejected before it is probed, default 30 seconds
+func WithProbeInterval(interval time.Duration) BalancedOption {
+	return func(b *BalancedSender) {
+		b.probeInterval = interval
+	}
+}
+
+// WithBalancedLogger overrides the default log.Printf logging of ejections and re-admissions
+func WithBalancedLogger(logger func(string, ...interface{})) BalancedOption {
+	return func(b *BalancedSender) {
+		b.logger = logger
+	}
+}
+
+// NewBalancedSender creates a BalancedSender. Backends with a weight of zero or less are rejected.
+func NewBalancedSender(backends []BalancedBackend, opts ...BalancedOption) (*BalancedSender, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("sendmail: balanced sender requires at least one backend")
+	}
+
+	balanced := &BalancedSender{
+		failureThreshold: 3,
+		probeInterval:    30 * time.Second,
+		now:              time.Now,
+	}
+	for i, backend := range backends {
+		if backend.Sender == nil {
+			return nil, fmt.Errorf("sendmail: balanced backend %d has no sender", i)
+		}
+		if backend.Weight <= 0 {
+			return nil, fmt.Errorf("sendmail: balanced backend %q requires a positive weight", backend.Name)
+		}
+		if backend.Name == "" {
+			backend.Name = fmt.Sprintf("backend-%d", i)
+		}
+		balanced.backends = append(balanced.backends, &balancedBackend{BalancedBackend: backend})
+	}
+	for _, opt := range opts {
+		opt(balanced)
+	}
+	if balanced.failureThreshold < 1 {
+		balanced.failureThreshold = 1
+	}
+	return balanced, nil
+}
+
+func (b *BalancedSender) SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent string) (*Response, error) {
+	return b.send(func(sender SendMail) (*Response, error) {
+		return sender.SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent)
+	})
+}
+
+func (b *BalancedSender) SendMessage(message *Message) (*Response, error) {
+	return b.send(func(sender SendMail) (*Response, error) {
+		return sender.SendMessage(message)
+	})
+}
+
+// Status returns the health of each backend, in the order they were configured
+func (b *BalancedSender) Status() []BackendStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	status := make([]BackendStatus, 0, len(b.backends))
+	for _, backend := range b.backends {
+		status = append(status, BackendStatus{
+			Name:                backend.Name,
+			Weight:              backend.Weight,
+			Healthy:             !now.Before(backend.ejectedUntil),
+			ConsecutiveFailures: backend.failures,
+			EjectedUntil:        backend.ejectedUntil,
+		})
+	}
+	return status
+}
+
+func (b *BalancedSender) send(send func(SendMail) (*Response, error)) (*Response, error) {
+	tried := map[*balancedBackend]bool{}
+	var lastResponse *Response
+	var lastErr error
+
+	for {
+		backend := b.pick(tried)
+		if backend == nil {
+			if lastErr == nil {
+				lastErr = ErrNoBackendAvailable
+			}
+			return lastResponse, lastErr
+		}
+		tried[backend] = true
+
+		response, err := send(backend.Sender)
+		failure := IsProviderFailure(response, err)
+		b.record(backend, err, failure)
+		if err == nil {
+			return response, nil
+		}
+		if !failure {
+			// the message itself was rejected, it fails on every backend and says nothing about their health
+			return response, fmt.Errorf("%s: %w", backend.Name, err)
+		}
+		lastResponse, lastErr = response, fmt.Errorf("%s: %w", backend.Name, err)
+	}
+}
+
+// pick selects an available backend at random, in proportion to the weights.
+// An ejected backend whose probe interval has passed is available to a single caller at a time.
+func (b *BalancedSender) pick(exclude map[*balancedBackend]bool) *balancedBackend {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	total := 0
+	candidates := make([]*balancedBackend, 0, len(b.backends))
+	for _, backend := range b.backends {
+		if exclude[backend] || now.Before(backend.ejectedUntil) {
+			continue
+		}
+		if backend.failures >= b.failureThreshold && backend.probing {
+			continue
+		}
+		candidates = append(candidates, backend)
+		total += backend.Weight
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	choice := rand.IntN(total)
+	for _, backend := range candidates {
+		if choice < backend.Weight {
+			if backend.failures >= b.failureThreshold {
+				backend.probing = true
+			}
+			return backend
+		}
+		choice -= backend.Weight
+	}
+	return candidates[len(candidates)-1]
+}
+
+// record updates the health of the backend, failure tells whether err is classified by IsProviderFailure
+func (b *BalancedSender) record(backend *balancedBackend, err error, failure bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasProbe := backend.probing
+	backend.probing = false
+	if err != nil && !failure {
+		return
+	}
+
+	if err == nil {
+		if backend.failures >= b.failureThreshold {
+			b.logf("Backend re-admitted: name=%s", backend.Name)
+		}
+		backend.failures = 0
+		backend.ejectedUntil = time.Time{}
+		return
+	}
+
+	backend.failures++
+	if backend.failures >= b.failureThreshold {
+		backend.ejectedUntil = b.now().Add(b.probeInterval)
+		if wasProbe {
+			b.logf("Backend probe failed: name=%s, error=%v", backend.Name, err)
+		} else {
+			b.logf("Backend ejected: name=%s, failures=%d, error=%v", backend.Name, backend.failures, err)
+		}
+	}
+}
+
+// logf logs message either via defined user logger or via system one if no user logger is defined.
+func (b *BalancedSender) logf(f string, args ...interface{}) {
+	if b.logger != nil {
+		b.logger(f, args...)
+	} else {
+		log.Printf(f, args...)
+	}
+}
@@ -0,0 +1,111 @@
+package sendmail
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBalancedSender_Invalid(t *testing.T) {
+	_, err := NewBalancedSender(nil)
+	assert.Error(t, err)
+
+	_, err = NewBalancedSender([]BalancedBackend{{Name: "a", Sender: &fakeSender{}, Weight: 0}})
+	assert.Error(t, err)
+}
+
+func TestBalancedSender_Weights(t *testing.T) {
+	heavy, light := &fakeSender{}, &fakeSender{}
+	balanced, err := NewBalancedSender([]BalancedBackend{
+		{Name: "heavy", Sender: heavy, Weight: 9},
+		{Name: "light", Sender: light, Weight: 1},
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 1000; i++ {
+		_, err := balanced.SendMessage(testMessage(t))
+		require.NoError(t, err)
+	}
+	assert.InDelta(t, 900, heavy.count(), 60)
+	assert.Equal(t, 1000, heavy.count()+light.count())
+}
+
+func TestBalancedSender_EjectAndProbe(t *testing.T) {
+	failing, healthy := &fakeSender{err: errors.New("unavailable")}, &fakeSender{}
+	now := time.Now()
+	balanced, err := NewBalancedSender([]BalancedBackend{
+		{Name: "failing", Sender: failing, Weight: 1},
+		{Name: "healthy", Sender: healthy, Weight: 1},
+	}, WithFailureThreshold(2), WithProbeInterval(time.Minute), WithBalancedLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+	balanced.now = func() time.Time { return now }
+
+	// failures are retried on the healthy backend, so every send succeeds
+	for i := 0; i < 50; i++ {
+		_, err := balanced.SendMessage(testMessage(t))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, failing.count())
+	assert.Equal(t, 50, healthy.count())
+
+	status := balanced.Status()
+	assert.False(t, status[0].Healthy)
+	assert.True(t, status[1].Healthy)
+
+	// after the probe interval a successful probe re-admits the backend
+	failing.setErr(nil)
+	now = now.Add(2 * time.Minute)
+	for i := 0; i < 50; i++ {
+		_, err := balanced.SendMessage(testMessage(t))
+		require.NoError(t, err)
+	}
+	assert.Greater(t, failing.count(), 2)
+	assert.True(t, balanced.Status()[0].Healthy)
+	assert.Equal(t, 0, balanced.Status()[0].ConsecutiveFailures)
+}
+
+func TestBalancedSender_AllFailing(t *testing.T) {
+	balanced, err := NewBalancedSender([]BalancedBackend{
+		{Name: "a", Sender: &fakeSender{err: errors.New("a down")}, Weight: 1},
+		{Name: "b", Sender: &fakeSender{err: errors.New("b down")}, Weight: 1},
+	}, WithFailureThreshold(1), WithBalancedLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+
+	_, err = balanced.SendMessage(testMessage(t))
+	assert.ErrorContains(t, err, "down")
+
+	_, err = balanced.SendMessage(testMessage(t))
+	assert.ErrorIs(t, err, ErrNoBackendAvailable)
+}
+
+func TestBalancedSender_InvalidMessage(t *testing.T) {
+	rejecting := func(message *Message) (*Response, error) {
+		if message.FromEmail == nil {
+			return nil, ErrMissingFrom
+		}
+		return &Response{StatusCode: 400}, &ProviderError{StatusCode: 400, Err: errors.New("bad request")}
+	}
+	a, b := &fakeSender{sendFunc: rejecting}, &fakeSender{sendFunc: rejecting}
+	balanced, err := NewBalancedSender([]BalancedBackend{
+		{Name: "a", Sender: a, Weight: 1},
+		{Name: "b", Sender: b, Weight: 1},
+	}, WithFailureThreshold(1), WithBalancedLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+
+	// validation is left to the backends, which may apply a default from address
+	_, err = balanced.SendMessage(&Message{})
+	assert.ErrorIs(t, err, ErrMissingFrom)
+	_, err = balanced.SendMessage(testMessage(t))
+	var providerErr *ProviderError
+	assert.ErrorAs(t, err, &providerErr)
+
+	// neither rejection is retried on the other backend or counts against its health
+	assert.Equal(t, 2, a.count()+b.count())
+	for _, status := range balanced.Status() {
+		assert.True(t, status.Healthy)
+		assert.Zero(t, status.ConsecutiveFailures)
+	}
+}
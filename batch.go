@@ -0,0 +1,157 @@
+package sendmail
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchResult is the outcome of a single message of a batch
+type BatchResult struct {
+	Message  *Message
+	Response *Response
+	Err      error
+}
+
+// BatchSender is implemented by senders with a native batch endpoint, MailJet and MailTrap, and by the decorators
+// RateLimitedSender, CircuitBreaker, IdempotentSender, SuppressingSender and VerifyingSender, which forward the batch
+type BatchSender interface {
+	// SendBatch sends distinct messages, results are returned in the order of the messages.
+	// A failed message is reported in its result, the returned error is only set when the context is done.
+	// Decorators pass the options on to the sender they wrap.
+	SendBatch(ctx context.Context, messages []*Message, opts ...BatchOption) ([]BatchResult, error)
+}
+
+// BatchOption configures SendBatch
+type BatchOption func(*batchOptions)
+
+type batchOptions struct {
+	concurrency int
+}
+
+// WithBatchConcurrency sets the number of messages sent at the same time by senders without a batch endpoint, default 10
+func WithBatchConcurrency(concurrency int) BatchOption {
+	return func(o *batchOptions) {
+		o.concurrency = concurrency
+	}
+}
+
+// SendBatch sends the messages through the native batch endpoint of sender when it implements BatchSender,
+// otherwise the messages are sent individually and concurrently. The options are passed to a BatchSender, so they reach
+// a sender without a batch endpoint wrapped by a decorator.
+// Results are returned in the order of the messages, messages not sent because the context is done report its error.
+func SendBatch(ctx context.Context, sender SendMail, messages []*Message, opts ...BatchOption) ([]BatchResult, error) {
+	if batch, ok := sender.(BatchSender); ok {
+		return batch.SendBatch(ctx, messages, opts...)
+	}
+
+	o := &batchOptions{concurrency: 10}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.concurrency < 1 {
+		o.concurrency = 1
+	}
+
+	results := newBatchResults(messages)
+	semaphore := make(chan struct{}, o.concurrency)
+	var wg sync.WaitGroup
+	var err error
+	for i, message := range messages {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err = ctx.Err(); err != nil {
+			for j := i; j < len(results); j++ {
+				results[j].Err = err
+			}
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			results[i].Response, results[i].Err = sender.SendMessage(message)
+		}()
+	}
+	wg.Wait()
+	return results, err
+}
+
+func newBatchResults(messages []*Message) []BatchResult {
+	results := make([]BatchResult, len(messages))
+	for i, message := range messages {
+		results[i].Message = message
+	}
+	return results
+}
+
+// chunkBatch prepares and validates the messages, reporting invalid ones in their results. Personalized messages are
+// expanded by the sender's SendMessage and sent right away.
+// It returns the prepared messages and the indexes of the remaining ones split into chunks of at most size.
+func chunkBatch(sender SendMail, messages []*Message, results []BatchResult, size int, prepare func(*Message) *Message) ([]*Message, [][]int) {
+	prepared := make([]*Message, len(messages))
+	var chunks [][]int
+	var chunk []int
+	for i, message := range messages {
+		prepared[i] = prepare(message)
+		if err := prepared[i].Validate(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		if len(prepared[i].Personalizations) > 0 {
+			results[i].Response, results[i].Err = sender.SendMessage(prepared[i])
+			continue
+		}
+		chunk = append(chunk, i)
+		if len(chunk) == size {
+			chunks = append(chunks, chunk)
+			chunk = nil
+		}
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return prepared, chunks
+}
+
+// sendChunks sends the chunks in turn, failing the chunks not sent once the context is done
+func sendChunks(ctx context.Context, chunks [][]int, results []BatchResult, send func(chunk []int)) error {
+	for i, chunk := range chunks {
+		if err := ctx.Err(); err != nil {
+			for _, remaining := range chunks[i:] {
+				for _, index := range remaining {
+					results[index].Err = err
+				}
+			}
+			return err
+		}
+		send(chunk)
+	}
+	return nil
+}
+
+// forwardBatch is the SendBatch of the decorators. The messages left in prepared are sent with a single SendBatch
+// of sender, with the options of the batch, keeping the native batching of the wrapped sender, and their results
+// stored at the index of the message.
+// A nil prepared message was handled by the decorator, which filled its result.
+func forwardBatch(ctx context.Context, sender SendMail, prepared []*Message, results []BatchResult, opts ...BatchOption) error {
+	var indexes []int
+	var batch []*Message
+	for i, message := range prepared {
+		if message != nil {
+			indexes = append(indexes, i)
+			batch = append(batch, message)
+		}
+	}
+	if len(batch) == 0 {
+		return ctx.Err()
+	}
+	sent, err := SendBatch(ctx, sender, batch, opts...)
+	for i, index := range indexes {
+		results[index].Response, results[index].Err = sent[i].Response, sent[i].Err
+	}
+	return err
+}
@@ -0,0 +1,273 @@
+package sendmail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func batchMessages(t *testing.T, n int) []*Message {
+	messages := make([]*Message, n)
+	for i := range messages {
+		messages[i] = testMessage(t)
+		messages[i].Subject = fmt.Sprintf("message %d", i)
+	}
+	return messages
+}
+
+func TestSendBatch_Fallback(t *testing.T) {
+	backend := &fakeSender{sendFunc: func(message *Message) (*Response, error) {
+		if message.Subject == "message 3" {
+			return nil, errors.New("rejected")
+		}
+		return &Response{StatusCode: 202, Body: message.Subject}, nil
+	}}
+
+	messages := batchMessages(t, 10)
+	results, err := SendBatch(context.Background(), backend, messages, WithBatchConcurrency(3))
+	require.NoError(t, err)
+	require.Len(t, results, 10)
+	for i, result := range results {
+		assert.Same(t, messages[i], result.Message)
+		if i == 3 {
+			assert.EqualError(t, result.Err, "rejected")
+			continue
+		}
+		require.NoError(t, result.Err)
+		assert.Equal(t, messages[i].Subject, result.Response.Body)
+	}
+	assert.Equal(t, 10, backend.count())
+}
+
+func TestSendBatch_FallbackCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var sent atomic.Int32
+	backend := &fakeSender{sendFunc: func(message *Message) (*Response, error) {
+		if sent.Add(1) == 2 {
+			cancel()
+		}
+		return &Response{StatusCode: 202}, nil
+	}}
+
+	results, err := SendBatch(ctx, backend, batchMessages(t, 10), WithBatchConcurrency(1))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NoError(t, results[0].Err)
+	assert.ErrorIs(t, results[9].Err, context.Canceled)
+}
+
+func TestSendBatch_DecoratorOptions(t *testing.T) {
+	var running, peak atomic.Int32
+	backend := &fakeSender{sendFunc: func(message *Message) (*Response, error) {
+		current := running.Add(1)
+		defer running.Add(-1)
+		for {
+			seen := peak.Load()
+			if current <= seen || peak.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return &Response{StatusCode: 202}, nil
+	}}
+
+	// the concurrency reaches the sender wrapped by the decorators
+	stacked := NewCircuitBreaker("fake", NewRateLimitedSender(NewIdempotentSender(backend, nil)))
+	results, err := SendBatch(context.Background(), stacked, batchMessages(t, 6), WithBatchConcurrency(1))
+	require.NoError(t, err)
+	for _, result := range results {
+		require.NoError(t, result.Err)
+	}
+	assert.Equal(t, int32(1), peak.Load())
+}
+
+func TestMailTrap_SendBatch(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		assert.Equal(t, "/api/batch", r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+		payload := struct {
+			Requests []map[string]interface{} `json:"requests"`
+		}{}
+		require.NoError(t, json.Unmarshal(body, &payload))
+
+		responses := []string{}
+		for _, request := range payload.Requests {
+			assert.NotContains(t, request, "idempotency_key")
+			if request["subject"] == "message 1" {
+				responses = append(responses, `{"success":false,"errors":["'to' address is invalid"]}`)
+			} else {
+				responses = append(responses, fmt.Sprintf(`{"success":true,"message_ids":["id-%s"]}`, request["subject"]))
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"success":true,"responses":[%s]}`, strings.Join(responses, ","))
+	}))
+	defer server.Close()
+
+	send, err := NewMailTrap("token", WithBaseURL(server.URL), WithLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+
+	messages := batchMessages(t, 3)
+	messages[0].IdempotencyKey = "key"
+	invalid := testMessage(t)
+	invalid.Recipients = nil
+	messages = append(messages, invalid)
+
+	results, err := send.SendBatch(context.Background(), messages)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+
+	require.NoError(t, results[0].Err)
+	assert.Equal(t, []string{"id-message 0"}, results[0].Response.Headers["X-Message-Id"])
+	assert.EqualError(t, results[1].Err, "Mailtrap API error: 'to' address is invalid")
+	require.NoError(t, results[2].Err)
+	assert.ErrorIs(t, results[3].Err, ErrMissingRecipients)
+
+	// SendBatch uses the native endpoint
+	results, err = SendBatch(context.Background(), send, messages[:1])
+	require.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestMailJet_SendBatch(t *testing.T) {
+	var sizes []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v3.1/send", r.URL.Path)
+		payload := struct {
+			Messages []map[string]interface{}
+		}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		sizes = append(sizes, len(payload.Messages))
+
+		results := []string{}
+		for i := range payload.Messages {
+			results = append(results, fmt.Sprintf(`{"Status":"success","To":[{"Email":"recipient@example.com","MessageUUID":"uuid-%d"}]}`, i))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"Messages":[%s]}`, strings.Join(results, ","))
+	}))
+	defer server.Close()
+
+	send, err := NewMailJet("key", "secret", WithBaseURL(server.URL), WithLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+
+	results, err := send.SendBatch(context.Background(), batchMessages(t, 120))
+	require.NoError(t, err)
+	assert.Equal(t, []int{50, 50, 20}, sizes)
+	require.Len(t, results, 120)
+	for _, result := range results {
+		assert.NoError(t, result.Err)
+	}
+	assert.Equal(t, []string{"uuid-1"}, results[51].Response.Headers["X-Message-Id"])
+}
+
+func TestMailJet_SendBatchValidationErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"Messages":[{"Status":"success","Errors":[]},{"Status":"error","Errors":[{"ErrorCode":"send-0003","StatusCode":400,"ErrorMessage":"At least \"HTMLPart\", \"TextPart\" or \"TemplateID\" must be provided."}]}]}`))
+	}))
+	defer server.Close()
+
+	send, err := NewMailJet("key", "secret", WithBaseURL(server.URL), WithLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+
+	results, err := send.SendBatch(context.Background(), batchMessages(t, 2))
+	require.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, 400, results[1].Response.StatusCode)
+	assert.ErrorContains(t, results[1].Err, "HTMLPart")
+}
+
+func TestDecorators_SendBatch(t *testing.T) {
+	var calls atomic.Int32
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		payload := struct {
+			Requests []map[string]interface{} `json:"requests"`
+		}{}
+		require.NoError(t, json.Unmarshal(body, &payload))
+		responses := []string{}
+		for _, request := range payload.Requests {
+			responses = append(responses, fmt.Sprintf(`{"success":true,"message_ids":["id-%s"]}`, request["subject"]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(int(status.Load()))
+		fmt.Fprintf(w, `{"success":true,"responses":[%s]}`, strings.Join(responses, ","))
+	}))
+	defer server.Close()
+	mailTrap, err := NewMailTrap("token", WithBaseURL(server.URL), WithLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	list := NewMemorySuppressionList()
+	require.NoError(t, list.Suppress(ctx, &Suppression{Address: "blocked@example.com", Reason: SuppressionHardBounce}))
+	stacked := NewCircuitBreaker("mailtrap", NewRateLimitedSender(NewIdempotentSender(NewSuppressingSender(mailTrap, list), nil)))
+
+	decorators := map[string]SendMail{
+		"rate limited":    NewRateLimitedSender(mailTrap, WithRatePerSecond(100, 10)),
+		"circuit breaker": NewCircuitBreaker("mailtrap", mailTrap),
+		"idempotent":      NewIdempotentSender(mailTrap, nil),
+		"suppressing":     NewSuppressingSender(mailTrap, list),
+		"stacked":         stacked,
+	}
+	for name, decorator := range decorators {
+		t.Run(name, func(t *testing.T) {
+			require.Implements(t, (*BatchSender)(nil), decorator)
+			calls.Store(0)
+			results, err := SendBatch(ctx, decorator, batchMessages(t, 5))
+			require.NoError(t, err)
+			assert.Equal(t, int32(1), calls.Load(), "the messages are sent with a single batch call")
+			for i, result := range results {
+				require.NoError(t, result.Err)
+				assert.Equal(t, []string{fmt.Sprintf("id-message %d", i)}, result.Response.Headers["X-Message-Id"])
+			}
+		})
+	}
+
+	// keys already sent, or repeated in the batch, are replayed, suppressed recipients are not sent
+	messages := batchMessages(t, 4)
+	messages[0].IdempotencyKey = "first"
+	messages[1].IdempotencyKey = "first"
+	messages[2].Recipients[0].Address = "blocked@example.com"
+	calls.Store(0)
+	results, err := SendBatch(ctx, stacked, messages)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+	require.NoError(t, results[0].Err)
+	assert.Equal(t, []string{"true"}, results[1].Response.Headers["Idempotent-Replayed"])
+	assert.ErrorIs(t, results[2].Err, ErrRecipientSuppressed)
+	require.NoError(t, results[3].Err)
+
+	results, err = SendBatch(ctx, stacked, messages[:2])
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, []string{"true"}, results[0].Response.Headers["Idempotent-Replayed"])
+
+	// a failed batch call counts as one failure of the breaker
+	breaker := NewCircuitBreaker("mailtrap", mailTrap, WithBreakerFailureThreshold(1))
+	status.Store(http.StatusBadGateway)
+	results, err = SendBatch(ctx, breaker, batchMessages(t, 2))
+	require.NoError(t, err)
+	assert.Error(t, results[0].Err)
+	assert.Equal(t, BreakerOpen, breaker.State())
+	results, err = SendBatch(ctx, breaker, batchMessages(t, 2))
+	require.NoError(t, err)
+	assert.ErrorIs(t, results[1].Err, ErrCircuitOpen)
+}
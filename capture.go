@@ -0,0 +1,293 @@
+// Development mode for the SMTP transport. Mail is delivered to a local capture server
+// (Mailpit, MailHog, smtp4dev) and can be read back via the Mailpit or MailHog HTTP API.
+package sendmail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CaptureFlavor selects the HTTP API used to read captured messages back
+type CaptureFlavor int
+
+const (
+	// CaptureMailpit reads messages via the Mailpit /api/v1 API
+	CaptureMailpit CaptureFlavor = iota
+	// CaptureMailHog reads messages via the MailHog /api/v1 and /api/v2 API
+	CaptureMailHog
+)
+
+// CapturedMessage is a message read back from a capture server
+type CapturedMessage struct {
+	ID          string
+	MessageID   string
+	From        *Email
+	To          []*Email
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []*Attachment
+	Created     time.Time
+}
+
+// CaptureServer sends via SMTP to a local capture server and reads the captured messages back.
+// The embedded SMTPMail provides the SendMail interface, so the real SendMessage path is exercised.
+type CaptureServer struct {
+	*SMTPMail
+	APIURL string
+	Flavor CaptureFlavor
+
+	client *http.Client
+}
+
+// NewMailpit creates a CaptureServer for Mailpit, defaulting to localhost:1025 and http://localhost:8025
+func NewMailpit(smtpAddr, apiURL string, opts ...Option) (*CaptureServer, error) {
+	return newCaptureServer(CaptureMailpit, smtpAddr, apiURL, opts)
+}
+
+// NewMailHog creates a CaptureServer for MailHog, defaulting to localhost:1025 and http://localhost:8025
+func NewMailHog(smtpAddr, apiURL string, opts ...Option) (*CaptureServer, error) {
+	return newCaptureServer(CaptureMailHog, smtpAddr, apiURL, opts)
+}
+
+func newCaptureServer(flavor CaptureFlavor, smtpAddr, apiURL string, opts []Option) (*CaptureServer, error) {
+	if smtpAddr == "" {
+		smtpAddr = "localhost:1025"
+	}
+	if apiURL == "" {
+		apiURL = "http://localhost:8025"
+	}
+
+	host, portStr, err := net.SplitHostPort(smtpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid capture server SMTP address: %w", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid capture server SMTP port: %w", err)
+	}
+
+	// capture servers generally do not offer TLS
+	smtpMail, err := NewSMTP(host, port, "", "", append([]Option{WithoutSMTPStartTLS()}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	manager := &CaptureServer{
+		SMTPMail: smtpMail,
+		APIURL:   strings.TrimRight(apiURL, "/"),
+		Flavor:   flavor,
+		client:   smtpMail.options.client(10*time.Second, false),
+	}
+	return manager, nil
+}
+
+// Messages returns the captured messages, newest first. Only the summary fields are populated by Mailpit,
+// use Message to read the content.
+func (c *CaptureServer) Messages() ([]*CapturedMessage, error) {
+	if c.Flavor == CaptureMailHog {
+		var result struct {
+			Items []mailHogMessage `json:"items"`
+		}
+		if err := c.get("/api/v2/messages", &result); err != nil {
+			return nil, err
+		}
+		messages := make([]*CapturedMessage, 0, len(result.Items))
+		for _, item := range result.Items {
+			message, err := item.captured()
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, message)
+		}
+		return messages, nil
+	}
+
+	var result struct {
+		Messages []mailpitMessage `json:"messages"`
+	}
+	if err := c.get("/api/v1/messages", &result); err != nil {
+		return nil, err
+	}
+	messages := make([]*CapturedMessage, 0, len(result.Messages))
+	for _, item := range result.Messages {
+		messages = append(messages, item.captured())
+	}
+	return messages, nil
+}
+
+// Message returns a single captured message including its content
+func (c *CaptureServer) Message(id string) (*CapturedMessage, error) {
+	if c.Flavor == CaptureMailHog {
+		var item mailHogMessage
+		if err := c.get("/api/v1/messages/"+id, &item); err != nil {
+			return nil, err
+		}
+		return item.captured()
+	}
+
+	var item mailpitMessage
+	if err := c.get("/api/v1/message/"+id, &item); err != nil {
+		return nil, err
+	}
+	return item.captured(), nil
+}
+
+// DeleteAll removes all captured messages
+func (c *CaptureServer) DeleteAll() error {
+	request, err := http.NewRequest(http.MethodDelete, c.APIURL+"/api/v1/messages", nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("Capture API error: status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// WaitForMessage polls the capture server until a message matches, or the context is done.
+// The matched message is returned with its content.
+func (c *CaptureServer) WaitForMessage(ctx context.Context, match func(*CapturedMessage) bool) (*CapturedMessage, error) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		messages, err := c.Messages()
+		if err != nil {
+			return nil, err
+		}
+		for _, summary := range messages {
+			message, err := c.Message(summary.ID)
+			if err != nil {
+				return nil, err
+			}
+			if match(message) {
+				return message, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *CaptureServer) get(path string, result interface{}) error {
+	res, err := c.client.Get(c.APIURL + path)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Capture API error: status code %d, body: %s", res.StatusCode, string(body))
+	}
+	return json.Unmarshal(body, result)
+}
+
+type mailpitAddress struct {
+	Name    string `json:"Name"`
+	Address string `json:"Address"`
+}
+
+type mailpitMessage struct {
+	ID        string           `json:"ID"`
+	MessageID string           `json:"MessageID"`
+	From      *mailpitAddress  `json:"From"`
+	To        []mailpitAddress `json:"To"`
+	Subject   string           `json:"Subject"`
+	Text      string           `json:"Text"`
+	HTML      string           `json:"HTML"`
+	Created   time.Time        `json:"Created"`
+	Date      time.Time        `json:"Date"`
+}
+
+func (m *mailpitMessage) captured() *CapturedMessage {
+	message := &CapturedMessage{
+		ID:        m.ID,
+		MessageID: m.MessageID,
+		Subject:   m.Subject,
+		Text:      m.Text,
+		HTML:      m.HTML,
+		Created:   m.Created,
+	}
+	if message.Created.IsZero() {
+		message.Created = m.Date
+	}
+	if m.From != nil {
+		message.From = &Email{Name: m.From.Name, Address: m.From.Address}
+	}
+	for _, to := range m.To {
+		message.To = append(message.To, &Email{Name: to.Name, Address: to.Address})
+	}
+	return message
+}
+
+type mailHogPath struct {
+	Mailbox string `json:"Mailbox"`
+	Domain  string `json:"Domain"`
+}
+
+type mailHogMessage struct {
+	ID      string        `json:"ID"`
+	From    *mailHogPath  `json:"From"`
+	To      []mailHogPath `json:"To"`
+	Created time.Time     `json:"Created"`
+	Raw     struct {
+		Data string `json:"Data"`
+	} `json:"Raw"`
+}
+
+// captured decodes the raw message, MailHog does not decode the content itself
+func (m *mailHogMessage) captured() (*CapturedMessage, error) {
+	parsed, err := parseMIME([]byte(m.Raw.Data))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse captured message %s: %w", m.ID, err)
+	}
+
+	message := &CapturedMessage{
+		ID:          m.ID,
+		MessageID:   strings.Trim(parsed.Header.Get("Message-ID"), "<>"),
+		Subject:     decodeHeader(parsed.Header.Get("Subject")),
+		Text:        parsed.Text,
+		HTML:        parsed.HTML,
+		Attachments: parsed.Attachments,
+		Created:     m.Created,
+	}
+	if from, err := parsed.Header.AddressList("From"); err == nil && len(from) > 0 {
+		message.From = &Email{Name: from[0].Name, Address: from[0].Address}
+	} else if m.From != nil {
+		message.From = &Email{Address: m.From.Mailbox + "@" + m.From.Domain}
+	}
+	for _, to := range m.To {
+		message.To = append(message.To, &Email{Address: to.Mailbox + "@" + to.Domain})
+	}
+	return message, nil
+}
+
+func decodeHeader(value string) string {
+	decoded, err := (&mime.WordDecoder{CharsetReader: charsetReader}).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
@@ -0,0 +1,261 @@
+package sendmail
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCapture is an in-process capture server: a minimal SMTP listener plus the Mailpit and MailHog read API
+type fakeCapture struct {
+	mu       sync.Mutex
+	messages []fakeCaptured
+
+	smtp net.Listener
+	api  *httptest.Server
+}
+
+type fakeCaptured struct {
+	id   string
+	from string
+	to   []string
+	raw  string
+	auth string
+}
+
+func newFakeCapture(t *testing.T) *fakeCapture {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	fake := &fakeCapture{smtp: listener}
+	go fake.serveSMTP()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/messages", fake.mailpitList)
+	mux.HandleFunc("GET /api/v1/message/{id}", fake.mailpitMessage)
+	mux.HandleFunc("GET /api/v2/messages", fake.mailHogList)
+	mux.HandleFunc("GET /api/v1/messages/{id}", fake.mailHogMessage)
+	mux.HandleFunc("DELETE /api/v1/messages", func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		fake.messages = nil
+		fake.mu.Unlock()
+	})
+	fake.api = httptest.NewServer(mux)
+
+	t.Cleanup(func() {
+		listener.Close()
+		fake.api.Close()
+	})
+	return fake
+}
+
+func (f *fakeCapture) serveSMTP() {
+	for {
+		conn, err := f.smtp.Accept()
+		if err != nil {
+			return
+		}
+		go f.handleSMTP(conn)
+	}
+}
+
+func (f *fakeCapture) handleSMTP(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	reply("220 fake ESMTP")
+	current := fakeCaptured{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250-fake")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(command, "AUTH"):
+			current.auth = strings.TrimSpace(line)
+			reply("235 authenticated")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			current.from = strings.Trim(strings.TrimSpace(line)[10:], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			current.to = append(current.to, strings.Trim(strings.TrimSpace(line)[8:], "<>"))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(dataLine, "."))
+			}
+			f.mu.Lock()
+			current.id = fmt.Sprintf("id-%d", len(f.messages)+1)
+			current.raw = data.String()
+			f.messages = append(f.messages, current)
+			f.mu.Unlock()
+			current = fakeCaptured{}
+			reply("250 queued")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (f *fakeCapture) find(id string) (fakeCaptured, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, message := range f.messages {
+		if message.id == id {
+			return message, true
+		}
+	}
+	return fakeCaptured{}, false
+}
+
+func (f *fakeCapture) mailpitJSON(message fakeCaptured, withContent bool) map[string]interface{} {
+	parsed, _ := parseMIME([]byte(message.raw))
+	to := []map[string]string{}
+	for _, address := range message.to {
+		to = append(to, map[string]string{"Address": address})
+	}
+	result := map[string]interface{}{
+		"ID":      message.id,
+		"From":    map[string]string{"Address": message.from},
+		"To":      to,
+		"Subject": decodeHeader(parsed.Header.Get("Subject")),
+	}
+	if withContent {
+		result["Text"] = parsed.Text
+		result["HTML"] = parsed.HTML
+	}
+	return result
+}
+
+func (f *fakeCapture) mailpitList(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	messages := append([]fakeCaptured(nil), f.messages...)
+	f.mu.Unlock()
+
+	list := []map[string]interface{}{}
+	for _, message := range messages {
+		list = append(list, f.mailpitJSON(message, false))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"total": len(list), "messages": list})
+}
+
+func (f *fakeCapture) mailpitMessage(w http.ResponseWriter, r *http.Request) {
+	message, ok := f.find(r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(f.mailpitJSON(message, true))
+}
+
+func (f *fakeCapture) mailHogJSON(message fakeCaptured) map[string]interface{} {
+	path := func(address string) map[string]string {
+		mailbox, domain, _ := strings.Cut(address, "@")
+		return map[string]string{"Mailbox": mailbox, "Domain": domain}
+	}
+	to := []map[string]string{}
+	for _, address := range message.to {
+		to = append(to, path(address))
+	}
+	return map[string]interface{}{
+		"ID":   message.id,
+		"From": path(message.from),
+		"To":   to,
+		"Raw":  map[string]interface{}{"Data": message.raw},
+	}
+}
+
+func (f *fakeCapture) mailHogList(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	messages := append([]fakeCaptured(nil), f.messages...)
+	f.mu.Unlock()
+
+	items := []map[string]interface{}{}
+	for _, message := range messages {
+		items = append(items, f.mailHogJSON(message))
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"total": len(items), "items": items})
+}
+
+func (f *fakeCapture) mailHogMessage(w http.ResponseWriter, r *http.Request) {
+	message, ok := f.find(r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(f.mailHogJSON(message))
+}
+
+func TestCaptureServer_Mailpit(t *testing.T) {
+	fake := newFakeCapture(t)
+	capture, err := NewMailpit(fake.smtp.Addr().String(), fake.api.URL)
+	require.NoError(t, err)
+
+	message := testMessage(t)
+	_, err = capture.SendMessage(message)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	captured, err := capture.WaitForMessage(ctx, func(m *CapturedMessage) bool {
+		return m.Subject == "Test Subject"
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "sender@example.com", captured.From.Address)
+	assert.Equal(t, "recipient@example.com", captured.To[0].Address)
+	assert.Equal(t, "Plain text content", captured.Text)
+	assert.Equal(t, "<p>HTML content</p>", captured.HTML)
+
+	require.NoError(t, capture.DeleteAll())
+	messages, err := capture.Messages()
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestCaptureServer_MailHog(t *testing.T) {
+	fake := newFakeCapture(t)
+	capture, err := NewMailHog(fake.smtp.Addr().String(), fake.api.URL)
+	require.NoError(t, err)
+
+	_, err = capture.SendMail("Sender", "sender@example.com", "Recipient", "recipient@example.com", "Héllo", "text body", "")
+	require.NoError(t, err)
+
+	messages, err := capture.Messages()
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "Héllo", messages[0].Subject)
+	assert.Equal(t, "text body", messages[0].Text)
+	assert.NotEmpty(t, messages[0].MessageID)
+
+	captured, err := capture.Message(messages[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Sender", captured.From.Name)
+}
@@ -0,0 +1,310 @@
+package sendmail
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("sendmail: circuit breaker is open")
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed passes every call through to the sender
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails every call immediately with ErrCircuitOpen
+	BreakerOpen
+	// BreakerHalfOpen lets a limited number of trial calls through
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker stops calling a failing sender so callers fail fast and fallbacks can take over.
+// After the failure threshold of consecutive failures the breaker opens for the open timeout, then half-opens to let trial
+// calls through: enough successes close it, a single failure opens it again. Errors the classifier does not count as
+// failures, such as validation errors, are not counted as successes either.
+// Each backend should be wrapped in its own breaker, the name identifies it in state change callbacks.
+type CircuitBreaker struct {
+	name   string
+	sender SendMail
+
+	failureThreshold  int
+	openTimeout       time.Duration
+	halfOpenSuccesses int
+	isFailure         func(*Response, error) bool
+	onStateChange     func(name string, from, to BreakerState)
+
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	successes int
+	inFlight  int
+	openedAt  time.Time
+	now       func() time.Time
+}
+
+// BreakerOption configures a CircuitBreaker
+type BreakerOption func(*CircuitBreaker)
+
+// WithBreakerFailureThreshold sets the consecutive failures that open the breaker, default 5
+func WithBreakerFailureThreshold(failures int) BreakerOption {
+	return func(c *CircuitBreaker) {
+		c.failureThreshold = failures
+	}
+}
+
+// WithBreakerOpenTimeout sets how long the breaker stays open before trial calls are allowed, default 30 seconds
+func WithBreakerOpenTimeout(timeout time.Duration) BreakerOption {
+	return func(c *CircuitBreaker) {
+		c.openTimeout = timeout
+	}
+}
+
+// WithBreakerHalfOpenSuccesses sets the successful trial calls needed to close the breaker, default 1.
+// It is also the number of trial calls allowed at the same time.
+func WithBreakerHalfOpenSuccesses(successes int) BreakerOption {
+	return func(c *CircuitBreaker) {
+		c.halfOpenSuccesses = successes
+	}
+}
+
+// WithBreakerClassifier decides which results count as failures, replacing IsProviderFailure
+func WithBreakerClassifier(isFailure func(*Response, error) bool) BreakerOption {
+	return func(c *CircuitBreaker) {
+		c.isFailure = isFailure
+	}
+}
+
+// WithBreakerStateChange registers a callback invoked on every state change.
+// The callback runs synchronously, it must not call back into the breaker.
+func WithBreakerStateChange(onStateChange func(name string, from, to BreakerState)) BreakerOption {
+	return func(c *CircuitBreaker) {
+		c.onStateChange = onStateChange
+	}
+}
+
+// IsProviderFailure is the default failure classifier. Errors caused by the message itself, a failed validation or a
+// 4xx rejection other than 408 and 429, say nothing about the health of the provider and are not counted.
+// The status code is read from a ProviderError, or from the response when the error is not one.
+func IsProviderFailure(response *Response, err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrInvalidMessage) || errors.Is(err, ErrMissingFrom) || errors.Is(err, ErrMissingRecipients) ||
+		errors.Is(err, ErrMissingSubject) {
+		return false
+	}
+	statusCode := 0
+	if response != nil {
+		statusCode = response.StatusCode
+	}
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		statusCode = providerErr.StatusCode
+	}
+	if statusCode >= 400 && statusCode < 500 {
+		return statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// NewCircuitBreaker wraps sender in a circuit breaker
+func NewCircuitBreaker(name string, sender SendMail, opts ...BreakerOption) *CircuitBreaker {
+	c := &CircuitBreaker{
+		name:              name,
+		sender:            sender,
+		failureThreshold:  5,
+		openTimeout:       30 * time.Second,
+		halfOpenSuccesses: 1,
+		isFailure:         IsProviderFailure,
+		now:               time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.failureThreshold < 1 {
+		c.failureThreshold = 1
+	}
+	if c.halfOpenSuccesses < 1 {
+		c.halfOpenSuccesses = 1
+	}
+	return c
+}
+
+func (c *CircuitBreaker) SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent string) (*Response, error) {
+	if err := c.before(); err != nil {
+		return nil, err
+	}
+	response, err := c.sender.SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent)
+	c.after(response, err)
+	return response, err
+}
+
+func (c *CircuitBreaker) SendMessage(message *Message) (*Response, error) {
+	if err := c.before(); err != nil {
+		return nil, err
+	}
+	response, err := c.sender.SendMessage(message)
+	c.after(response, err)
+	return response, err
+}
+
+// SendBatch forwards the batch to the wrapped sender, which keeps its native batch endpoint. The batch counts as a
+// single call, failing when one of its messages failed on the provider side. An open breaker fails every message.
+func (c *CircuitBreaker) SendBatch(ctx context.Context, messages []*Message, opts ...BatchOption) ([]BatchResult, error) {
+	results := newBatchResults(messages)
+	if err := c.before(); err != nil {
+		for i := range results {
+			results[i].Err = err
+		}
+		return results, nil
+	}
+	err := forwardBatch(ctx, c.sender, messages, results, opts...)
+
+	// the batch succeeds when a message was sent, it is neutral when every message was rejected for its content
+	var response, rejected *Response
+	var failure, rejection error
+	sent := false
+	for _, result := range results {
+		// messages not sent because the context is done say nothing about the provider
+		if err != nil && errors.Is(result.Err, err) {
+			continue
+		}
+		switch {
+		case c.isFailure(result.Response, result.Err):
+			response, failure = result.Response, result.Err
+		case result.Err == nil:
+			sent = true
+		case rejection == nil:
+			rejected, rejection = result.Response, result.Err
+		}
+		if failure != nil {
+			break
+		}
+	}
+	if failure == nil && !sent {
+		response, failure = rejected, rejection
+	}
+	c.after(response, failure)
+	return results, err
+}
+
+// Name returns the name the breaker was created with
+func (c *CircuitBreaker) Name() string {
+	return c.name
+}
+
+// State returns the current state, an open breaker past its timeout reports half-open
+func (c *CircuitBreaker) State() BreakerState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == BreakerOpen && !c.now().Before(c.openedAt.Add(c.openTimeout)) {
+		return BreakerHalfOpen
+	}
+	return c.state
+}
+
+func (c *CircuitBreaker) before() error {
+	c.mu.Lock()
+	var change func()
+	defer func() {
+		c.mu.Unlock()
+		if change != nil {
+			change()
+		}
+	}()
+
+	if c.state == BreakerOpen {
+		if c.now().Before(c.openedAt.Add(c.openTimeout)) {
+			return ErrCircuitOpen
+		}
+		change = c.setState(BreakerHalfOpen)
+	}
+	if c.state == BreakerHalfOpen {
+		if c.inFlight >= c.halfOpenSuccesses {
+			return ErrCircuitOpen
+		}
+		c.inFlight++
+	}
+	return nil
+}
+
+func (c *CircuitBreaker) after(response *Response, err error) {
+	c.mu.Lock()
+	var change func()
+	defer func() {
+		c.mu.Unlock()
+		if change != nil {
+			change()
+		}
+	}()
+
+	failed := c.isFailure(response, err)
+	// an error that is not a failure, such as a validation error, neither proves nor disproves the sender's health
+	neutral := err != nil && !failed
+	switch c.state {
+	case BreakerHalfOpen:
+		// a call started before the breaker opened may finish while half-open
+		if c.inFlight > 0 {
+			c.inFlight--
+		}
+		if failed {
+			change = c.open()
+			return
+		}
+		if neutral {
+			return
+		}
+		c.successes++
+		if c.successes >= c.halfOpenSuccesses {
+			change = c.setState(BreakerClosed)
+		}
+	case BreakerClosed:
+		if neutral {
+			return
+		}
+		if !failed {
+			c.failures = 0
+			return
+		}
+		c.failures++
+		if c.failures >= c.failureThreshold {
+			change = c.open()
+		}
+	}
+}
+
+func (c *CircuitBreaker) open() func() {
+	c.openedAt = c.now()
+	return c.setState(BreakerOpen)
+}
+
+// setState changes the state and returns the callback notification, to be run once the lock is released
+func (c *CircuitBreaker) setState(state BreakerState) func() {
+	from := c.state
+	c.state = state
+	c.failures = 0
+	c.successes = 0
+	c.inFlight = 0
+	if c.onStateChange == nil || from == state {
+		return nil
+	}
+	return func() {
+		c.onStateChange(c.name, from, state)
+	}
+}
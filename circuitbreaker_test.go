@@ -0,0 +1,173 @@
+package sendmail
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker_OpensAndRecovers(t *testing.T) {
+	backend := &fakeSender{err: errors.New("timeout")}
+	now := time.Now()
+	var changes []string
+	breaker := NewCircuitBreaker("sendgrid", backend,
+		WithBreakerFailureThreshold(2),
+		WithBreakerOpenTimeout(time.Minute),
+		WithBreakerStateChange(func(name string, from, to BreakerState) {
+			changes = append(changes, name+":"+from.String()+"->"+to.String())
+		}))
+	breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		_, err := breaker.SendMessage(testMessage(t))
+		assert.EqualError(t, err, "timeout")
+	}
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	_, err := breaker.SendMessage(testMessage(t))
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, backend.count())
+
+	// a failed trial call opens the breaker again
+	now = now.Add(time.Minute)
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+	_, err = breaker.SendMessage(testMessage(t))
+	assert.EqualError(t, err, "timeout")
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	// a successful trial call closes it
+	backend.setErr(nil)
+	now = now.Add(time.Minute)
+	_, err = breaker.SendMessage(testMessage(t))
+	require.NoError(t, err)
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	assert.Equal(t, []string{
+		"sendgrid:closed->open",
+		"sendgrid:open->half-open",
+		"sendgrid:half-open->open",
+		"sendgrid:open->half-open",
+		"sendgrid:half-open->closed",
+	}, changes)
+}
+
+func TestCircuitBreaker_IgnoresClientErrors(t *testing.T) {
+	backend := &fakeSender{sendFunc: func(message *Message) (*Response, error) {
+		return &Response{StatusCode: http.StatusBadRequest}, errors.New("invalid from address")
+	}}
+	breaker := NewCircuitBreaker("mailtrap", backend, WithBreakerFailureThreshold(1))
+
+	for i := 0; i < 3; i++ {
+		_, err := breaker.SendMessage(testMessage(t))
+		assert.Error(t, err)
+	}
+	assert.Equal(t, BreakerClosed, breaker.State())
+	assert.Equal(t, 3, backend.count())
+}
+
+func TestCircuitBreaker_IgnoresInvalidMessages(t *testing.T) {
+	server := newRecordingServer(t, "")
+	sendGrid, err := NewSendGrid("key", WithBaseURL(server.URL), WithLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+	breaker := NewCircuitBreaker("sendgrid", sendGrid, WithBreakerFailureThreshold(1))
+
+	message := testMessage(t)
+	message.Recipients[0].Address = "jane@localhost"
+	for i := 0; i < 3; i++ {
+		_, err := breaker.SendMessage(message)
+		assert.ErrorIs(t, err, ErrInvalidMessage)
+		var addressErr *AddressError
+		assert.ErrorAs(t, err, &addressErr)
+	}
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	message = testMessage(t)
+	message.Unsubscribe = &Unsubscribe{URL: "http://example.com/unsubscribe"}
+	_, err = breaker.SendMessage(message)
+	assert.ErrorIs(t, err, ErrInvalidUnsubscribe)
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestCircuitBreaker_HalfOpenInvalidMessage(t *testing.T) {
+	backend := &fakeSender{err: errors.New("timeout")}
+	now := time.Now()
+	breaker := NewCircuitBreaker("sendgrid", backend, WithBreakerFailureThreshold(1), WithBreakerOpenTimeout(time.Minute))
+	breaker.now = func() time.Time { return now }
+
+	_, err := breaker.SendMessage(testMessage(t))
+	assert.EqualError(t, err, "timeout")
+	assert.Equal(t, BreakerOpen, breaker.State())
+
+	// an invalid message says nothing about the provider, the breaker stays half-open for the next trial
+	now = now.Add(time.Minute)
+	backend.sendFunc = func(message *Message) (*Response, error) {
+		return nil, message.Validate()
+	}
+	_, err = breaker.SendMessage(&Message{})
+	assert.ErrorIs(t, err, ErrInvalidMessage)
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+	results, err := SendBatch(context.Background(), breaker, []*Message{{}})
+	require.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, ErrInvalidMessage)
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+
+	_, err = breaker.SendMessage(testMessage(t))
+	require.NoError(t, err)
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestIsProviderFailure(t *testing.T) {
+	failure := errors.New("failed")
+	assert.False(t, IsProviderFailure(nil, nil))
+	assert.False(t, IsProviderFailure(nil, ErrMissingRecipients))
+	assert.False(t, IsProviderFailure(nil, (&Message{}).Validate()))
+	assert.False(t, IsProviderFailure(&Response{StatusCode: http.StatusUnprocessableEntity}, failure))
+	assert.True(t, IsProviderFailure(&Response{StatusCode: http.StatusTooManyRequests}, failure))
+	assert.True(t, IsProviderFailure(&Response{StatusCode: http.StatusBadGateway}, failure))
+	assert.True(t, IsProviderFailure(nil, failure))
+	assert.False(t, IsProviderFailure(nil, &ProviderError{StatusCode: http.StatusBadRequest, Err: failure}))
+	assert.True(t, IsProviderFailure(nil, &ProviderError{StatusCode: http.StatusTooManyRequests, Err: failure}))
+}
+
+func TestCircuitBreaker_ProviderRejections(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusUnprocessableEntity)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(int(status.Load()))
+		w.Write([]byte(`{"message":"rejected","ErrorMessage":"rejected"}`))
+	}))
+	t.Cleanup(server.Close)
+	quiet := WithLogger(func(string, ...interface{}) {})
+
+	mailerSend, err := NewMailerSend("token", WithBaseURL(server.URL), quiet)
+	require.NoError(t, err)
+	mailJet, err := NewMailJet("key", "secret", WithBaseURL(server.URL), quiet)
+	require.NoError(t, err)
+
+	for _, sender := range []SendMail{mailerSend, mailJet} {
+		status.Store(http.StatusUnprocessableEntity)
+		breaker := NewCircuitBreaker("provider", sender, WithBreakerFailureThreshold(1))
+
+		// a rejected recipient says nothing about the provider
+		response, err := breaker.SendMessage(testMessage(t))
+		var providerErr *ProviderError
+		require.ErrorAs(t, err, &providerErr)
+		assert.Equal(t, http.StatusUnprocessableEntity, providerErr.StatusCode)
+		require.NotNil(t, response)
+		assert.Equal(t, http.StatusUnprocessableEntity, response.StatusCode)
+		assert.Equal(t, BreakerClosed, breaker.State())
+
+		status.Store(http.StatusServiceUnavailable)
+		_, err = breaker.SendMessage(testMessage(t))
+		assert.Error(t, err)
+		assert.Equal(t, BreakerOpen, breaker.State())
+	}
+}
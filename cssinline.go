@@ -0,0 +1,445 @@
+package sendmail
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var ErrInvalidCSS = errors.New("sendmail: invalid css")
+
+// dynamicPseudo matches the pseudo-classes and pseudo-elements that depend on the client, rules using them can't
+// be inlined and are kept in a <style> element
+var dynamicPseudo = regexp.MustCompile(`(?i)::?(hover|active|focus|focus-within|focus-visible|visited|target|before|after|first-line|first-letter|placeholder|selection|marker)\b`)
+
+var atRuleName = regexp.MustCompile(`^@[-\w]+`)
+
+// InlineCSS applies the rules of the <style> elements in content as inline style attributes, since many email
+// clients ignore <style>. The cascade is followed: declarations are applied by importance, specificity and source
+// order, and existing style attributes take precedence over the rules. Rules that can't be inlined, media queries,
+// dynamic pseudo-classes such as :hover and at-rules such as @font-face, are kept in a single <style> element.
+// Rules matching no element are dropped. <style> elements with a media attribute are left as is.
+func InlineCSS(content string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+
+	var styles []*html.Node
+	var find func(n *html.Node)
+	find = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.DataAtom == atom.Style && attribute(n, "media") == "" {
+			styles = append(styles, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			find(c)
+		}
+	}
+	find(doc)
+	if len(styles) == 0 {
+		return content, nil
+	}
+
+	inliner := &cssInliner{doc: doc, declarations: map[*html.Node][]cssDeclaration{}}
+	var kept []string
+	for _, style := range styles {
+		var css strings.Builder
+		for c := style.FirstChild; c != nil; c = c.NextSibling {
+			css.WriteString(c.Data)
+		}
+		items, err := parseCSS(css.String())
+		if err != nil {
+			return "", err
+		}
+		for _, item := range items {
+			if text := inliner.apply(item); text != "" {
+				kept = append(kept, text)
+			}
+		}
+	}
+	inliner.write()
+
+	// the kept rules replace the first <style> element, the others are removed
+	first := styles[0]
+	for _, style := range styles[1:] {
+		style.Parent.RemoveChild(style)
+	}
+	if len(kept) > 0 {
+		for first.FirstChild != nil {
+			first.RemoveChild(first.FirstChild)
+		}
+		first.AppendChild(&html.Node{Type: html.TextNode, Data: "\n" + strings.Join(kept, "\n") + "\n"})
+	} else {
+		first.Parent.RemoveChild(first)
+	}
+	return renderHTML(doc, content)
+}
+
+// renderHTML renders the document, a fragment without <html> element is rendered without the elements added by
+// the parser. Doctypes and comments outside the added elements, such as Outlook conditional comments, are kept.
+func renderHTML(doc *html.Node, content string) (string, error) {
+	var out strings.Builder
+	if strings.Contains(strings.ToLower(content), "<html") {
+		if err := html.Render(&out, doc); err != nil {
+			return "", err
+		}
+		return out.String(), nil
+	}
+	for root := doc.FirstChild; root != nil; root = root.NextSibling {
+		if root.Type != html.ElementNode {
+			if err := html.Render(&out, root); err != nil {
+				return "", err
+			}
+			continue
+		}
+		for section := root.FirstChild; section != nil; section = section.NextSibling {
+			if section.Type != html.ElementNode {
+				if err := html.Render(&out, section); err != nil {
+					return "", err
+				}
+				continue
+			}
+			for c := section.FirstChild; c != nil; c = c.NextSibling {
+				if err := html.Render(&out, c); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+	return out.String(), nil
+}
+
+// cssItem is a rule or an at-rule of a style sheet. The body of an at-rule holds its nested rules, it is empty for
+// at-rules without a block such as @import.
+type cssItem struct {
+	prelude string
+	body    string
+	block   bool
+}
+
+// cssDeclaration is a property set on an element, ordered by its place in the cascade
+type cssDeclaration struct {
+	property    string
+	value       string
+	important   bool
+	inline      bool
+	specificity cascadia.Specificity
+	order       int
+}
+
+type cssInliner struct {
+	doc          *html.Node
+	declarations map[*html.Node][]cssDeclaration
+	elements     []*html.Node
+	order        int
+}
+
+// apply inlines a rule, returning the text to keep in the <style> element
+func (c *cssInliner) apply(item cssItem) string {
+	if strings.HasPrefix(item.prelude, "@") {
+		return c.keepAtRule(item)
+	}
+	declarations := parseDeclarations(item.body)
+	if len(declarations) == 0 {
+		return ""
+	}
+
+	var keep []string
+	for _, selector := range splitSelectors(item.prelude) {
+		if dynamicPseudo.MatchString(selector) {
+			if c.used(dynamicPseudo.ReplaceAllString(selector, "")) {
+				keep = append(keep, selector)
+			}
+			continue
+		}
+		compiled, err := cascadia.Parse(selector)
+		if err != nil {
+			// selectors cascadia doesn't know are left to the client
+			keep = append(keep, selector)
+			continue
+		}
+		for _, n := range cascadia.QueryAll(c.doc, compiled) {
+			c.add(n, declarations, false, compiled.Specificity())
+		}
+	}
+	if len(keep) == 0 {
+		return ""
+	}
+	return strings.Join(keep, ", ") + " { " + item.body + " }"
+}
+
+// keepAtRule keeps at-rules as they are, the nested rules of @media and @supports are dropped when they match no
+// element
+func (c *cssInliner) keepAtRule(item cssItem) string {
+	if !item.block {
+		return item.prelude + ";"
+	}
+	verbatim := item.prelude + " { " + item.body + " }"
+	name := strings.ToLower(atRuleName.FindString(item.prelude))
+	if name != "@media" && name != "@supports" {
+		return verbatim
+	}
+	nested, err := parseCSS(item.body)
+	if err != nil {
+		return verbatim
+	}
+	var rules []string
+	for _, rule := range nested {
+		if strings.HasPrefix(rule.prelude, "@") {
+			rules = append(rules, c.keepAtRule(rule))
+			continue
+		}
+		var used []string
+		for _, selector := range splitSelectors(rule.prelude) {
+			if c.used(dynamicPseudo.ReplaceAllString(selector, "")) {
+				used = append(used, selector)
+			}
+		}
+		if len(used) > 0 {
+			rules = append(rules, "  "+strings.Join(used, ", ")+" { "+rule.body+" }")
+		}
+	}
+	if len(rules) == 0 {
+		return ""
+	}
+	return item.prelude + " {\n" + strings.Join(rules, "\n") + "\n}"
+}
+
+// used reports whether selector matches an element, selectors that can't be matched count as used
+func (c *cssInliner) used(selector string) bool {
+	compiled, err := cascadia.Parse(strings.TrimSpace(selector))
+	if err != nil {
+		return true
+	}
+	return cascadia.Query(c.doc, compiled) != nil
+}
+
+func (c *cssInliner) add(n *html.Node, declarations []cssDeclaration, inline bool, specificity cascadia.Specificity) {
+	if _, ok := c.declarations[n]; !ok {
+		c.elements = append(c.elements, n)
+		// the existing style attribute is part of the cascade
+		c.declarations[n] = nil
+		if !inline {
+			c.add(n, parseDeclarations(attribute(n, "style")), true, cascadia.Specificity{})
+		}
+	}
+	for _, declaration := range declarations {
+		declaration.inline = inline
+		declaration.specificity = specificity
+		declaration.order = c.order
+		c.order++
+		c.declarations[n] = append(c.declarations[n], declaration)
+	}
+}
+
+// write sets the style attribute of the matched elements. !important is dropped from the inlined declarations so
+// that the kept media queries can still override them with !important.
+func (c *cssInliner) write() {
+	for _, n := range c.elements {
+		declarations := c.declarations[n]
+		sort.SliceStable(declarations, func(i, j int) bool {
+			a, b := declarations[i], declarations[j]
+			if a.important != b.important {
+				return b.important
+			}
+			if a.inline != b.inline {
+				return b.inline
+			}
+			if a.specificity != b.specificity {
+				return a.specificity.Less(b.specificity)
+			}
+			return a.order < b.order
+		})
+		// a property set again moves to the end, so shorthands and longhands keep their cascade order
+		var properties []string
+		values := map[string]string{}
+		for _, declaration := range declarations {
+			if _, ok := values[declaration.property]; ok {
+				properties = removeString(properties, declaration.property)
+			}
+			properties = append(properties, declaration.property)
+			values[declaration.property] = declaration.value
+		}
+		style := make([]string, len(properties))
+		for i, property := range properties {
+			style[i] = property + ": " + values[property]
+		}
+		setAttribute(n, "style", strings.Join(style, "; "))
+	}
+}
+
+func removeString(values []string, value string) []string {
+	for i, v := range values {
+		if v == value {
+			return append(values[:i], values[i+1:]...)
+		}
+	}
+	return values
+}
+
+func setAttribute(n *html.Node, key, value string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr[i].Val = value
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: value})
+}
+
+// parseCSS splits a style sheet into its rules and at-rules, comments are dropped
+func parseCSS(css string) ([]cssItem, error) {
+	css, err := stripCSSComments(css)
+	if err != nil {
+		return nil, err
+	}
+	var items []cssItem
+	start := 0
+	for i := 0; i < len(css); i++ {
+		switch css[i] {
+		case '"', '\'':
+			end := strings.IndexByte(css[i+1:], css[i])
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated string", ErrInvalidCSS)
+			}
+			i += end + 1
+		case ';':
+			// an at-rule without block, e.g. @import
+			if prelude := strings.TrimSpace(css[start:i]); prelude != "" {
+				items = append(items, cssItem{prelude: prelude})
+			}
+			start = i + 1
+		case '{':
+			end, err := closingBrace(css, i)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, cssItem{
+				prelude: strings.TrimSpace(css[start:i]),
+				body:    strings.TrimSpace(css[i+1 : end]),
+				block:   true,
+			})
+			i = end
+			start = end + 1
+		case '}':
+			return nil, fmt.Errorf("%w: unexpected }", ErrInvalidCSS)
+		}
+	}
+	if rest := strings.TrimSpace(css[start:]); rest != "" {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidCSS, rest)
+	}
+	return items, nil
+}
+
+// closingBrace returns the index of the brace closing the block opened at open
+func closingBrace(css string, open int) (int, error) {
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '"', '\'':
+			end := strings.IndexByte(css[i+1:], css[i])
+			if end < 0 {
+				return 0, fmt.Errorf("%w: unterminated string", ErrInvalidCSS)
+			}
+			i += end + 1
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("%w: unterminated block", ErrInvalidCSS)
+}
+
+func stripCSSComments(css string) (string, error) {
+	var out strings.Builder
+	for {
+		start := strings.Index(css, "/*")
+		if start < 0 {
+			out.WriteString(css)
+			return out.String(), nil
+		}
+		end := strings.Index(css[start+2:], "*/")
+		if end < 0 {
+			return "", fmt.Errorf("%w: unterminated comment", ErrInvalidCSS)
+		}
+		out.WriteString(css[:start])
+		css = css[start+2+end+2:]
+	}
+}
+
+// parseDeclarations parses "property: value; ..." lists, as found in rules and style attributes. Semicolons
+// within strings and parentheses, e.g. in data URLs, don't end a declaration.
+func parseDeclarations(text string) []cssDeclaration {
+	var declarations []cssDeclaration
+	add := func(declaration string) {
+		property, value, ok := strings.Cut(declaration, ":")
+		property = strings.ToLower(strings.TrimSpace(property))
+		value = strings.TrimSpace(value)
+		if !ok || property == "" || value == "" {
+			return
+		}
+		important := false
+		if i := strings.LastIndexByte(value, '!'); i >= 0 && strings.EqualFold(strings.TrimSpace(value[i+1:]), "important") {
+			important = true
+			value = strings.TrimSpace(value[:i])
+		}
+		declarations = append(declarations, cssDeclaration{property: property, value: value, important: important})
+	}
+
+	start, depth := 0, 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch ch := text[i]; {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth = max(depth-1, 0)
+		case ch == ';' && depth == 0:
+			add(text[start:i])
+			start = i + 1
+		}
+	}
+	add(text[start:])
+	return declarations
+}
+
+// splitSelectors splits a selector list on the commas outside parentheses and attribute selectors
+func splitSelectors(prelude string) []string {
+	var selectors []string
+	start, depth := 0, 0
+	for i := 0; i < len(prelude); i++ {
+		switch prelude[i] {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth = max(depth-1, 0)
+		case ',':
+			if depth == 0 {
+				if selector := strings.TrimSpace(prelude[start:i]); selector != "" {
+					selectors = append(selectors, selector)
+				}
+				start = i + 1
+			}
+		}
+	}
+	if selector := strings.TrimSpace(prelude[start:]); selector != "" {
+		selectors = append(selectors, selector)
+	}
+	return selectors
+}
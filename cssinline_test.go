@@ -0,0 +1,98 @@
+package sendmail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInlineCSS(t *testing.T) {
+	content := `<!DOCTYPE html><html><head><style>
+/* base */
+p { color: #333; margin: 0 0 10px }
+.note { color: red; font-size: 12px }
+p.note { color: green }
+#footer p { color: gray !important }
+.unused, h6 { color: blue }
+a:hover { color: orange }
+td[data-x="a;b"] { background: url(data:image/png;base64,AAA) }
+@font-face { font-family: Brand; src: url(brand.woff) }
+@media (max-width: 600px) {
+  p { font-size: 16px !important }
+  .unused { display: none }
+}
+@media print { .unused { display: none } }
+</style></head>
+<body>
+<p>Hello</p>
+<p class="note" style="margin: 0">Note</p>
+<div id="footer"><p style="color: black">Footer <a href="https://example.com">link</a></p></div>
+<table><tr><td data-x="a;b">cell</td></tr></table>
+</body></html>`
+
+	inlined, err := InlineCSS(content)
+	require.NoError(t, err)
+
+	assert.Contains(t, inlined, `<p style="color: #333; margin: 0 0 10px">Hello</p>`)
+	// p.note is more specific than .note, the style attribute beats both
+	assert.Contains(t, inlined, `<p class="note" style="font-size: 12px; color: green; margin: 0">Note</p>`)
+	// !important beats the style attribute
+	assert.Contains(t, inlined, `<p style="margin: 0 0 10px; color: gray">Footer`)
+	assert.Contains(t, inlined, `<td data-x="a;b" style="background: url(data:image/png;base64,AAA)">cell</td>`)
+
+	assert.Contains(t, inlined, `<head><style>
+a:hover { color: orange }
+@font-face { font-family: Brand; src: url(brand.woff) }
+@media (max-width: 600px) {
+  p { font-size: 16px !important }
+}
+</style></head>`)
+	assert.NotContains(t, inlined, "unused")
+	assert.NotContains(t, inlined, "h6")
+	assert.NotContains(t, inlined, "@media print")
+}
+
+func TestInlineCSS_Fragment(t *testing.T) {
+	inlined, err := InlineCSS(`<style>b { font-weight: bold } b:hover { color: red }</style><p>Hi <b>Jane</b></p>`)
+	require.NoError(t, err)
+	assert.Equal(t, "<style>\nb:hover { color: red }\n</style><p>Hi <b style=\"font-weight: bold\">Jane</b></p>", inlined)
+
+	inlined, err = InlineCSS(`<style>b { font-weight: bold }</style><p>Hi <b>Jane</b></p>`)
+	require.NoError(t, err)
+	assert.Equal(t, `<p>Hi <b style="font-weight: bold">Jane</b></p>`, inlined)
+
+	content := `<p>No style</p>`
+	inlined, err = InlineCSS(content)
+	require.NoError(t, err)
+	assert.Equal(t, content, inlined)
+
+	// doctypes and top level comments are not part of the added elements and are rendered as well
+	inlined, err = InlineCSS("<!DOCTYPE html><!--[if mso]><table><tr><td><![endif]--><style>b { font-weight: bold }</style>" +
+		"<p>Hi <b>Jane</b></p><!--[if mso]></td></tr></table><![endif]-->")
+	require.NoError(t, err)
+	assert.Equal(t, `<!DOCTYPE html><!--[if mso]><table><tr><td><![endif]--><p>Hi <b style="font-weight: bold">Jane</b></p>`+
+		`<!--[if mso]></td></tr></table><![endif]-->`, inlined)
+
+	_, err = InlineCSS(`<style>p { color: red </style><p>Hi</p>`)
+	assert.ErrorIs(t, err, ErrInvalidCSS)
+}
+
+func TestMessageBuilder_InlineCSS(t *testing.T) {
+	builder := func() MessageBuilder {
+		return NewEmailMessage().
+			FromEmail("Shop", "shop@example.com").
+			AddRecipient("Jane", "jane@example.com").
+			Subject("Hello").
+			HtmlContent(`<style>p { color: red }</style><p>Hi</p>`)
+	}
+
+	message, err := builder().InlineCSS(true).Build()
+	require.NoError(t, err)
+	assert.Equal(t, `<p style="color: red">Hi</p>`, message.HtmlContent)
+	assert.Equal(t, "Hi", message.PlainTextContent)
+
+	message, err = builder().Build()
+	require.NoError(t, err)
+	assert.Equal(t, `<style>p { color: red }</style><p>Hi</p>`, message.HtmlContent)
+}
@@ -0,0 +1,371 @@
+package sendmail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidDKIMKey = errors.New("sendmail: invalid DKIM key")
+
+// DKIMCanonicalization is the algorithm used to prepare the header or body before signing, RFC 6376 section 3.4.
+// Relaxed tolerates the whitespace and header case changes made by relays, simple does not.
+type DKIMCanonicalization string
+
+const (
+	DKIMSimple  DKIMCanonicalization = "simple"
+	DKIMRelaxed DKIMCanonicalization = "relaxed"
+)
+
+// DefaultDKIMHeaders are the headers signed unless WithDKIMHeaders is given, the ones missing from a message are
+// skipped. From is always signed.
+var DefaultDKIMHeaders = []string{
+	"From", "Reply-To", "To", "Cc", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type",
+	"Content-Transfer-Encoding", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// dkimKey is a signing key published at <selector>._domainkey.<domain>
+type dkimKey struct {
+	domain    string
+	selector  string
+	signer    crypto.Signer
+	algorithm string
+}
+
+// DKIMSigner adds DKIM-Signature headers, RFC 6376, to rendered messages. Keys are RSA, signed with rsa-sha256, or
+// Ed25519, signed with ed25519-sha256 (RFC 8463). With several keys, e.g. an RSA and an Ed25519 one during a key
+// rollover or for dual signing, each adds its own signature.
+type DKIMSigner struct {
+	keys   []*dkimKey
+	header DKIMCanonicalization
+	body   DKIMCanonicalization
+	signed []string
+	expiry time.Duration
+	now    func() time.Time
+}
+
+// DKIMOption configures a DKIMSigner
+type DKIMOption func(*DKIMSigner)
+
+// WithDKIMKey adds another key, it signs the message in addition to the key given to NewDKIMSigner
+func WithDKIMKey(domain, selector string, key crypto.Signer) DKIMOption {
+	return func(s *DKIMSigner) {
+		s.keys = append(s.keys, &dkimKey{domain: domain, selector: selector, signer: key})
+	}
+}
+
+// WithDKIMCanonicalization sets the header and body canonicalization, relaxed/relaxed by default
+func WithDKIMCanonicalization(header, body DKIMCanonicalization) DKIMOption {
+	return func(s *DKIMSigner) {
+		s.header, s.body = header, body
+	}
+}
+
+// WithDKIMHeaders replaces DefaultDKIMHeaders
+func WithDKIMHeaders(headers ...string) DKIMOption {
+	return func(s *DKIMSigner) {
+		s.signed = headers
+	}
+}
+
+// WithDKIMExpiration sets the x= tag, after which verifiers consider the signature invalid. No expiration by default.
+func WithDKIMExpiration(expiry time.Duration) DKIMOption {
+	return func(s *DKIMSigner) {
+		s.expiry = expiry
+	}
+}
+
+// NewDKIMSigner creates a signer for the key published at <selector>._domainkey.<domain>. The key is an
+// *rsa.PrivateKey of at least 1024 bits or an ed25519.PrivateKey, see ParseDKIMKey.
+func NewDKIMSigner(domain, selector string, key crypto.Signer, opts ...DKIMOption) (*DKIMSigner, error) {
+	s := &DKIMSigner{
+		keys:   []*dkimKey{{domain: domain, selector: selector, signer: key}},
+		header: DKIMRelaxed,
+		body:   DKIMRelaxed,
+		signed: DefaultDKIMHeaders,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	for _, c := range []DKIMCanonicalization{s.header, s.body} {
+		if c != DKIMSimple && c != DKIMRelaxed {
+			return nil, fmt.Errorf("sendmail: unknown DKIM canonicalization %q", c)
+		}
+	}
+	for _, k := range s.keys {
+		if strings.TrimSpace(k.domain) == "" || strings.TrimSpace(k.selector) == "" {
+			return nil, fmt.Errorf("%w: missing domain or selector", ErrInvalidDKIMKey)
+		}
+		switch private := k.signer.(type) {
+		case *rsa.PrivateKey:
+			if private.N.BitLen() < 1024 {
+				return nil, fmt.Errorf("%w: RSA keys need at least 1024 bits", ErrInvalidDKIMKey)
+			}
+			k.algorithm = "rsa-sha256"
+		case ed25519.PrivateKey:
+			k.algorithm = "ed25519-sha256"
+		default:
+			return nil, fmt.Errorf("%w: unsupported key type %T", ErrInvalidDKIMKey, k.signer)
+		}
+	}
+	return s, nil
+}
+
+// ParseDKIMKey reads a PEM encoded RSA (PKCS #1 or PKCS #8) or Ed25519 (PKCS #8) private key
+func ParseDKIMKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM data", ErrInvalidDKIMKey)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidDKIMKey, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported key type %T", ErrInvalidDKIMKey, key)
+	}
+	return signer, nil
+}
+
+// DKIMRecord returns the TXT record to publish at <selector>._domainkey.<domain> for key
+func DKIMRecord(key crypto.Signer) (string, error) {
+	switch public := key.Public().(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return "", err
+		}
+		return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(public), nil
+	default:
+		return "", fmt.Errorf("%w: unsupported key type %T", ErrInvalidDKIMKey, public)
+	}
+}
+
+// Sign returns the message with a DKIM-Signature header per key prepended. The message is an RFC 5322 document
+// with CRLF line endings, as rendered for the SMTP transport.
+func (s *DKIMSigner) Sign(message []byte) ([]byte, error) {
+	headerEnd := bytes.Index(message, []byte("\r\n\r\n"))
+	if headerEnd < 0 {
+		return nil, fmt.Errorf("sendmail: malformed message, no end of header")
+	}
+	fields := splitHeaderFields(string(message[:headerEnd+2]))
+	body := message[headerEnd+4:]
+
+	bodyHash := sha256.Sum256(canonicalizeBody(body, s.body))
+	signed := s.selectHeaders(fields)
+	if !s.signsFrom(signed) {
+		return nil, fmt.Errorf("sendmail: DKIM requires a From header")
+	}
+	names := make([]string, len(signed))
+	for i, field := range signed {
+		names[i] = strings.ToLower(headerName(field))
+	}
+
+	var signatures strings.Builder
+	now := s.now()
+	for _, key := range s.keys {
+		tags := []string{
+			"v=1",
+			"a=" + key.algorithm,
+			"c=" + string(s.header) + "/" + string(s.body),
+			"d=" + key.domain,
+			"s=" + key.selector,
+			"t=" + strconv.FormatInt(now.Unix(), 10),
+		}
+		if s.expiry > 0 {
+			tags = append(tags, "x="+strconv.FormatInt(now.Add(s.expiry).Unix(), 10))
+		}
+		tags = append(tags,
+			"h="+strings.Join(names, ":"),
+			"bh="+base64.StdEncoding.EncodeToString(bodyHash[:]),
+			"b=",
+		)
+		// the signature covers its own header with an empty b= tag, without the final line break
+		header := "DKIM-Signature: " + foldTags(tags)
+
+		hash := sha256.New()
+		for _, field := range signed {
+			hash.Write([]byte(canonicalizeHeader(field, s.header)))
+		}
+		hash.Write([]byte(strings.TrimSuffix(canonicalizeHeader(header+"\r\n", s.header), "\r\n")))
+
+		var signature []byte
+		var err error
+		if key.algorithm == "ed25519-sha256" {
+			// RFC 8463 signs the SHA-256 hash with PureEd25519
+			signature, err = key.signer.Sign(rand.Reader, hash.Sum(nil), crypto.Hash(0))
+		} else {
+			signature, err = key.signer.Sign(rand.Reader, hash.Sum(nil), crypto.SHA256)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sendmail: DKIM signature for %s: %w", key.domain, err)
+		}
+		signatures.WriteString(header + foldBase64(base64.StdEncoding.EncodeToString(signature)) + "\r\n")
+	}
+
+	signedMessage := make([]byte, 0, signatures.Len()+len(message))
+	signedMessage = append(signedMessage, signatures.String()...)
+	return append(signedMessage, message...), nil
+}
+
+func (s *DKIMSigner) signsFrom(fields []string) bool {
+	for _, field := range fields {
+		if strings.EqualFold(headerName(field), "From") {
+			return true
+		}
+	}
+	return false
+}
+
+// selectHeaders returns the fields to sign in the order of the h= tag. A repeated header is signed from the last
+// instance up, as verifiers read them, RFC 6376 section 5.4.2.
+func (s *DKIMSigner) selectHeaders(fields []string) []string {
+	used := map[int]bool{}
+	var signed []string
+	names := s.signed
+	if !containsFold(names, "From") {
+		names = append([]string{"From"}, names...)
+	}
+	for _, name := range names {
+		for {
+			index := -1
+			for i := len(fields) - 1; i >= 0; i-- {
+				if !used[i] && strings.EqualFold(headerName(fields[i]), name) {
+					index = i
+					break
+				}
+			}
+			if index < 0 {
+				break
+			}
+			used[index] = true
+			signed = append(signed, fields[index])
+		}
+	}
+	return signed
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitHeaderFields splits a header block into its fields, each with its folded lines and final CRLF
+func splitHeaderFields(header string) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(header, "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+func headerName(field string) string {
+	name, _, _ := strings.Cut(field, ":")
+	return strings.TrimSpace(name)
+}
+
+// canonicalizeHeader prepares a header field, including its CRLF, RFC 6376 section 3.4.1 and 3.4.2
+func canonicalizeHeader(field string, c DKIMCanonicalization) string {
+	if c == DKIMSimple {
+		return field
+	}
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value + "\r\n"
+}
+
+// canonicalizeBody prepares the body, RFC 6376 section 3.4.3 and 3.4.4. Trailing empty lines are dropped, an
+// empty body is a single CRLF with simple canonicalization and empty with relaxed.
+func canonicalizeBody(body []byte, c DKIMCanonicalization) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	if c == DKIMRelaxed {
+		for i, line := range lines {
+			words := strings.FieldsFunc(line, isWSP)
+			line = strings.Join(words, " ")
+			if len(words) > 0 && isWSP(rune(lines[i][0])) {
+				line = " " + line
+			}
+			lines[i] = line
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if c == DKIMSimple {
+			return []byte("\r\n")
+		}
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// foldTags joins the tags of the signature header, folding lines before 78 characters
+func foldTags(tags []string) string {
+	var out strings.Builder
+	lineLength := len("DKIM-Signature: ")
+	for i, tag := range tags {
+		if i > 0 {
+			out.WriteString(";")
+			lineLength++
+			if lineLength+1+len(tag) > 76 {
+				out.WriteString("\r\n\t")
+				lineLength = 1
+			} else {
+				out.WriteString(" ")
+				lineLength++
+			}
+		}
+		out.WriteString(tag)
+		lineLength += len(tag)
+	}
+	return out.String()
+}
+
+// foldBase64 folds the signature value, verifiers ignore whitespace in b=
+func foldBase64(value string) string {
+	const width = 72
+	var out strings.Builder
+	for len(value) > width {
+		out.WriteString(value[:width])
+		out.WriteString("\r\n\t")
+		value = value[width:]
+	}
+	out.WriteString(value)
+	return out.String()
+}
@@ -0,0 +1,117 @@
+package sendmail
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dkimKeys generates the keys of the tests. Signatures are verified against an independent implementation in the
+// integration module.
+func dkimKeys(t *testing.T) (*rsa.PrivateKey, ed25519.PrivateKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return rsaKey, edKey
+}
+
+func TestNewDKIMSigner_Errors(t *testing.T) {
+	rsaKey, _ := dkimKeys(t)
+	_, err := NewDKIMSigner("", "rsa", rsaKey)
+	assert.ErrorIs(t, err, ErrInvalidDKIMKey)
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, err = NewDKIMSigner("example.com", "ec", ecdsaKey)
+	assert.ErrorIs(t, err, ErrInvalidDKIMKey)
+
+	_, err = NewDKIMSigner("example.com", "rsa", rsaKey, WithDKIMCanonicalization("strict", DKIMRelaxed))
+	assert.Error(t, err)
+
+	signer, err := NewDKIMSigner("example.com", "rsa", rsaKey)
+	require.NoError(t, err)
+	_, err = signer.Sign([]byte("Subject: no from\r\n\r\nbody\r\n"))
+	assert.Error(t, err)
+	_, err = signer.Sign([]byte("From: a@example.com"))
+	assert.Error(t, err)
+}
+
+func TestParseDKIMKey(t *testing.T) {
+	rsaKey, edKey := dkimKeys(t)
+
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	key, err := ParseDKIMKey(pkcs1)
+	require.NoError(t, err)
+	assert.True(t, rsaKey.Equal(key))
+
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	key, err = ParseDKIMKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	require.NoError(t, err)
+	assert.True(t, edKey.Equal(key))
+
+	_, err = ParseDKIMKey([]byte("not a key"))
+	assert.ErrorIs(t, err, ErrInvalidDKIMKey)
+}
+
+func TestCanonicalizeBody(t *testing.T) {
+	body := []byte(" Hello \t world  \r\n\r\nline\t\r\n\r\n\r\n")
+	assert.Equal(t, " Hello \t world  \r\n\r\nline\t\r\n", string(canonicalizeBody(body, DKIMSimple)))
+	assert.Equal(t, " Hello world\r\n\r\nline\r\n", string(canonicalizeBody(body, DKIMRelaxed)))
+	assert.Equal(t, "\r\n", string(canonicalizeBody(nil, DKIMSimple)))
+	assert.Empty(t, canonicalizeBody([]byte("\r\n\r\n"), DKIMRelaxed))
+}
+
+func TestSMTP_DKIM(t *testing.T) {
+	_, edKey := dkimKeys(t)
+	signer, err := NewDKIMSigner("example.com", "ed", edKey)
+	require.NoError(t, err)
+
+	fake := newFakeCapture(t)
+	host, port, err := net.SplitHostPort(fake.smtp.Addr().String())
+	require.NoError(t, err)
+	portNumber, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	send, err := NewSMTP(host, portNumber, "", "", WithDKIM(signer), WithLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+
+	_, err = send.SendMessage(testMessage(t))
+	require.NoError(t, err)
+
+	fake.mu.Lock()
+	raw := fake.messages[0].raw
+	fake.mu.Unlock()
+	require.True(t, strings.HasPrefix(raw, "DKIM-Signature: v=1; a=ed25519-sha256;"))
+	signature, message, _ := strings.Cut(raw, "\r\nFrom: ")
+	assert.Contains(t, signature, " d=example.com;")
+	assert.Contains(t, signature, "\ts=ed;")
+	assert.Contains(t, message, "Subject: Test Subject\r\n")
+}
+
+func TestFileSender_DKIM(t *testing.T) {
+	_, edKey := dkimKeys(t)
+	signer, err := NewDKIMSigner("example.com", "ed", edKey)
+	require.NoError(t, err)
+
+	send, err := NewMaildirSender(t.TempDir(), WithDKIM(signer), WithLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+	response, err := send.SendMessage(testMessage(t))
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(response.Body)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), "DKIM-Signature: v=1; a=ed25519-sha256;"))
+}
@@ -11,17 +11,17 @@ import (
 func main() {
 	fmt.Println("Starting mailersend example")
 	fmt.Println("Make sure to set MAILERSEND_API_TOKEN")
-	send, err := sendmail.NewMailerSend(os.Getenv("MAILERSEND_API_TOKEN"))
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	send, err := sendmail.NewMailerSend(os.Getenv("MAILERSEND_API_TOKEN"),
+		sendmail.WithLogger(func(format string, args ...interface{}) {
+			// slog.Debug(fmt.Sprintf(format, args...))
+			logger.Info(format, args...)
+		}))
 	if err != nil {
 		slog.Error("Error connecting to mailersend service", "error", err)
 		return
 	}
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
-	send.Logger = func(format string, args ...interface{}) {
-		// slog.Debug(fmt.Sprintf(format, args...))
-		logger.Info(format, args...)
-	}
 	messageBuilder := sendmail.NewEmailMessage()
 
 	// Note: the from email address requires authentication in MailJet
@@ -11,16 +11,14 @@ import (
 func main() {
 	fmt.Println("Starting smtp2go example")
 	fmt.Println("Make sure to set SMTP2GO_API_KEY")
-	send, err := sendmail.NewSmtp2go(os.Getenv("SMTP2GO_API_KEY"))
-	if err != nil {
-		slog.Error("Error connecting to smtp2go service", "error", err)
-		return
-	}
-
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
-	send.Logger = func(format string, args ...interface{}) {
+	send, err := sendmail.NewSmtp2go(os.Getenv("SMTP2GO_API_KEY"), sendmail.WithLogger(func(format string, args ...interface{}) {
 		// slog.Debug(fmt.Sprintf(format, args...))
 		logger.Info(format, args...)
+	}))
+	if err != nil {
+		slog.Error("Error connecting to smtp2go service", "error", err)
+		return
 	}
 	messageBuilder := sendmail.NewEmailMessage()
 
@@ -0,0 +1,159 @@
+// File and Maildir sink, intended for development environments where no mail should leave the machine
+package sendmail
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/malcolm-davis/go-stopwatch"
+)
+
+// FileSender writes each message to disk rather than sending it.
+// In the default layout each message is written as <id>.eml next to an <id>.json copy of the original Message.
+// In the Maildir layout messages are delivered into new/ and the json copies are written to json/,
+// so that the directory can be opened with any Maildir aware mail client.
+type FileSender struct {
+	Dir     string
+	Maildir bool
+	options *options
+}
+
+// maildirCounter keeps Maildir file names unique within the process
+var maildirCounter atomic.Uint64
+
+// NewFileSender creates a FileSender writing .eml files into dir, creating the directory if needed.
+// Of the options, WithLogger, WithDefaultFrom and WithDKIM apply to the file sink.
+func NewFileSender(dir string, opts ...Option) (*FileSender, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("Failed to create mail directory: %w", err)
+	}
+	o := newOptions(opts)
+	return &FileSender{Dir: dir, options: o}, nil
+}
+
+// NewMaildirSender creates a FileSender delivering into the Maildir at dir, creating tmp, new and cur if needed
+func NewMaildirSender(dir string, opts ...Option) (*FileSender, error) {
+	for _, sub := range []string{"tmp", "new", "cur", "json"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("Failed to create maildir: %w", err)
+		}
+	}
+	o := newOptions(opts)
+	return &FileSender{Dir: dir, Maildir: true, options: o}, nil
+}
+
+func (fs *FileSender) SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent string) (response *Response, err error) {
+	timer := stopwatch.Start("SendMail", stopwatch.LogStop)
+	defer func() {
+		timer.StopE(err)
+	}()
+
+	fromName, fromEmail = fs.options.from(fromName, fromEmail)
+	message, err := NewEmailMessage().
+		FromEmail(fromName, fromEmail).
+		AddRecipient(toName, toEmail).
+		Subject(subject).
+		PlainTextContent(plainTextContent).
+		HtmlContent(htmlContent).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	return fs.write(message)
+}
+
+func (fs *FileSender) SendMessage(message *Message) (response *Response, err error) {
+	timer := stopwatch.Start("SendMessage", stopwatch.LogStop)
+	defer func() {
+		timer.StopE(err)
+	}()
+
+	message = fs.options.withDefaults(message)
+	err = message.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if len(message.Personalizations) > 0 {
+		return sendPersonalized(fs, message)
+	}
+	return fs.write(message)
+}
+
+func (fs *FileSender) write(message *Message) (response *Response, err error) {
+	messageID := newMessageID(message)
+	eml, err := renderMIME(message, messageID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if fs.options != nil && fs.options.dkim != nil {
+		if eml, err = fs.options.dkim.Sign(eml); err != nil {
+			return nil, err
+		}
+	}
+	sidecar, err := json.MarshalIndent(message, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	var emlPath, jsonPath string
+	if fs.Maildir {
+		emlPath, jsonPath, err = fs.writeMaildir(eml)
+	} else {
+		name := strings.SplitN(messageID, "@", 2)[0]
+		emlPath = filepath.Join(fs.Dir, name+".eml")
+		jsonPath = filepath.Join(fs.Dir, name+".json")
+		err = os.WriteFile(emlPath, eml, 0o644)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to write email: %w", err)
+	}
+	if err = os.WriteFile(jsonPath, sidecar, 0o644); err != nil {
+		return nil, fmt.Errorf("Failed to write email json: %w", err)
+	}
+
+	fs.logf("Wrote email: message_id=%s, path=%s", messageID, emlPath)
+
+	return &Response{
+		StatusCode: 200,
+		Body:       emlPath,
+		Headers:    map[string][]string{"X-Message-Id": {messageID}},
+	}, nil
+}
+
+// writeMaildir delivers the message by writing it into tmp/ and renaming it into new/
+func (fs *FileSender) writeMaildir(eml []byte) (emlPath, jsonPath string, err error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	// '/' and ':' are not allowed within maildir unique names
+	hostname = strings.NewReplacer("/", "\\057", ":", "\\072").Replace(hostname)
+
+	now := time.Now()
+	unique := fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), maildirCounter.Add(1), hostname)
+
+	tmpPath := filepath.Join(fs.Dir, "tmp", unique)
+	if err = os.WriteFile(tmpPath, eml, 0o644); err != nil {
+		return "", "", err
+	}
+	emlPath = filepath.Join(fs.Dir, "new", unique)
+	if err = os.Rename(tmpPath, emlPath); err != nil {
+		return "", "", err
+	}
+	return emlPath, filepath.Join(fs.Dir, "json", unique+".json"), nil
+}
+
+// logf logs message either via defined user logger or via system one if no user logger is defined.
+func (fs *FileSender) logf(f string, args ...interface{}) {
+	if fs.options != nil && fs.options.logger != nil {
+		fs.options.logger(f, args...)
+	} else {
+		log.Printf(f, args...)
+	}
+}
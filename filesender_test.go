@@ -0,0 +1,85 @@
+package sendmail
+
+import (
+	"encoding/json"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMessage(t *testing.T) *Message {
+	message, err := NewEmailMessage().
+		FromEmail("Sender", "sender@example.com").
+		AddRecipient("Recipient", "recipient@example.com").
+		Subject("Test Subject").
+		PlainTextContent("Plain text content").
+		HtmlContent("<p>HTML content</p>").
+		AddAttachment("text/plain", "test.txt", "dGVzdCBjb250ZW50").
+		Build()
+	require.NoError(t, err)
+	return message
+}
+
+func TestFileSender_SendMessage(t *testing.T) {
+	dir := t.TempDir()
+	sender, err := NewFileSender(dir)
+	require.NoError(t, err)
+
+	response, err := sender.SendMessage(testMessage(t))
+	require.NoError(t, err)
+	assert.Equal(t, 200, response.StatusCode)
+
+	messageID := response.Headers["X-Message-Id"][0]
+	assert.True(t, strings.HasSuffix(messageID, "@example.com"))
+
+	eml, err := os.Open(response.Body)
+	require.NoError(t, err)
+	defer eml.Close()
+	parsed, err := mail.ReadMessage(eml)
+	require.NoError(t, err)
+	assert.Equal(t, "<"+messageID+">", parsed.Header.Get("Message-ID"))
+	assert.Equal(t, "Test Subject", parsed.Header.Get("Subject"))
+	assert.Contains(t, parsed.Header.Get("Content-Type"), "multipart/mixed")
+
+	sidecar, err := os.ReadFile(strings.TrimSuffix(response.Body, ".eml") + ".json")
+	require.NoError(t, err)
+	var original Message
+	require.NoError(t, json.Unmarshal(sidecar, &original))
+	assert.Equal(t, "recipient@example.com", original.Recipients[0].Address)
+}
+
+func TestFileSender_Maildir(t *testing.T) {
+	dir := t.TempDir()
+	sender, err := NewMaildirSender(dir)
+	require.NoError(t, err)
+
+	_, err = sender.SendMail("Sender", "sender@example.com", "Recipient", "recipient@example.com", "Subject", "text", "")
+	require.NoError(t, err)
+	_, err = sender.SendMail("Sender", "sender@example.com", "Recipient", "recipient@example.com", "Subject", "text", "")
+	require.NoError(t, err)
+
+	delivered, err := os.ReadDir(filepath.Join(dir, "new"))
+	require.NoError(t, err)
+	assert.Len(t, delivered, 2)
+
+	pending, err := os.ReadDir(filepath.Join(dir, "tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	sidecars, err := os.ReadDir(filepath.Join(dir, "json"))
+	require.NoError(t, err)
+	assert.Len(t, sidecars, 2)
+}
+
+func TestFileSender_InvalidMessage(t *testing.T) {
+	sender, err := NewFileSender(t.TempDir())
+	require.NoError(t, err)
+
+	_, err = sender.SendMessage(&Message{})
+	assert.ErrorIs(t, err, ErrMissingFrom)
+}
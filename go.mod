@@ -3,17 +3,19 @@ module github.com/malcolm-davis/go-sendmail
 go 1.25.0
 
 require (
+	github.com/andybalholm/cascadia v1.3.3
+	github.com/mailersend/mailersend-go v1.6.1
+	github.com/mailjet/mailjet-apiv3-go/v4 v4.0.7
 	github.com/sendgrid/rest v2.6.9+incompatible
 	github.com/sendgrid/sendgrid-go v3.16.1+incompatible
+	github.com/smtp2go-oss/smtp2go-go v1.0.4
 	github.com/stretchr/testify v1.11.0
+	golang.org/x/text v0.28.0
 )
 
 require (
 	github.com/google/go-querystring v1.1.0 // indirect
-	github.com/mailersend/mailersend-go v1.6.1 // indirect
-	github.com/mailjet/mailjet-apiv3-go/v4 v4.0.7 // indirect
 	github.com/malcolm-davis/go-random v0.0.0-20250813231649-6fc5951eb4b9 // indirect
-	github.com/smtp2go-oss/smtp2go-go v1.0.4 // indirect
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 	gopkg.in/mail.v2 v2.3.1 // indirect
 )
@@ -22,6 +24,6 @@ require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/malcolm-davis/go-stopwatch v0.0.0-20250818194927-94b25798f427
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/net v0.43.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	golang.org/x/net v0.43.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,339 @@
+package sendmail
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLToText converts HTML content into readable plain text. Paragraphs and headings become blocks separated by
+// blank lines, lists are bulleted or numbered, table rows become lines with the cells separated by " | ", and
+// links are numbered footnotes listed after the text.
+func HTMLToText(content string) string {
+	doc, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		// the HTML parser accepts any input, only a failing reader returns an error
+		return content
+	}
+	links := &textLinks{index: map[string]int{}}
+	w := &textWriter{links: links}
+	w.render(doc)
+
+	text := cleanText(w.String())
+	if len(links.urls) > 0 {
+		var footnotes strings.Builder
+		for i, url := range links.urls {
+			fmt.Fprintf(&footnotes, "\n[%d] %s", i+1, url)
+		}
+		text += "\n" + footnotes.String()
+	}
+	return text
+}
+
+// textLinks numbers the link targets, a repeated target keeps its number
+type textLinks struct {
+	urls  []string
+	index map[string]int
+}
+
+func (l *textLinks) number(url string) int {
+	if n, ok := l.index[url]; ok {
+		return n
+	}
+	l.urls = append(l.urls, url)
+	l.index[url] = len(l.urls)
+	return len(l.urls)
+}
+
+// textWriter collapses whitespace and separates blocks. Line breaks requested by a block are only written when
+// more text follows, so blocks never leave trailing blank lines.
+type textWriter struct {
+	buf     strings.Builder
+	pending int  // line breaks owed before the next text
+	space   bool // a collapsed space is owed before the next text
+	pre     bool // inside <pre>, whitespace is kept
+	nested  bool // inside a list item, nested lists are not set apart by a blank line
+	links   *textLinks
+}
+
+func (w *textWriter) String() string {
+	return w.buf.String()
+}
+
+func (w *textWriter) child() *textWriter {
+	return &textWriter{pre: w.pre, nested: w.nested, links: w.links}
+}
+
+func (w *textWriter) write(s string) {
+	if s == "" {
+		return
+	}
+	if w.buf.Len() > 0 {
+		if w.pending > 0 {
+			w.buf.WriteString(strings.Repeat("\n", w.pending))
+		} else if w.space {
+			w.buf.WriteByte(' ')
+		}
+	}
+	w.pending, w.space = 0, false
+	w.buf.WriteString(s)
+}
+
+// inline writes text, collapsing runs of whitespace into a single space
+func (w *textWriter) inline(s string) {
+	if w.pre {
+		w.write(s)
+		return
+	}
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		if s != "" {
+			w.space = true
+		}
+		return
+	}
+	if s[0] == ' ' || s[0] == '\t' || s[0] == '\n' || s[0] == '\r' {
+		w.space = true
+	}
+	for i, word := range words {
+		if i > 0 {
+			w.space = true
+		}
+		w.write(word)
+	}
+	if last, _ := utf8.DecodeLastRuneInString(s); last == ' ' || last == '\t' || last == '\n' || last == '\r' {
+		w.space = true
+	}
+}
+
+// breakLines ensures at least n line breaks before the next text
+func (w *textWriter) breakLines(n int) {
+	w.pending = max(w.pending, n)
+	w.space = false
+}
+
+// block writes s as a block set apart by n line breaks
+func (w *textWriter) block(s string, n int) {
+	if strings.TrimSpace(s) == "" {
+		return
+	}
+	w.breakLines(n)
+	w.write(s)
+	w.breakLines(n)
+}
+
+func (w *textWriter) renderChildren(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.render(c)
+	}
+}
+
+// renderBlock renders the children of n on their own and returns the cleaned text
+func (w *textWriter) renderBlock(n *html.Node, configure func(*textWriter)) string {
+	sub := w.child()
+	if configure != nil {
+		configure(sub)
+	}
+	sub.renderChildren(n)
+	return cleanText(sub.String())
+}
+
+func (w *textWriter) render(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.inline(n.Data)
+		return
+	case html.DocumentNode:
+		w.renderChildren(n)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Head, atom.Script, atom.Style, atom.Title, atom.Template:
+	case atom.Br:
+		w.pending = min(w.pending+1, 2)
+		w.space = false
+	case atom.Hr:
+		w.block("----------", 2)
+	case atom.P, atom.Address:
+		w.breakLines(2)
+		w.renderChildren(n)
+		w.breakLines(2)
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		text := w.renderBlock(n, nil)
+		switch n.DataAtom {
+		case atom.H1:
+			text += "\n" + strings.Repeat("=", utf8.RuneCountInString(text))
+		case atom.H2:
+			text += "\n" + strings.Repeat("-", utf8.RuneCountInString(text))
+		}
+		w.block(text, 2)
+	case atom.Ul, atom.Ol:
+		separation := 2
+		if w.nested {
+			separation = 1
+		}
+		w.block(w.renderList(n), separation)
+	case atom.Table:
+		w.block(w.renderTable(n), 2)
+	case atom.Blockquote:
+		text := w.renderBlock(n, nil)
+		w.block(prefixLines(text, "> ", ">"), 2)
+	case atom.Pre:
+		text := w.renderBlock(n, func(sub *textWriter) { sub.pre = true })
+		w.block(text, 2)
+	case atom.Img:
+		w.inline(attribute(n, "alt"))
+	case atom.A:
+		w.renderLink(n)
+	case atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Main, atom.Nav, atom.Aside,
+		atom.Form, atom.Center, atom.Dl, atom.Dt, atom.Dd, atom.Figure, atom.Figcaption, atom.Tr, atom.Li:
+		w.breakLines(1)
+		w.renderChildren(n)
+		w.breakLines(1)
+	default:
+		w.renderChildren(n)
+	}
+}
+
+// renderLink writes the link text followed by its footnote number. Links whose text is the target, and links
+// within the page, get no footnote.
+func (w *textWriter) renderLink(n *html.Node) {
+	start := w.buf.Len()
+	w.renderChildren(n)
+	text := strings.TrimSpace(w.buf.String()[start:])
+
+	href := strings.TrimSpace(attribute(n, "href"))
+	lower := strings.ToLower(href)
+	if text == "" || href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(lower, "javascript:") {
+		return
+	}
+	if text == href || "mailto:"+text == href || strings.TrimSuffix(href, "/") == text {
+		return
+	}
+	space := w.space
+	w.space = true
+	w.write("[" + strconv.Itoa(w.links.number(href)) + "]")
+	w.space = space
+}
+
+// renderList numbers or bullets the list items, continuation lines are indented under the item text
+func (w *textWriter) renderList(n *html.Node) string {
+	var items []string
+	number := 1
+	if start, err := strconv.Atoi(attribute(n, "start")); err == nil {
+		number = start
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Li {
+			continue
+		}
+		text := w.renderBlock(c, func(sub *textWriter) { sub.nested = true })
+		marker := "* "
+		if n.DataAtom == atom.Ol {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+		indent := strings.Repeat(" ", len(marker))
+		items = append(items, marker+strings.TrimPrefix(prefixLines(text, indent, ""), indent))
+	}
+	return strings.Join(items, "\n")
+}
+
+// renderTable writes a row per line with the cells separated by " | ". Layout tables, whose cells hold blocks of
+// text, are written as consecutive blocks instead.
+func (w *textWriter) renderTable(n *html.Node) string {
+	var text strings.Builder
+	previousSimple := false
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.DataAtom {
+			case atom.Thead, atom.Tbody, atom.Tfoot:
+				visit(c)
+			case atom.Tr:
+				var cells []string
+				simple := true
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type != html.ElementNode || (cell.DataAtom != atom.Td && cell.DataAtom != atom.Th) {
+						continue
+					}
+					if content := w.renderBlock(cell, nil); content != "" {
+						cells = append(cells, content)
+						simple = simple && !strings.Contains(content, "\n")
+					}
+				}
+				if len(cells) == 0 {
+					continue
+				}
+				row := strings.Join(cells, " | ")
+				if !simple {
+					row = strings.Join(cells, "\n\n")
+				}
+				if text.Len() > 0 {
+					if simple && previousSimple {
+						text.WriteString("\n")
+					} else {
+						text.WriteString("\n\n")
+					}
+				}
+				text.WriteString(row)
+				previousSimple = simple
+			}
+		}
+	}
+	visit(n)
+	return text.String()
+}
+
+func attribute(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// prefixLines prefixes every line of text, empty lines get emptyPrefix
+func prefixLines(text, prefix, emptyPrefix string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = emptyPrefix
+		} else {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cleanText drops trailing spaces and blank lines beyond one in a row
+func cleanText(text string) string {
+	lines := strings.Split(text, "\n")
+	kept := lines[:0]
+	blank := 0
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\u00a0")
+		if line == "" {
+			blank++
+			if blank > 1 {
+				continue
+			}
+		} else {
+			blank = 0
+		}
+		kept = append(kept, line)
+	}
+	return strings.Trim(strings.Join(kept, "\n"), "\n")
+}
@@ -0,0 +1,96 @@
+package sendmail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTMLToText(t *testing.T) {
+	content := `<html><head><title>Order</title><style>p { color: red }</style></head>
+<body>
+  <h1>Your order</h1>
+  <p>Hello   <b>Jane</b>,<br>thanks for your
+     order. <a href="https://shop.example.com/orders/42">View it online</a>.</p>
+  <h2>Items</h2>
+  <table>
+    <tr><th>Item</th><th>Qty</th></tr>
+    <tr><td>Socks</td><td>2</td></tr>
+  </table>
+  <ul>
+    <li>Free returns</li>
+    <li>Support: <a href="mailto:help@example.com">help@example.com</a>
+      <ol><li>Call</li><li>Write</li></ol>
+    </li>
+  </ul>
+  <blockquote><p>Great socks</p><p>- A customer</p></blockquote>
+  <p><a href="https://shop.example.com/orders/42">Track</a> &amp; <a href="#top">top</a>
+  <img src="logo.png" alt="Example Shop"></p>
+  <script>alert("x")</script>
+</body></html>`
+
+	expected := `Your order
+==========
+
+Hello Jane,
+thanks for your order. View it online [1].
+
+Items
+-----
+
+Item | Qty
+Socks | 2
+
+* Free returns
+* Support: help@example.com
+  1. Call
+  2. Write
+
+> Great socks
+>
+> - A customer
+
+Track [1] & top Example Shop
+
+[1] https://shop.example.com/orders/42`
+	assert.Equal(t, expected, HTMLToText(content))
+}
+
+func TestHTMLToText_LayoutTable(t *testing.T) {
+	content := `<table><tr><td><p>First paragraph</p><p>Second paragraph</p></td></tr>
+<tr><td>Footer</td></tr></table><pre>  keep
+    spacing</pre>`
+	assert.Equal(t, "First paragraph\n\nSecond paragraph\n\nFooter\n\n  keep\n    spacing", HTMLToText(content))
+}
+
+func TestMessageBuilder_AutoPlainText(t *testing.T) {
+	message, err := NewEmailMessage().
+		FromEmail("Shop", "shop@example.com").
+		AddRecipient("Jane", "jane@example.com").
+		Subject("Hello").
+		HtmlContent("<p>Hi <a href=\"https://example.com/a\">there</a></p>").
+		Build()
+	require.NoError(t, err)
+	assert.Equal(t, "Hi there [1]\n\n[1] https://example.com/a", message.PlainTextContent)
+
+	message, err = NewEmailMessage().
+		FromEmail("Shop", "shop@example.com").
+		AddRecipient("Jane", "jane@example.com").
+		Subject("Hello").
+		HtmlContent("<p>Hi</p>").
+		AutoPlainText(false).
+		Build()
+	require.NoError(t, err)
+	assert.Empty(t, message.PlainTextContent)
+
+	message, err = NewEmailMessage().
+		FromEmail("Shop", "shop@example.com").
+		AddRecipient("Jane", "jane@example.com").
+		Subject("Hello").
+		PlainTextContent("Own text").
+		HtmlContent("<p>Hi</p>").
+		Build()
+	require.NoError(t, err)
+	assert.Equal(t, "Own text", message.PlainTextContent)
+}
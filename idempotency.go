@@ -0,0 +1,238 @@
+package sendmail
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// DedupStore remembers the responses of messages sent with an idempotency key
+type DedupStore interface {
+	// Get returns the response stored for key, false when the key is unknown or expired
+	Get(ctx context.Context, key string) (*Response, bool, error)
+	// Put stores the response for key until the ttl expires
+	Put(ctx context.Context, key string, response *Response, ttl time.Duration) error
+}
+
+// IdempotentSender sends a message carrying an IdempotencyKey at most once within the TTL. A repeated key returns the
+// original response, marked with an Idempotent-Replayed header, without calling the sender again.
+// Failed sends are not recorded so they can be retried. Concurrent sends of the same key are serialized within the
+// process, a store shared between processes narrows but does not close the window for duplicates.
+// Deduplication is client side only: none of the providers in this package accept an idempotency key, so the key
+// is never sent to them. A send that fails after the provider accepted the message, e.g. on a timeout, is not
+// recorded, and its retry can deliver the message twice.
+type IdempotentSender struct {
+	sender SendMail
+	store  DedupStore
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// IdempotencyOption configures an IdempotentSender
+type IdempotencyOption func(*IdempotentSender)
+
+// WithIdempotencyTTL sets how long a key is remembered, default 24 hours
+func WithIdempotencyTTL(ttl time.Duration) IdempotencyOption {
+	return func(s *IdempotentSender) {
+		s.ttl = ttl
+	}
+}
+
+// NewIdempotentSender wraps sender, a nil store uses a MemoryDedupStore
+func NewIdempotentSender(sender SendMail, store DedupStore, opts ...IdempotencyOption) *IdempotentSender {
+	if store == nil {
+		store = NewMemoryDedupStore()
+	}
+	s := &IdempotentSender{
+		sender: sender,
+		store:  store,
+		ttl:    24 * time.Hour,
+		locks:  map[string]*keyLock{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// SendMail has no idempotency key and is passed straight through
+func (s *IdempotentSender) SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent string) (*Response, error) {
+	return s.sender.SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent)
+}
+
+func (s *IdempotentSender) SendMessage(message *Message) (*Response, error) {
+	return s.SendMessageContext(context.Background(), message)
+}
+
+// SendMessageContext passes the context to the dedup store
+func (s *IdempotentSender) SendMessageContext(ctx context.Context, message *Message) (*Response, error) {
+	if message == nil || message.IdempotencyKey == "" {
+		return s.sender.SendMessage(message)
+	}
+	key := message.IdempotencyKey
+
+	unlock := s.lock(key)
+	defer unlock()
+
+	previous, ok, err := s.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return replayed(previous), nil
+	}
+
+	response, err := s.sender.SendMessage(message)
+	if err != nil {
+		return response, err
+	}
+	// the message is sent, reporting a store failure would invite the caller to send it again
+	_ = s.store.Put(ctx, key, response, s.ttl)
+	return response, nil
+}
+
+// SendBatch replays the keys already sent and sends the other messages with a single SendBatch of the wrapped
+// sender, which keeps its native batch endpoint. A key repeated within the batch is sent once, its later messages
+// get the result of the first.
+func (s *IdempotentSender) SendBatch(ctx context.Context, messages []*Message, opts ...BatchOption) ([]BatchResult, error) {
+	results := newBatchResults(messages)
+	prepared := make([]*Message, len(messages))
+	first := map[string]int{}
+	var repeated []int
+	for i, message := range messages {
+		prepared[i] = message
+		if message == nil || message.IdempotencyKey == "" {
+			continue
+		}
+		if _, ok := first[message.IdempotencyKey]; ok {
+			prepared[i] = nil
+			repeated = append(repeated, i)
+			continue
+		}
+		first[message.IdempotencyKey] = i
+	}
+
+	// the keys are locked in order, so that batches sharing keys do not deadlock
+	keys := slices.Sorted(maps.Keys(first))
+	for _, key := range keys {
+		unlock := s.lock(key)
+		defer unlock()
+	}
+	for _, key := range keys {
+		i := first[key]
+		previous, ok, err := s.store.Get(ctx, key)
+		if err != nil || ok {
+			prepared[i] = nil
+			results[i].Err = err
+			if ok {
+				results[i].Response = replayed(previous)
+			}
+		}
+	}
+
+	err := forwardBatch(ctx, s.sender, prepared, results, opts...)
+	for _, key := range keys {
+		if i := first[key]; prepared[i] != nil && results[i].Err == nil {
+			// the message is sent, reporting a store failure would invite the caller to send it again
+			_ = s.store.Put(ctx, key, results[i].Response, s.ttl)
+		}
+	}
+	for _, i := range repeated {
+		if previous := results[first[messages[i].IdempotencyKey]]; previous.Err == nil {
+			results[i].Response = replayed(previous.Response)
+		} else {
+			results[i].Err = previous.Err
+		}
+	}
+	return results, err
+}
+
+// lock serializes sends of the same key, returning the unlock function
+func (s *IdempotentSender) lock(key string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[key]
+	if !ok {
+		l = &keyLock{}
+		s.locks[key] = l
+	}
+	l.waiters++
+	s.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(s.locks, key)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// replayed copies the stored response and marks it as a replay
+func replayed(response *Response) *Response {
+	if response == nil {
+		response = &Response{}
+	}
+	copied := *response
+	copied.Headers = maps.Clone(response.Headers)
+	if copied.Headers == nil {
+		copied.Headers = map[string][]string{}
+	}
+	copied.Headers["Idempotent-Replayed"] = []string{"true"}
+	return &copied
+}
+
+// MemoryDedupStore keeps idempotency keys in memory, expired keys are pruned at most once a minute as new ones are stored
+type MemoryDedupStore struct {
+	mu         sync.Mutex
+	entries    map[string]dedupEntry
+	lastPruned time.Time
+	now        func() time.Time
+}
+
+type dedupEntry struct {
+	response *Response
+	expires  time.Time
+}
+
+// NewMemoryDedupStore creates an empty in-memory dedup store
+func NewMemoryDedupStore() *MemoryDedupStore {
+	return &MemoryDedupStore{entries: map[string]dedupEntry{}, now: time.Now}
+}
+
+func (m *MemoryDedupStore) Get(ctx context.Context, key string) (*Response, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[key]
+	if !ok || !m.now().Before(entry.expires) {
+		return nil, false, nil
+	}
+	return entry.response, true, nil
+}
+
+func (m *MemoryDedupStore) Put(ctx context.Context, key string, response *Response, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if now.Sub(m.lastPruned) >= time.Minute {
+		for k, entry := range m.entries {
+			if !now.Before(entry.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.lastPruned = now
+	}
+	m.entries[key] = dedupEntry{response: response, expires: now.Add(ttl)}
+	return nil
+}
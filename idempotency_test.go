@@ -0,0 +1,107 @@
+package sendmail
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotentSender_ReplaysDuplicates(t *testing.T) {
+	backend := &fakeSender{response: &Response{StatusCode: 202, Body: "queued", Headers: map[string][]string{"X-Message-Id": {"abc"}}}}
+	sender := NewIdempotentSender(backend, nil)
+
+	message := testMessage(t)
+	message.IdempotencyKey = "order-42"
+
+	first, err := sender.SendMessage(message)
+	require.NoError(t, err)
+	second, err := sender.SendMessage(message)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, backend.count())
+	assert.Equal(t, 202, second.StatusCode)
+	assert.Equal(t, "queued", second.Body)
+	assert.Equal(t, []string{"abc"}, second.Headers["X-Message-Id"])
+	assert.Equal(t, []string{"true"}, second.Headers["Idempotent-Replayed"])
+	assert.NotContains(t, first.Headers, "Idempotent-Replayed")
+
+	// messages without a key are always sent
+	_, err = sender.SendMessage(testMessage(t))
+	require.NoError(t, err)
+	assert.Equal(t, 2, backend.count())
+}
+
+func TestIdempotentSender_FailuresAreRetried(t *testing.T) {
+	backend := &fakeSender{err: errors.New("unavailable")}
+	sender := NewIdempotentSender(backend, nil)
+
+	message := testMessage(t)
+	message.IdempotencyKey = "order-42"
+
+	_, err := sender.SendMessage(message)
+	assert.Error(t, err)
+	backend.setErr(nil)
+	_, err = sender.SendMessage(message)
+	require.NoError(t, err)
+	assert.Equal(t, 2, backend.count())
+}
+
+func TestIdempotentSender_ConcurrentDuplicates(t *testing.T) {
+	backend := &fakeSender{sendFunc: func(message *Message) (*Response, error) {
+		time.Sleep(10 * time.Millisecond)
+		return &Response{StatusCode: 202}, nil
+	}}
+	sender := NewIdempotentSender(backend, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			message := testMessage(t)
+			message.IdempotencyKey = "order-42"
+			_, err := sender.SendMessage(message)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, backend.count())
+	assert.Empty(t, sender.locks)
+}
+
+func TestMemoryDedupStore_Expiry(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryDedupStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Put(ctx, "key", &Response{StatusCode: 200}, time.Hour))
+	response, ok, err := store.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 200, response.StatusCode)
+
+	now = now.Add(time.Hour)
+	_, ok, err = store.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, store.Put(ctx, "other", &Response{}, time.Hour))
+	assert.Len(t, store.entries, 1)
+}
+
+func TestMessageBuilder_IdempotencyKey(t *testing.T) {
+	message, err := NewEmailMessage().
+		FromEmail("Sender", "sender@example.com").
+		AddRecipient("", "recipient@example.com").
+		Subject("Subject").
+		IdempotencyKey(" order-42 ").
+		Build()
+	require.NoError(t, err)
+	assert.Equal(t, "order-42", message.IdempotencyKey)
+}
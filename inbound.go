@@ -0,0 +1,526 @@
+// Inbound email webhooks. Providers receiving mail for a domain post it in their own format, it is parsed into an
+// InboundMessage using the Email and Attachment types messages are sent with.
+package sendmail
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// InboundMessage is an email received through a provider's inbound webhook. MessageID is the Message-ID header
+// without angle brackets, Raw holds the original MIME message when the provider posts it.
+type InboundMessage struct {
+	Provider         string
+	MessageID        string
+	From             *Email
+	To               []*Email
+	Cc               []*Email
+	ReplyTo          []*Email
+	Subject          string
+	PlainTextContent string
+	HtmlContent      string
+	Attachments      []*Attachment
+	Headers          map[string][]string
+	Date             time.Time
+	Raw              []byte
+}
+
+// InboundParser parses an inbound webhook request body with the given Content-Type
+type InboundParser func(contentType string, body []byte) (*InboundMessage, error)
+
+var inboundParsers = map[string]InboundParser{
+	"sendgrid":   parseSendGridInbound,
+	"mailgun":    parseMailgunInbound,
+	"postmark":   parsePostmarkInbound,
+	"mailersend": parseMailerSendInbound,
+}
+
+// ParseInbound parses the inbound webhook body posted by sendgrid, mailgun, postmark or mailersend
+func ParseInbound(provider, contentType string, body []byte) (*InboundMessage, error) {
+	parse, ok := inboundParsers[provider]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownWebhookProvider, provider)
+	}
+	return parse(contentType, body)
+}
+
+// InboundHandler is an http.Handler receiving the messages of a provider's inbound webhook. A callback error is
+// answered with a 500 so the provider retries the delivery.
+type InboundHandler struct {
+	webhookEndpoint
+	parse    InboundParser
+	callback func(ctx context.Context, message *InboundMessage) error
+}
+
+// NewInboundHandler creates the handler for the inbound webhook of the given provider
+func NewInboundHandler(provider string, callback func(ctx context.Context, message *InboundMessage) error, opts ...WebhookOption) (*InboundHandler, error) {
+	parse, ok := inboundParsers[provider]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownWebhookProvider, provider)
+	}
+	if callback == nil {
+		return nil, errors.New("sendmail: inbound callback is required")
+	}
+	return &InboundHandler{
+		webhookEndpoint: newWebhookEndpoint(provider, opts),
+		parse:           parse,
+		callback:        callback,
+	}, nil
+}
+
+func (h *InboundHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, ok := h.read(w, r)
+	if !ok {
+		return
+	}
+	message, err := h.parse(r.Header.Get("Content-Type"), body)
+	if err != nil {
+		h.reject(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.callback(r.Context(), message); err != nil {
+		h.forget(r)
+		h.reject(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseSendGridInbound parses SendGrid Inbound Parse, a multipart form with either the raw message in "email"
+// or the parsed fields and attachment1..N files
+// https://www.twilio.com/docs/sendgrid/for-developers/parsing-email/setting-up-the-inbound-parse-webhook
+func parseSendGridInbound(contentType string, body []byte) (*InboundMessage, error) {
+	form, err := parseInboundForm(contentType, body)
+	if err != nil {
+		return nil, fmt.Errorf("SendGrid inbound: %w", err)
+	}
+	if raw := formValue(form, "email"); raw != "" {
+		return inboundFromMIME("sendgrid", []byte(raw))
+	}
+
+	// charsets gives the charset of each field, e.g. {"subject":"iso-8859-1","text":"iso-8859-1"}
+	charsets := map[string]string{}
+	if value := formValue(form, "charsets"); value != "" {
+		if err := json.Unmarshal([]byte(value), &charsets); err != nil {
+			return nil, fmt.Errorf("SendGrid inbound charsets: %w", err)
+		}
+	}
+	field := func(name string) string {
+		return string(decodeCharset(charsets[name], []byte(formValue(form, name))))
+	}
+
+	message := &InboundMessage{
+		Provider:         "sendgrid",
+		From:             parseEmail(field("from")),
+		To:               parseEmailList(field("to")),
+		Cc:               parseEmailList(field("cc")),
+		Subject:          field("subject"),
+		PlainTextContent: field("text"),
+		HtmlContent:      field("html"),
+	}
+	if headers := field("headers"); headers != "" {
+		header, err := mail.ReadMessage(strings.NewReader(strings.TrimRight(headers, "\r\n") + "\r\n\r\n"))
+		if err == nil {
+			applyInboundHeader(message, header.Header)
+		}
+	}
+
+	// attachment-info describes the files, keyed by their form name
+	info := map[string]struct {
+		Filename  string `json:"filename"`
+		Type      string `json:"type"`
+		ContentID string `json:"content-id"`
+	}{}
+	if value := formValue(form, "attachment-info"); value != "" {
+		if err := json.Unmarshal([]byte(value), &info); err != nil {
+			return nil, fmt.Errorf("SendGrid inbound attachment-info: %w", err)
+		}
+	}
+	message.Attachments, err = formAttachments(form, func(name string, attachment *Attachment) {
+		if described, ok := info[name]; ok {
+			if described.Filename != "" {
+				attachment.Filename = described.Filename
+			}
+			if described.Type != "" {
+				attachment.ContentType = described.Type
+			}
+			if described.ContentID != "" {
+				attachment.Disposition = "inline"
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("SendGrid inbound: %w", err)
+	}
+	return message, nil
+}
+
+// parseMailgunInbound parses a Mailgun route forwarding to a URL. Routes to a URL ending in "mime" post the raw
+// message in "body-mime", other routes the parsed fields and attachment-1..N files.
+// https://documentation.mailgun.com/docs/mailgun/user-manual/receive-forward-store/
+func parseMailgunInbound(contentType string, body []byte) (*InboundMessage, error) {
+	form, err := parseInboundForm(contentType, body)
+	if err != nil {
+		return nil, fmt.Errorf("Mailgun inbound: %w", err)
+	}
+	if raw := formValue(form, "body-mime"); raw != "" {
+		return inboundFromMIME("mailgun", []byte(raw))
+	}
+
+	message := &InboundMessage{
+		Provider:         "mailgun",
+		From:             parseEmail(formValue(form, "from")),
+		To:               parseEmailList(formValue(form, "To")),
+		Cc:               parseEmailList(formValue(form, "Cc")),
+		Subject:          formValue(form, "subject"),
+		PlainTextContent: formValue(form, "body-plain"),
+		HtmlContent:      formValue(form, "body-html"),
+	}
+	if len(message.To) == 0 {
+		message.To = parseEmailList(formValue(form, "recipient"))
+	}
+	// message-headers is a JSON list of [name, value] pairs
+	if value := formValue(form, "message-headers"); value != "" {
+		var pairs [][]string
+		if err := json.Unmarshal([]byte(value), &pairs); err != nil {
+			return nil, fmt.Errorf("Mailgun inbound message-headers: %w", err)
+		}
+		header := mail.Header{}
+		for _, pair := range pairs {
+			if len(pair) == 2 {
+				key := http.CanonicalHeaderKey(pair[0])
+				header[key] = append(header[key], pair[1])
+			}
+		}
+		applyInboundHeader(message, header)
+	}
+
+	message.Attachments, err = formAttachments(form, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Mailgun inbound: %w", err)
+	}
+	return message, nil
+}
+
+// postmarkInbound is the Postmark inbound webhook payload
+// https://postmarkapp.com/developer/webhooks/inbound-webhook
+type postmarkInbound struct {
+	MessageID string
+	FromFull  postmarkAddress
+	ToFull    []postmarkAddress
+	CcFull    []postmarkAddress
+	ReplyTo   string
+	Subject   string
+	Date      string
+	TextBody  string
+	HtmlBody  string
+	RawEmail  string
+	Headers   []struct {
+		Name  string
+		Value string
+	}
+	Attachments []struct {
+		Name        string
+		Content     string
+		ContentType string
+		ContentID   string
+	}
+}
+
+type postmarkAddress struct {
+	Email string
+	Name  string
+}
+
+func (a postmarkAddress) email() *Email {
+	return &Email{Name: a.Name, Address: a.Email}
+}
+
+// parsePostmarkInbound parses the Postmark inbound JSON, RawEmail is present when raw content is enabled
+func parsePostmarkInbound(contentType string, body []byte) (*InboundMessage, error) {
+	payload := postmarkInbound{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("Postmark inbound: %w", err)
+	}
+	if payload.RawEmail != "" {
+		return inboundFromMIME("postmark", []byte(payload.RawEmail))
+	}
+
+	message := &InboundMessage{
+		Provider:         "postmark",
+		From:             payload.FromFull.email(),
+		ReplyTo:          parseEmailList(payload.ReplyTo),
+		Subject:          payload.Subject,
+		PlainTextContent: payload.TextBody,
+		HtmlContent:      payload.HtmlBody,
+	}
+	for _, to := range payload.ToFull {
+		message.To = append(message.To, to.email())
+	}
+	for _, cc := range payload.CcFull {
+		message.Cc = append(message.Cc, cc.email())
+	}
+	header := mail.Header{}
+	if payload.Date != "" {
+		header["Date"] = []string{payload.Date}
+	}
+	for _, h := range payload.Headers {
+		key := http.CanonicalHeaderKey(h.Name)
+		header[key] = append(header[key], h.Value)
+	}
+	applyInboundHeader(message, header)
+	// the Postmark id is used when the Message-ID header is missing
+	if message.MessageID == "" {
+		message.MessageID = payload.MessageID
+	}
+	for _, attachment := range payload.Attachments {
+		disposition := "attachment"
+		if attachment.ContentID != "" {
+			disposition = "inline"
+		}
+		message.Attachments = append(message.Attachments, &Attachment{
+			ContentType:   attachment.ContentType,
+			Filename:      attachment.Name,
+			Base64Content: attachment.Content,
+			Disposition:   disposition,
+		})
+	}
+	return message, nil
+}
+
+// mailerSendInbound is the MailerSend inbound route payload
+// https://developers.mailersend.com/api/v1/inbound.html
+type mailerSendInbound struct {
+	Data struct {
+		ID          string                 `json:"id"`
+		Raw         string                 `json:"raw"`
+		From        mailerSendAddress      `json:"from"`
+		To          []mailerSendAddress    `json:"to"`
+		Cc          []mailerSendAddress    `json:"cc"`
+		Subject     string                 `json:"subject"`
+		Text        string                 `json:"text"`
+		HTML        string                 `json:"html"`
+		Headers     map[string]interface{} `json:"headers"`
+		Attachments []struct {
+			Filename    string `json:"filename"`
+			ContentType string `json:"content_type"`
+			Content     string `json:"content"`
+			Disposition string `json:"disposition"`
+		} `json:"attachments"`
+	} `json:"data"`
+}
+
+type mailerSendAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+// parseMailerSendInbound parses a MailerSend inbound route, the raw message is used when present
+func parseMailerSendInbound(contentType string, body []byte) (*InboundMessage, error) {
+	payload := mailerSendInbound{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("MailerSend inbound: %w", err)
+	}
+	data := payload.Data
+	if data.Raw != "" {
+		return inboundFromMIME("mailersend", []byte(data.Raw))
+	}
+
+	message := &InboundMessage{
+		Provider:         "mailersend",
+		From:             &Email{Name: data.From.Name, Address: data.From.Email},
+		Subject:          data.Subject,
+		PlainTextContent: data.Text,
+		HtmlContent:      data.HTML,
+	}
+	for _, to := range data.To {
+		message.To = append(message.To, &Email{Name: to.Name, Address: to.Email})
+	}
+	for _, cc := range data.Cc {
+		message.Cc = append(message.Cc, &Email{Name: cc.Name, Address: cc.Email})
+	}
+	header := mail.Header{}
+	for name, value := range data.Headers {
+		key := http.CanonicalHeaderKey(name)
+		switch value := value.(type) {
+		case string:
+			header[key] = append(header[key], value)
+		case []interface{}:
+			for _, v := range value {
+				header[key] = append(header[key], fmt.Sprint(v))
+			}
+		}
+	}
+	applyInboundHeader(message, header)
+	if message.MessageID == "" {
+		message.MessageID = data.ID
+	}
+	for _, attachment := range data.Attachments {
+		message.Attachments = append(message.Attachments, &Attachment{
+			ContentType:   attachment.ContentType,
+			Filename:      attachment.Filename,
+			Base64Content: attachment.Content,
+			Disposition:   attachment.Disposition,
+		})
+	}
+	return message, nil
+}
+
+// inboundFromMIME parses a raw message, keeping it in Raw
+func inboundFromMIME(provider string, raw []byte) (*InboundMessage, error) {
+	parsed, err := parseMIME(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s inbound raw message: %w", provider, err)
+	}
+	message := &InboundMessage{
+		Provider:         provider,
+		PlainTextContent: parsed.Text,
+		HtmlContent:      parsed.HTML,
+		Attachments:      parsed.Attachments,
+		Raw:              raw,
+	}
+	applyInboundHeader(message, parsed.Header)
+	return message, nil
+}
+
+// applyInboundHeader records the header and fills the fields the provider did not post separately
+func applyInboundHeader(message *InboundMessage, header mail.Header) {
+	message.Headers = map[string][]string(header)
+	message.MessageID = strings.Trim(strings.TrimSpace(header.Get("Message-Id")), "<>")
+	if date, err := header.Date(); err == nil {
+		message.Date = date
+	}
+	if message.From == nil || message.From.Address == "" {
+		message.From = parseEmail(header.Get("From"))
+	}
+	if len(message.To) == 0 {
+		message.To = parseEmailList(header.Get("To"))
+	}
+	if len(message.Cc) == 0 {
+		message.Cc = parseEmailList(header.Get("Cc"))
+	}
+	if len(message.ReplyTo) == 0 {
+		message.ReplyTo = parseEmailList(header.Get("Reply-To"))
+	}
+	if message.Subject == "" {
+		message.Subject = decodeHeader(header.Get("Subject"))
+	}
+}
+
+// parseEmail parses a single address, an unparsable value is kept as the address
+func parseEmail(value string) *Email {
+	if list := parseEmailList(value); len(list) > 0 {
+		return list[0]
+	}
+	return nil
+}
+
+func parseEmailList(value string) []*Email {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	parser := &mail.AddressParser{WordDecoder: &mime.WordDecoder{CharsetReader: charsetReader}}
+	addresses, err := parser.ParseList(value)
+	if err != nil {
+		return []*Email{{Address: value}}
+	}
+	list := make([]*Email, 0, len(addresses))
+	for _, address := range addresses {
+		list = append(list, &Email{Name: address.Name, Address: address.Address})
+	}
+	return list
+}
+
+// parseInboundForm reads a multipart or url encoded form from the body
+func parseInboundForm(contentType string, body []byte) (*multipart.Form, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	switch mediaType {
+	case "multipart/form-data":
+		return multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(int64(len(body)) + 1)
+	case "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		return &multipart.Form{Value: values}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content type %s", mediaType)
+	}
+}
+
+func formValue(form *multipart.Form, key string) string {
+	if values := form.Value[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// formAttachments returns the uploaded files in the order they are numbered, describe can amend each attachment
+func formAttachments(form *multipart.Form, describe func(name string, attachment *Attachment)) ([]*Attachment, error) {
+	names := make([]string, 0, len(form.File))
+	for name := range form.File {
+		names = append(names, name)
+	}
+	// attachment2 sorts before attachment10
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) < len(names[j])
+		}
+		return names[i] < names[j]
+	})
+
+	var attachments []*Attachment
+	for _, name := range names {
+		for _, file := range form.File[name] {
+			content, err := readFormFile(file)
+			if err != nil {
+				return nil, err
+			}
+			contentType := file.Header.Get("Content-Type")
+			if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+				contentType = mediaType
+			}
+			attachment := &Attachment{
+				ContentType:   contentType,
+				Filename:      file.Filename,
+				Base64Content: base64.StdEncoding.EncodeToString(content),
+				Disposition:   "attachment",
+			}
+			if describe != nil {
+				describe(name, attachment)
+			}
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments, nil
+}
+
+func readFormFile(file *multipart.FileHeader) ([]byte, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	content, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("attachment %q: %w", file.Filename, err)
+	}
+	return content, nil
+}
@@ -0,0 +1,212 @@
+package sendmail
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// multipartForm encodes fields and files as multipart/form-data, files are given as name, filename, content type
+// and content
+func multipartForm(t *testing.T, fields map[string]string, files ...[4]string) (string, []byte) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for key, value := range fields {
+		require.NoError(t, writer.WriteField(key, value))
+	}
+	for _, file := range files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="`+file[0]+`"; filename="`+file[1]+`"`)
+		header.Set("Content-Type", file[2])
+		part, err := writer.CreatePart(header)
+		require.NoError(t, err)
+		part.Write([]byte(file[3]))
+	}
+	require.NoError(t, writer.Close())
+	return writer.FormDataContentType(), body.Bytes()
+}
+
+func TestParseInbound_SendGrid(t *testing.T) {
+	contentType, body := multipartForm(t, map[string]string{
+		"from":            `Jane Doe <jane@example.com>`,
+		"to":              "support@example.org",
+		"subject":         "Re: Ticket 42",
+		"text":            "It works now",
+		"html":            "<p>It works now</p>",
+		"headers":         "Message-ID: <abc@mail.example.com>\nDate: Mon, 01 Jan 2024 10:00:00 +0000\nIn-Reply-To: <ticket-42@example.org>\n",
+		"attachment-info": `{"attachment1":{"filename":"log.txt","type":"text/plain"},"attachment2":{"filename":"logo.png","type":"image/png","content-id":"logo"}}`,
+	}, [4]string{"attachment1", "log.txt", "text/plain", "line 1"}, [4]string{"attachment2", "logo.png", "image/png", "png"})
+
+	message, err := ParseInbound("sendgrid", contentType, body)
+	require.NoError(t, err)
+	assert.Equal(t, &Email{Name: "Jane Doe", Address: "jane@example.com"}, message.From)
+	assert.Equal(t, []*Email{{Address: "support@example.org"}}, message.To)
+	assert.Equal(t, "Re: Ticket 42", message.Subject)
+	assert.Equal(t, "It works now", message.PlainTextContent)
+	assert.Equal(t, "abc@mail.example.com", message.MessageID)
+	assert.Equal(t, []string{"<ticket-42@example.org>"}, message.Headers["In-Reply-To"])
+	assert.Equal(t, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), message.Date.UTC())
+	require.Len(t, message.Attachments, 2)
+	assert.Equal(t, &Attachment{ContentType: "text/plain", Filename: "log.txt", Base64Content: base64.StdEncoding.EncodeToString([]byte("line 1")), Disposition: "attachment"}, message.Attachments[0])
+	assert.Equal(t, "inline", message.Attachments[1].Disposition)
+	assert.Nil(t, message.Raw)
+}
+
+func TestParseInbound_SendGridRaw(t *testing.T) {
+	raw, err := renderMIME(testMessage(t), "raw@example.com", time.Now())
+	require.NoError(t, err)
+	contentType, body := multipartForm(t, map[string]string{"email": string(raw), "to": "ignored@example.org"})
+
+	message, err := ParseInbound("sendgrid", contentType, body)
+	require.NoError(t, err)
+	expected := testMessage(t)
+	assert.Equal(t, raw, message.Raw)
+	assert.Equal(t, "raw@example.com", message.MessageID)
+	assert.Equal(t, expected.Subject, message.Subject)
+	assert.Equal(t, expected.Recipients[0].Address, message.To[0].Address)
+	assert.Equal(t, expected.PlainTextContent, message.PlainTextContent)
+}
+
+func TestParseInbound_Charset(t *testing.T) {
+	// a Windows-1252 message, as sent by older desktop clients
+	raw := []byte("From: =?windows-1252?Q?Ren=E9_M=FCller?= <rene@example.com>\r\n" +
+		"To: support@example.org\r\n" +
+		"Subject: =?iso-8859-1?Q?Caf=E9_cr=E8me?=\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/alternative; boundary=b1\r\n" +
+		"\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain; charset=windows-1252\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		"Price: 5 \x80, caf\xe9\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/html; charset=ISO-8859-1\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"<p>Caf=E9 cr=E8me</p>\r\n" +
+		"--b1--\r\n")
+	contentType, body := multipartForm(t, map[string]string{"email": string(raw)})
+
+	message, err := ParseInbound("sendgrid", contentType, body)
+	require.NoError(t, err)
+	assert.Equal(t, "Café crème", message.Subject)
+	assert.Equal(t, &Email{Name: "René Müller", Address: "rene@example.com"}, message.From)
+	assert.Equal(t, "Price: 5 €, café", message.PlainTextContent)
+	assert.Equal(t, "<p>Café crème</p>", message.HtmlContent)
+}
+
+func TestParseInbound_SendGridCharsets(t *testing.T) {
+	contentType, body := multipartForm(t, map[string]string{
+		"from":     "Ren\xe9 M\xfcller <rene@example.com>",
+		"to":       "support@example.org",
+		"subject":  "Caf\xe9 cr\xe8me",
+		"text":     "\x93\xfa\x96\x7b\x8c\xea",
+		"html":     "<p>caf\xe9</p>",
+		"charsets": `{"from":"iso-8859-1","to":"UTF-8","subject":"iso-8859-1","text":"Shift_JIS","html":"iso-8859-1"}`,
+	})
+
+	message, err := ParseInbound("sendgrid", contentType, body)
+	require.NoError(t, err)
+	assert.Equal(t, &Email{Name: "René Müller", Address: "rene@example.com"}, message.From)
+	assert.Equal(t, "Café crème", message.Subject)
+	assert.Equal(t, "日本語", message.PlainTextContent)
+	assert.Equal(t, "<p>café</p>", message.HtmlContent)
+
+	contentType, body = multipartForm(t, map[string]string{"subject": "x", "charsets": "{"})
+	_, err = ParseInbound("sendgrid", contentType, body)
+	assert.Error(t, err)
+}
+
+func TestParseInbound_Mailgun(t *testing.T) {
+	contentType, body := multipartForm(t, map[string]string{
+		"recipient":       "support@example.org",
+		"from":            "Jane Doe <jane@example.com>",
+		"subject":         "Re: Ticket 42",
+		"body-plain":      "Thanks",
+		"message-headers": `[["Message-Id","<mg@example.com>"],["Reply-To","Help <help@example.com>"]]`,
+	}, [4]string{"attachment-1", "a.txt", "text/plain; charset=utf-8", "a"})
+
+	message, err := ParseInbound("mailgun", contentType, body)
+	require.NoError(t, err)
+	assert.Equal(t, "mailgun", message.Provider)
+	assert.Equal(t, []*Email{{Address: "support@example.org"}}, message.To)
+	assert.Equal(t, "mg@example.com", message.MessageID)
+	assert.Equal(t, []*Email{{Name: "Help", Address: "help@example.com"}}, message.ReplyTo)
+	require.Len(t, message.Attachments, 1)
+	assert.Equal(t, "text/plain", message.Attachments[0].ContentType)
+}
+
+func TestParseInbound_Postmark(t *testing.T) {
+	body := `{
+		"MessageID":"pm-1","FromFull":{"Email":"jane@example.com","Name":"Jane"},
+		"ToFull":[{"Email":"support@example.org","Name":""}],"CcFull":[{"Email":"boss@example.com","Name":"Boss"}],
+		"Subject":"Question","Date":"Mon, 1 Jan 2024 10:00:00 +0000","TextBody":"Hello","HtmlBody":"<p>Hello</p>",
+		"Headers":[{"Name":"X-Spam-Status","Value":"No"}],
+		"Attachments":[{"Name":"a.txt","Content":"YQ==","ContentType":"text/plain","ContentID":""}]
+	}`
+	message, err := ParseInbound("postmark", "application/json", []byte(body))
+	require.NoError(t, err)
+	assert.Equal(t, &InboundMessage{
+		Provider:         "postmark",
+		MessageID:        "pm-1",
+		From:             &Email{Name: "Jane", Address: "jane@example.com"},
+		To:               []*Email{{Address: "support@example.org"}},
+		Cc:               []*Email{{Name: "Boss", Address: "boss@example.com"}},
+		Subject:          "Question",
+		PlainTextContent: "Hello",
+		HtmlContent:      "<p>Hello</p>",
+		Attachments:      []*Attachment{{ContentType: "text/plain", Filename: "a.txt", Base64Content: "YQ==", Disposition: "attachment"}},
+		Headers:          map[string][]string{"Date": {"Mon, 1 Jan 2024 10:00:00 +0000"}, "X-Spam-Status": {"No"}},
+		Date:             message.Date,
+	}, message)
+	assert.Equal(t, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), message.Date.UTC())
+}
+
+func TestParseInbound_MailerSend(t *testing.T) {
+	body := `{"type":"inbound.message","data":{"id":"ms-1","from":{"email":"jane@example.com","name":"Jane"},
+		"to":[{"email":"support@example.org","name":"Support"}],"subject":"Hi","text":"Hello",
+		"headers":{"Message-ID":"<ms@example.com>","Received":["a","b"]},
+		"attachments":[{"filename":"a.txt","content_type":"text/plain","content":"YQ==","disposition":"attachment"}]}}`
+	message, err := ParseInbound("mailersend", "application/json", []byte(body))
+	require.NoError(t, err)
+	assert.Equal(t, "ms@example.com", message.MessageID)
+	assert.Equal(t, []*Email{{Name: "Support", Address: "support@example.org"}}, message.To)
+	assert.Equal(t, []string{"a", "b"}, message.Headers["Received"])
+	require.Len(t, message.Attachments, 1)
+}
+
+func TestInboundHandler(t *testing.T) {
+	var received *InboundMessage
+	handler, err := NewInboundHandler("postmark", func(ctx context.Context, message *InboundMessage) error {
+		received = message
+		return nil
+	})
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/inbound", bytes.NewReader([]byte(`{"Subject":"Hi","FromFull":{"Email":"jane@example.com"}}`)))
+	request.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	require.NotNil(t, received)
+	assert.Equal(t, "Hi", received.Subject)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/inbound", bytes.NewReader([]byte("{"))))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	_, err = ParseInbound("sendgrid", "text/plain", nil)
+	assert.Error(t, err)
+	_, err = NewInboundHandler("smtp2go", func(context.Context, *InboundMessage) error { return nil })
+	assert.ErrorIs(t, err, ErrUnknownWebhookProvider)
+}
@@ -0,0 +1,132 @@
+package integration
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/emersion/go-msgauth/dkim"
+	sendmail "github.com/malcolm-davis/go-sendmail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dkimKeys generates the keys of the tests, an RSA key at rsa._domainkey.example.com and an Ed25519 key at
+// ed._domainkey.example.com, with a resolver serving their records
+func dkimKeys(t *testing.T) (*rsa.PrivateKey, ed25519.PrivateKey, func(string) ([]string, error)) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	records := map[string]string{}
+	for name, key := range map[string]crypto.Signer{"rsa._domainkey.example.com": rsaKey, "ed._domainkey.example.com": edKey} {
+		records[name], err = sendmail.DKIMRecord(key)
+		require.NoError(t, err)
+	}
+	lookup := func(domain string) ([]string, error) {
+		if record, ok := records[domain]; ok {
+			return []string{record}, nil
+		}
+		return nil, fmt.Errorf("no TXT record for %s", domain)
+	}
+	return rsaKey, edKey, lookup
+}
+
+func verifyDKIM(t *testing.T, message []byte, lookup func(string) ([]string, error)) []*dkim.Verification {
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(message), &dkim.VerifyOptions{LookupTXT: lookup})
+	require.NoError(t, err)
+	return verifications
+}
+
+// renderedMessage renders a message through the file sink, which writes the MIME form sent over SMTP
+func renderedMessage(t *testing.T) []byte {
+	message := testMessage(t)
+	message.HtmlContent = "<p>Hello   there</p>\n\n\n"
+	message.Unsubscribe = &sendmail.Unsubscribe{URL: "https://example.com/unsubscribe?token=abc"}
+
+	sender, err := sendmail.NewFileSender(t.TempDir(), sendmail.WithLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+	response, err := sender.SendMessage(message)
+	require.NoError(t, err)
+	raw, err := os.ReadFile(response.Body)
+	require.NoError(t, err)
+	return raw
+}
+
+func TestDKIMSigner_Sign(t *testing.T) {
+	rsaKey, edKey, lookup := dkimKeys(t)
+	raw := renderedMessage(t)
+
+	canonicalizations := [][2]sendmail.DKIMCanonicalization{
+		{sendmail.DKIMRelaxed, sendmail.DKIMRelaxed}, {sendmail.DKIMSimple, sendmail.DKIMSimple},
+		{sendmail.DKIMRelaxed, sendmail.DKIMSimple}, {sendmail.DKIMSimple, sendmail.DKIMRelaxed},
+	}
+	for _, c := range canonicalizations {
+		t.Run(string(c[0])+"/"+string(c[1]), func(t *testing.T) {
+			signer, err := sendmail.NewDKIMSigner("example.com", "rsa", rsaKey,
+				sendmail.WithDKIMKey("example.com", "ed", edKey),
+				sendmail.WithDKIMCanonicalization(c[0], c[1]))
+			require.NoError(t, err)
+
+			signed, err := signer.Sign(raw)
+			require.NoError(t, err)
+			assert.True(t, bytes.HasSuffix(signed, raw), "the message follows the signatures unchanged")
+
+			verifications := verifyDKIM(t, signed, lookup)
+			require.Len(t, verifications, 2)
+			for _, verification := range verifications {
+				require.NoError(t, verification.Err)
+				assert.Equal(t, "example.com", verification.Domain)
+				assert.Contains(t, verification.HeaderKeys, "from")
+				assert.Contains(t, verification.HeaderKeys, "list-unsubscribe-post")
+			}
+		})
+	}
+}
+
+func TestFileSender_DKIM(t *testing.T) {
+	_, edKey, lookup := dkimKeys(t)
+	signer, err := sendmail.NewDKIMSigner("example.com", "ed", edKey)
+	require.NoError(t, err)
+
+	sender, err := sendmail.NewFileSender(t.TempDir(), sendmail.WithDKIM(signer), sendmail.WithLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+	response, err := sender.SendMessage(testMessage(t))
+	require.NoError(t, err)
+	raw, err := os.ReadFile(response.Body)
+	require.NoError(t, err)
+
+	verifications := verifyDKIM(t, raw, lookup)
+	require.Len(t, verifications, 1)
+	assert.NoError(t, verifications[0].Err)
+}
+
+func TestDKIMSigner_DetectsTampering(t *testing.T) {
+	rsaKey, _, lookup := dkimKeys(t)
+	signer, err := sendmail.NewDKIMSigner("example.com", "rsa", rsaKey, sendmail.WithDKIMHeaders("From", "Subject"))
+	require.NoError(t, err)
+	signed, err := signer.Sign(renderedMessage(t))
+	require.NoError(t, err)
+
+	verifications := verifyDKIM(t, signed, lookup)
+	require.Len(t, verifications, 1)
+	require.NoError(t, verifications[0].Err)
+	assert.Equal(t, []string{"from", "subject"}, verifications[0].HeaderKeys)
+
+	// relaxed canonicalization accepts whitespace changes made by relays
+	relayed := bytes.Replace(signed, []byte("Subject: "), []byte("subject:    "), 1)
+	require.NoError(t, verifyDKIM(t, relayed, lookup)[0].Err)
+
+	tampered := bytes.Replace(signed, []byte("Test Subject"), []byte("Other Subject"), 1)
+	assert.Error(t, verifyDKIM(t, tampered, lookup)[0].Err)
+
+	index := bytes.Index(signed, []byte("\r\n\r\n"))
+	tampered = append(append([]byte{}, signed[:index]...), []byte("\r\n\r\nAppended body\r\n")...)
+	assert.Error(t, verifyDKIM(t, tampered, lookup)[0].Err)
+}
@@ -0,0 +1,4 @@
+// Package integration holds the tests that need a database driver or a third party DKIM verifier.
+// It is a separate module so that users of go-sendmail never download those dependencies.
+// Run the tests with go test ./... from this directory.
+package integration
@@ -0,0 +1,40 @@
+module github.com/malcolm-davis/go-sendmail/integration
+
+go 1.25.0
+
+require (
+	github.com/emersion/go-msgauth v0.7.0
+	github.com/malcolm-davis/go-sendmail v0.0.0
+	github.com/stretchr/testify v1.11.0
+	modernc.org/sqlite v1.38.2
+)
+
+require (
+	github.com/andybalholm/cascadia v1.3.3 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/go-querystring v1.1.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mailersend/mailersend-go v1.6.1 // indirect
+	github.com/mailjet/mailjet-apiv3-go/v4 v4.0.7 // indirect
+	github.com/malcolm-davis/go-random v0.0.0-20250813231649-6fc5951eb4b9 // indirect
+	github.com/malcolm-davis/go-stopwatch v0.0.0-20250818194927-94b25798f427 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	github.com/sendgrid/rest v2.6.9+incompatible // indirect
+	github.com/sendgrid/sendgrid-go v3.16.1+incompatible // indirect
+	github.com/smtp2go-oss/smtp2go-go v1.0.4 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/exp v0.0.0-20250620022241-b7579e27df2b // indirect
+	golang.org/x/net v0.43.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+	modernc.org/libc v1.66.3 // indirect
+	modernc.org/mathutil v1.7.1 // indirect
+	modernc.org/memory v1.11.0 // indirect
+)
+
+replace github.com/malcolm-davis/go-sendmail => ../
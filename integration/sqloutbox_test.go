@@ -0,0 +1,171 @@
+package integration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	sendmail "github.com/malcolm-davis/go-sendmail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func testMessage(t *testing.T) *sendmail.Message {
+	message, err := sendmail.NewEmailMessage().
+		FromEmail("Sender", "sender@example.com").
+		AddRecipient("Recipient", "recipient@example.com").
+		Subject("Test Subject").
+		PlainTextContent("Plain text content").
+		HtmlContent("<p>HTML content</p>").
+		Build()
+	require.NoError(t, err)
+	return message
+}
+
+func openSQLite(t *testing.T, path string) *sql.DB {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newSQLiteOutbox(t *testing.T) (*sql.DB, *sendmail.SQLOutboxStore) {
+	db := openSQLite(t, filepath.Join(t.TempDir(), "outbox.db"))
+	store, err := sendmail.NewSQLOutboxStore(db, sendmail.DialectSQLite)
+	require.NoError(t, err)
+	require.NoError(t, store.Migrate(context.Background()))
+	// migrations are applied once
+	require.NoError(t, store.Migrate(context.Background()))
+	return db, store
+}
+
+func TestSQLOutboxStore_ClaimAndUpdate(t *testing.T) {
+	ctx := context.Background()
+	_, store := newSQLiteOutbox(t)
+
+	first, err := store.Enqueue(ctx, testMessage(t))
+	require.NoError(t, err)
+	second, err := store.Enqueue(ctx, testMessage(t))
+	require.NoError(t, err)
+
+	claimed, err := store.Claim(ctx, 1, 50*time.Millisecond)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, first.ID, claimed[0].ID)
+	assert.Equal(t, testMessage(t), claimed[0].Message)
+
+	claimed, err = store.Claim(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, second.ID, claimed[0].ID)
+
+	claimed[0].Status = sendmail.OutboxSent
+	claimed[0].Attempts = 1
+	claimed[0].SentAt = time.Unix(0, 42)
+	require.NoError(t, store.Update(ctx, claimed[0]))
+
+	sent, err := store.List(ctx, sendmail.OutboxSent)
+	require.NoError(t, err)
+	require.Len(t, sent, 1)
+	assert.Equal(t, 1, sent[0].Attempts)
+	assert.Equal(t, time.Unix(0, 42), sent[0].SentAt)
+
+	// the first claim expires with its lease
+	time.Sleep(100 * time.Millisecond)
+	claimed, err = store.Claim(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, first.ID, claimed[0].ID)
+
+	assert.ErrorIs(t, store.Update(ctx, &sendmail.OutboxEntry{ID: "missing"}), sendmail.ErrOutboxEntryNotFound)
+
+	purged, err := store.Purge(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	sent, err = store.List(ctx, sendmail.OutboxSent)
+	require.NoError(t, err)
+	assert.Empty(t, sent)
+}
+
+func TestSQLOutboxStore_EnqueueTx(t *testing.T) {
+	ctx := context.Background()
+	db, store := newSQLiteOutbox(t)
+
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	_, err = store.EnqueueTx(ctx, tx, testMessage(t))
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+
+	tx, err = db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	_, err = store.EnqueueTx(ctx, tx, testMessage(t))
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	pending, err := store.List(ctx, sendmail.OutboxPending)
+	require.NoError(t, err)
+	assert.Len(t, pending, 1)
+}
+
+// failingSender fails every message
+type failingSender struct{}
+
+func (failingSender) SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent string) (*sendmail.Response, error) {
+	return nil, errors.New("unavailable")
+}
+
+func (failingSender) SendMessage(message *sendmail.Message) (*sendmail.Response, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestSQLOutboxStore_Dispatcher(t *testing.T) {
+	ctx := context.Background()
+	_, store := newSQLiteOutbox(t)
+	for i := 0; i < 3; i++ {
+		_, err := store.Enqueue(ctx, testMessage(t))
+		require.NoError(t, err)
+	}
+
+	dispatcher := sendmail.NewOutboxDispatcher(store, failingSender{}, sendmail.WithMaxAttempts(1),
+		sendmail.WithDispatcherLogger(func(string, ...interface{}) {}))
+	processed, err := dispatcher.DispatchOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, processed)
+
+	dead, err := store.List(ctx, sendmail.OutboxDead)
+	require.NoError(t, err)
+	require.Len(t, dead, 3)
+	assert.Equal(t, "unavailable", dead[0].LastError)
+}
+
+func TestSQLOutboxStore_ConcurrentMigrate(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.db")
+
+	// each instance has its own connection pool, as separate processes would
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		store, err := sendmail.NewSQLOutboxStore(openSQLite(t, path), sendmail.DialectSQLite)
+		require.NoError(t, err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = store.Migrate(ctx)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	var versions int
+	require.NoError(t, openSQLite(t, path).QueryRow("SELECT COUNT(*) FROM sendmail_outbox_migrations").Scan(&versions))
+	assert.Equal(t, 2, versions)
+}
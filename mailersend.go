@@ -12,22 +12,25 @@ import (
 )
 
 type MailerSend struct {
-	client *mailersend.Mailersend
-	token  string
-
-	// override logging using user defined logger function.
-	Logger func(string, ...interface{})
+	client  *mailersend.Mailersend
+	token   string
+	options *options
 }
 
-func NewMailerSend(apiToken string) (*MailerSend, error) {
+func NewMailerSend(apiToken string, opts ...Option) (*MailerSend, error) {
+	o := newOptions(opts)
+
 	client := mailersend.NewMailersend(apiToken)
 	if client == nil {
 		return nil, fmt.Errorf("Failed to create MailerSend client")
 	}
+	// the library does not allow changing its base url, so the base url is applied by the transport
+	client.SetClient(o.client(0, true))
 
 	manager := &MailerSend{
-		token:  apiToken,
-		client: client,
+		token:   apiToken,
+		client:  client,
+		options: o,
 	}
 
 	return manager, nil
@@ -41,6 +44,7 @@ func (ms *MailerSend) SendMail(fromName, fromEmail, toName, toEmail, subject, pl
 
 	message := ms.client.Email.NewMessage()
 
+	fromName, fromEmail = ms.options.from(fromName, fromEmail)
 	from := mailersend.From{
 		Name:  fromName,
 		Email: fromEmail,
@@ -68,11 +72,40 @@ func (ms *MailerSend) SendMessage(message *Message) (response *Response, err err
 		timer.StopE(err)
 	}()
 
+	message = ms.options.withDefaults(message)
 	err = message.Validate()
 	if err != nil {
 		return nil, err
 	}
 
+	if len(message.Personalizations) > 0 {
+		return ms.sendPersonalized(message)
+	}
+	return ms.post(ms.newMessage(message))
+}
+
+// sendPersonalized sends one message per personalization, the variables are passed as MailerSend personalization
+// data, which the {{ name }} placeholders read
+func (ms *MailerSend) sendPersonalized(message *Message) (*Response, error) {
+	results := newBatchResults(Personalize(message))
+	for i, personalization := range message.Personalizations {
+		recipient := *message
+		recipient.Recipients = []*Email{personalization.To}
+		recipient.Personalizations = nil
+		recipient.Unsubscribe = message.unsubscribeFor(personalization)
+
+		msMessage := ms.newMessage(&recipient)
+		data := map[string]interface{}{}
+		for name, value := range personalization.Variables {
+			data[name] = value
+		}
+		msMessage.SetPersonalization([]mailersend.Personalization{{Email: personalization.To.Address, Data: data}})
+		results[i].Response, results[i].Err = ms.post(msMessage)
+	}
+	return personalizedResponse(results)
+}
+
+func (ms *MailerSend) newMessage(message *Message) *mailersend.Message {
 	msMessage := ms.client.Email.NewMessage()
 
 	from := mailersend.From{
@@ -100,7 +133,16 @@ func (ms *MailerSend) SendMessage(message *Message) (response *Response, err err
 	msMessage.SetHTML(message.HtmlContent)
 	msMessage.SetText(message.PlainTextContent)
 
-	return ms.post(msMessage)
+	// MailerSend takes List-Unsubscribe in its own field, the other headers are passed as custom headers
+	for _, header := range message.Unsubscribe.headers() {
+		if header.name == "List-Unsubscribe" {
+			msMessage.SetListUnsubscribe(header.value)
+		} else {
+			msMessage.Headers = append(msMessage.Headers, mailersend.Header{Name: header.name, Value: header.value})
+		}
+	}
+
+	return msMessage
 }
 
 func (ms *MailerSend) post(messasge *mailersend.Message) (response *Response, err error) {
@@ -115,7 +157,13 @@ func (ms *MailerSend) post(messasge *mailersend.Message) (response *Response, er
 	ctx := context.Background()
 	msResponse, err := ms.client.Email.Send(ctx, messasge)
 	if err != nil {
-		return nil, err
+		// the library returns the rejecting response together with the error, it has already read the body
+		if msResponse == nil || msResponse.Response == nil {
+			return nil, err
+		}
+		response = &Response{StatusCode: msResponse.StatusCode, Headers: msResponse.Header}
+		ms.logf("Send email: status_code=%d, error=%v", msResponse.StatusCode, err)
+		return response, providerError(response, "Failed to send email: %w", err)
 	}
 
 	if msResponse == nil {
@@ -137,7 +185,7 @@ func (ms *MailerSend) post(messasge *mailersend.Message) (response *Response, er
 	}
 
 	if msResponse.StatusCode < 200 || msResponse.StatusCode >= 300 {
-		return response, fmt.Errorf("Failed to send email: status_code=%d, body=%s, headers=%s", msResponse.StatusCode, bodyStr, mapString)
+		return response, providerError(response, "Failed to send email: status_code=%d, body=%s, headers=%s", msResponse.StatusCode, bodyStr, mapString)
 	}
 
 	return response, nil
@@ -145,8 +193,8 @@ func (ms *MailerSend) post(messasge *mailersend.Message) (response *Response, er
 
 // logf logs message either via defined user logger or via system one if no user logger is defined.
 func (ms *MailerSend) logf(f string, args ...interface{}) {
-	if ms.Logger != nil {
-		ms.Logger(f, args...)
+	if ms.options != nil && ms.options.logger != nil {
+		ms.options.logger(f, args...)
 	} else {
 		log.Printf(f, args...)
 	}
@@ -3,8 +3,12 @@
 package sendmail
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"html"
 	"log"
+	"strings"
 
 	"github.com/mailjet/mailjet-apiv3-go/v4"
 	"github.com/malcolm-davis/go-stopwatch"
@@ -23,21 +27,28 @@ type MailJetMailManager struct {
 	client    *mailjet.Client
 	APIKey    string
 	SecretKey string
-
-	// User defined logger function.
-	Logger func(string, ...interface{})
+	options   *options
 }
 
-func NewMailJet(apiKey string, secretKey string) (*MailJetMailManager, error) {
-	client := mailjet.NewMailjetClient(apiKey, secretKey)
+func NewMailJet(apiKey string, secretKey string, opts ...Option) (*MailJetMailManager, error) {
+	o := newOptions(opts)
+
+	var client *mailjet.Client
+	if o.baseURL != "" {
+		client = mailjet.NewMailjetClient(apiKey, secretKey, o.baseURL+"/v3")
+	} else {
+		client = mailjet.NewMailjetClient(apiKey, secretKey)
+	}
 	if client == nil {
 		return nil, fmt.Errorf("Failed to create Mailjet client")
 	}
+	client.SetClient(recording(o.client(0, false)))
 
 	manager := &MailJetMailManager{
 		APIKey:    apiKey,
 		SecretKey: secretKey,
 		client:    client,
+		options:   o,
 	}
 
 	return manager, nil
@@ -49,6 +60,7 @@ func (mj *MailJetMailManager) SendMail(fromName, fromEmail, toName, toEmail, sub
 		timer.StopE(err)
 	}()
 
+	fromName, fromEmail = mj.options.from(fromName, fromEmail)
 	messagesInfo := []mailjet.InfoMessagesV31{{
 		From: &mailjet.RecipientV31{
 			Email: fromEmail,
@@ -75,11 +87,85 @@ func (mj *MailJetMailManager) SendMessage(message *Message) (response *Response,
 		timer.StopE(err)
 	}()
 
+	message = mj.options.withDefaults(message)
 	err = message.Validate()
 	if err != nil {
 		return nil, err
 	}
 
+	if len(message.Personalizations) > 0 {
+		return mj.sendPersonalized(message)
+	}
+	return mj.post([]mailjet.InfoMessagesV31{mj.messageInfo(message)})
+}
+
+// sendPersonalized sends one message per personalization, 50 per call, with the variables passed as MailJet
+// template variables. Placeholders are rewritten to the {{var:name}} template syntax.
+func (mj *MailJetMailManager) sendPersonalized(message *Message) (*Response, error) {
+	results := newBatchResults(Personalize(message))
+	for start := 0; start < len(message.Personalizations); start += mailJetBatchLimit {
+		end := min(start+mailJetBatchLimit, len(message.Personalizations))
+
+		var chunk []int
+		var messagesInfo []mailjet.InfoMessagesV31
+		for i := start; i < end; i++ {
+			personalization := message.Personalizations[i]
+			recipient := *message
+			recipient.Recipients = []*Email{personalization.To}
+			recipient.Personalizations = nil
+			recipient.Unsubscribe = message.unsubscribeFor(personalization)
+
+			templateVariable := func(name string) (string, bool) {
+				_, ok := personalization.Variables[name]
+				return "{{var:" + name + "}}", ok
+			}
+			info := mj.messageInfo(&recipient)
+			info.Subject = rewritePlaceholders(info.Subject, templateVariable)
+			info.TextPart = rewritePlaceholders(info.TextPart, templateVariable)
+			// the template language inserts the values unescaped, the html part gets the escaped values substituted
+			// instead, with braces escaped too so the template language leaves them alone
+			info.HTMLPart = rewritePlaceholders(info.HTMLPart, func(name string) (string, bool) {
+				value, ok := personalization.Variables[name]
+				return strings.ReplaceAll(html.EscapeString(value), "{", "&#123;"), ok
+			})
+			if len(personalization.Variables) > 0 {
+				info.TemplateLanguage = true
+				info.Variables = map[string]interface{}{}
+				for name, value := range personalization.Variables {
+					info.Variables[name] = value
+				}
+			}
+			chunk = append(chunk, i)
+			messagesInfo = append(messagesInfo, info)
+		}
+		mj.postBatch(messagesInfo, chunk, results)
+	}
+	return personalizedResponse(results)
+}
+
+// mailJetBatchLimit is the number of messages accepted by a single Send API v3.1 call
+const mailJetBatchLimit = 50
+
+// SendBatch sends distinct messages through the Send API v3.1, 50 messages per call
+func (mj *MailJetMailManager) SendBatch(ctx context.Context, messages []*Message, opts ...BatchOption) (results []BatchResult, err error) {
+	timer := stopwatch.Start("SendBatch", stopwatch.LogStop)
+	defer func() {
+		timer.StopE(err)
+	}()
+
+	results = newBatchResults(messages)
+	prepared, chunks := chunkBatch(mj, messages, results, mailJetBatchLimit, mj.options.withDefaults)
+	err = sendChunks(ctx, chunks, results, func(chunk []int) {
+		messagesInfo := make([]mailjet.InfoMessagesV31, 0, len(chunk))
+		for _, index := range chunk {
+			messagesInfo = append(messagesInfo, mj.messageInfo(prepared[index]))
+		}
+		mj.postBatch(messagesInfo, chunk, results)
+	})
+	return results, err
+}
+
+func (mj *MailJetMailManager) messageInfo(message *Message) mailjet.InfoMessagesV31 {
 	recipientList := mailjet.RecipientsV31{}
 	for _, recipient := range message.Recipients {
 		recipientList = append(recipientList, mailjet.RecipientV31{
@@ -97,7 +183,7 @@ func (mj *MailJetMailManager) SendMessage(message *Message) (response *Response,
 		})
 	}
 
-	messagesInfo := []mailjet.InfoMessagesV31{{
+	info := mailjet.InfoMessagesV31{
 		From: &mailjet.RecipientV31{
 			Email: message.FromEmail.Address,
 			Name:  message.FromEmail.Name,
@@ -107,28 +193,99 @@ func (mj *MailJetMailManager) SendMessage(message *Message) (response *Response,
 		TextPart:    message.PlainTextContent,
 		HTMLPart:    message.HtmlContent,
 		Attachments: &attachmentList,
-	},
 	}
+	for _, header := range message.Unsubscribe.headers() {
+		if info.Headers == nil {
+			info.Headers = map[string]interface{}{}
+		}
+		info.Headers[header.name] = header.value
+	}
+	return info
+}
 
-	return mj.post(messagesInfo)
+// postBatch sends a chunk of messages, recording the outcome of each in results
+func (mj *MailJetMailManager) postBatch(messagesInfo []mailjet.InfoMessagesV31, chunk []int, results []BatchResult) {
+	fail := func(err error) {
+		for _, index := range chunk {
+			results[index].Err = err
+		}
+	}
+
+	client, err := mj.mailjetClient()
+	if err != nil {
+		fail(err)
+		return
+	}
+	recorder := &responseRecorder{}
+	mailjetResponse, err := client.SendMailV31(&mailjet.MessagesV31{Info: messagesInfo}, recorder.record)
+
+	// a validation failure is reported per message, messages without errors were accepted
+	var feedback *mailjet.APIFeedbackErrorsV31
+	if errors.As(err, &feedback) && len(feedback.Messages) == len(chunk) {
+		for i, index := range chunk {
+			errs := feedback.Messages[i].Errors
+			if len(errs) == 0 {
+				results[index].Response = &Response{StatusCode: 200}
+				continue
+			}
+			results[index].Response = &Response{StatusCode: errs[0].StatusCode}
+			results[index].Err = providerError(results[index].Response, "Failed to send email: %s", errs[0].ErrorMessage)
+		}
+		return
+	}
+	if err != nil {
+		for _, index := range chunk {
+			results[index].Response = recorder.response
+		}
+		fail(providerError(recorder.response, "%w", err))
+		return
+	}
+	if len(mailjetResponse.ResultsV31) != len(chunk) {
+		fail(fmt.Errorf("Mailjet returned %d results for %d messages", len(mailjetResponse.ResultsV31), len(chunk)))
+		return
+	}
+
+	for i, index := range chunk {
+		result := mailjetResponse.ResultsV31[i]
+		response := &Response{StatusCode: 200, Headers: map[string][]string{}}
+		for _, to := range result.To {
+			response.Headers["X-Message-Id"] = append(response.Headers["X-Message-Id"], to.MessageUUID)
+		}
+		results[index].Response = response
+		if result.Status != "success" {
+			response.StatusCode = 400
+			results[index].Err = fmt.Errorf("Failed to send email: status %s", result.Status)
+		}
+	}
+	mj.logf("Send batch: messages=%d", len(chunk))
 }
 
-func (mj *MailJetMailManager) post(messagesInfo []mailjet.InfoMessagesV31) (response *Response, err error) {
-	client := mj.client
-	if client == nil {
+func (mj *MailJetMailManager) mailjetClient() (*mailjet.Client, error) {
+	if mj.client == nil {
 		client := mailjet.NewMailjetClient(mj.APIKey, mj.SecretKey)
 		if client == nil {
 			return nil, fmt.Errorf("Failed to create Mailjet client")
 		}
+		mj.client = client
 		mj.logf("Created new Mailjet client")
 	}
+	return mj.client, nil
+}
 
-	messages := mailjet.MessagesV31{Info: messagesInfo}
-	mailjetResponse, err := client.SendMailV31(&messages)
+func (mj *MailJetMailManager) post(messagesInfo []mailjet.InfoMessagesV31) (response *Response, err error) {
+	client, err := mj.mailjetClient()
 	if err != nil {
 		return nil, err
 	}
 
+	// the library drops the response on errors, the recorder keeps its status and headers
+	recorder := &responseRecorder{}
+	messages := mailjet.MessagesV31{Info: messagesInfo}
+	mailjetResponse, err := client.SendMailV31(&messages, recorder.record)
+	if err != nil {
+		return recorder.response, providerError(recorder.response, "%w", err)
+	}
+
 	if mailjetResponse == nil || len(mailjetResponse.ResultsV31) == 0 {
 		return nil, fmt.Errorf("No results returned from Mailjet")
 	}
@@ -145,17 +302,20 @@ func (mj *MailJetMailManager) post(messagesInfo []mailjet.InfoMessagesV31) (resp
 	response = &Response{
 		StatusCode: statusCode,
 	}
+	if recorder.response != nil {
+		response.Headers = recorder.response.Headers
+	}
 	// Accept any 2xx response as success
 	if statusCode < 200 || statusCode >= 300 {
-		return response, fmt.Errorf("Failed to send email: %s", response.Body)
+		return response, providerError(response, "Failed to send email: %s", response.Body)
 	}
 	return response, nil
 }
 
 // logf logs message either via defined user logger or via system one if no user logger is defined.
 func (mj *MailJetMailManager) logf(f string, args ...interface{}) {
-	if mj.Logger != nil {
-		mj.Logger(f, args...)
+	if mj.options != nil && mj.options.logger != nil {
+		mj.options.logger(f, args...)
 	} else {
 		log.Printf(f, args...)
 	}
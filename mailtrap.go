@@ -3,33 +3,103 @@ package sendmail
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/malcolm-davis/go-stopwatch"
 )
 
+// MailTrapMode selects the Mailtrap sending stream
+type MailTrapMode int
+
+const (
+	// MailTrapTransactional sends via the transactional stream, send.api.mailtrap.io
+	MailTrapTransactional MailTrapMode = iota
+	// MailTrapBulk sends via the bulk stream, bulk.api.mailtrap.io
+	MailTrapBulk
+	// MailTrapSandbox delivers into a testing inbox, sandbox.api.mailtrap.io
+	MailTrapSandbox
+)
+
+var mailTrapHosts = map[MailTrapMode]string{
+	MailTrapTransactional: "https://send.api.mailtrap.io",
+	MailTrapBulk:          "https://bulk.api.mailtrap.io",
+	MailTrapSandbox:       "https://sandbox.api.mailtrap.io",
+}
+
 // MailTrapConfig provides functionality to send emails via mailtrap
 // https://mailtrap.io/blog/golang-send-email/#Send-emails-in-Go-using-email-API
 type MailTrap struct {
-	token  string
-	client *http.Client
+	token   string
+	client  *http.Client
+	mode    MailTrapMode
+	inboxID string
+	baseURL string
+	options *options
+}
+
+// WithMailTrapSandbox delivers into the sandbox testing inbox with the given id
+func WithMailTrapSandbox(inboxID string) Option {
+	return func(o *options) {
+		o.mailTrapMode = MailTrapSandbox
+		o.mailTrapInboxID = inboxID
+	}
 }
 
-func NewMailTrap(mailTrapKey string) (*MailTrap, error) {
-	client := http.Client{Timeout: 10 * time.Second}
+// WithMailTrapBulk sends via the bulk stream
+func WithMailTrapBulk() Option {
+	return func(o *options) {
+		o.mailTrapMode = MailTrapBulk
+	}
+}
+
+func NewMailTrap(mailTrapKey string, opts ...Option) (*MailTrap, error) {
+	o := newOptions(opts)
+	if o.mailTrapMode == MailTrapSandbox && o.mailTrapInboxID == "" {
+		return nil, fmt.Errorf("Mailtrap sandbox mode requires an inbox id")
+	}
+
 	manager := &MailTrap{
-		token:  mailTrapKey,
-		client: &client,
+		token:   mailTrapKey,
+		client:  o.client(10*time.Second, false),
+		mode:    o.mailTrapMode,
+		inboxID: o.mailTrapInboxID,
+		baseURL: o.baseURL,
+		options: o,
 	}
 
 	return manager, nil
 }
 
+// endpoint returns the send url for the configured stream
+func (ms *MailTrap) endpoint() string {
+	return ms.apiURL("send")
+}
+
+// batchEndpoint returns the batch send url for the configured stream
+func (ms *MailTrap) batchEndpoint() string {
+	return ms.apiURL("batch")
+}
+
+func (ms *MailTrap) apiURL(action string) string {
+	host := ms.baseURL
+	if host == "" {
+		host = mailTrapHosts[ms.mode]
+	}
+	if ms.mode == MailTrapSandbox {
+		return host + "/api/" + action + "/" + url.PathEscape(ms.inboxID)
+	}
+	return host + "/api/" + action
+}
+
 func (ms *MailTrap) SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent string) (response *Response, err error) {
 	timer := stopwatch.Start("SendMail", stopwatch.LogStop)
 	defer func() {
@@ -45,6 +115,7 @@ func (ms *MailTrap) SendMail(fromName, fromEmail, toName, toEmail, subject, plai
 	//     "html":"<strong>Here’s the space for your great sales pitch</strong>"
 	// }`)
 
+	fromName, fromEmail = ms.options.from(fromName, fromEmail)
 	messageBuilder := NewEmailMessage()
 	messageBuilder.FromEmail(fromName, fromEmail)
 	messageBuilder.AddRecipient(toName, toEmail)
@@ -57,11 +128,11 @@ func (ms *MailTrap) SendMail(fromName, fromEmail, toName, toEmail, subject, plai
 		return nil, err
 	}
 
-	email, err := json.Marshal(message)
+	email, err := json.Marshal(newMailTrapMessage(message))
 	if err != nil {
 		return nil, err
 	}
-	return ms.post(email)
+	return ms.post(ms.endpoint(), email)
 }
 
 func (ms *MailTrap) SendMessage(message *Message) (response *Response, err error) {
@@ -70,10 +141,15 @@ func (ms *MailTrap) SendMessage(message *Message) (response *Response, err error
 		timer.StopE(err)
 	}()
 
+	message = ms.options.withDefaults(message)
 	err = message.Validate()
 	if err != nil {
 		return nil, err
 	}
+	// personalized copies go through the batch endpoint
+	if len(message.Personalizations) > 0 {
+		return sendPersonalized(ms, message)
+	}
 
 	// mailtrap format
 	//  message := []byte(`{
@@ -106,16 +182,120 @@ func (ms *MailTrap) SendMessage(message *Message) (response *Response, err error
 	//     ]
 	// }`)
 
-	email, err := json.Marshal(message)
+	email, err := json.Marshal(newMailTrapMessage(message))
 	if err != nil {
 		return nil, err
 	}
-	return ms.post(email)
+	return ms.post(ms.endpoint(), email)
+}
+
+// mailTrapMessage is the Mailtrap send payload, it leaves out the Message fields Mailtrap does not accept
+type mailTrapMessage struct {
+	From        *Email            `json:"from"`
+	To          []*Email          `json:"to"`
+	Subject     string            `json:"subject"`
+	Text        string            `json:"text,omitempty"`
+	HTML        string            `json:"html,omitempty"`
+	Attachments []*Attachment     `json:"attachments,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+func newMailTrapMessage(message *Message) *mailTrapMessage {
+	payload := &mailTrapMessage{
+		From:        message.FromEmail,
+		To:          message.Recipients,
+		Subject:     message.Subject,
+		Text:        message.PlainTextContent,
+		HTML:        message.HtmlContent,
+		Attachments: message.Attachments,
+	}
+	for _, header := range message.Unsubscribe.headers() {
+		if payload.Headers == nil {
+			payload.Headers = map[string]string{}
+		}
+		payload.Headers[header.name] = header.value
+	}
+	return payload
+}
+
+// mailTrapBatchLimit is the number of messages accepted by a single batch call
+const mailTrapBatchLimit = 500
+
+// mailTrapBatchResponse is the reply of the batch endpoint, responses are in the order of the requests
+type mailTrapBatchResponse struct {
+	Success   bool     `json:"success"`
+	Errors    []string `json:"errors"`
+	Responses []struct {
+		Success    bool     `json:"success"`
+		MessageIDs []string `json:"message_ids"`
+		Errors     []string `json:"errors"`
+	} `json:"responses"`
+}
+
+// SendBatch sends distinct messages through the batch endpoint of the configured stream, 500 messages per call
+func (ms *MailTrap) SendBatch(ctx context.Context, messages []*Message, opts ...BatchOption) (results []BatchResult, err error) {
+	timer := stopwatch.Start("SendBatch", stopwatch.LogStop)
+	defer func() {
+		timer.StopE(err)
+	}()
+
+	results = newBatchResults(messages)
+	prepared, chunks := chunkBatch(ms, messages, results, mailTrapBatchLimit, ms.options.withDefaults)
+	err = sendChunks(ctx, chunks, results, func(chunk []int) {
+		ms.postBatch(prepared, chunk, results)
+	})
+	return results, err
+}
+
+// postBatch sends a chunk of messages, recording the outcome of each in results
+func (ms *MailTrap) postBatch(messages []*Message, chunk []int, results []BatchResult) {
+	fail := func(response *Response, err error) {
+		for _, index := range chunk {
+			results[index].Response, results[index].Err = response, err
+		}
+	}
+
+	requests := make([]*mailTrapMessage, 0, len(chunk))
+	for _, index := range chunk {
+		requests = append(requests, newMailTrapMessage(messages[index]))
+	}
+	body, err := json.Marshal(map[string]interface{}{"requests": requests})
+	if err != nil {
+		fail(nil, err)
+		return
+	}
+
+	response, err := ms.post(ms.batchEndpoint(), body)
+	if err != nil {
+		fail(response, err)
+		return
+	}
+	batch := mailTrapBatchResponse{}
+	if err := json.Unmarshal([]byte(response.Body), &batch); err != nil {
+		fail(response, fmt.Errorf("Mailtrap batch response: %w", err))
+		return
+	}
+	if len(batch.Responses) != len(chunk) {
+		fail(response, fmt.Errorf("Mailtrap returned %d responses for %d messages", len(batch.Responses), len(chunk)))
+		return
+	}
+
+	for i, index := range chunk {
+		result := batch.Responses[i]
+		if !result.Success {
+			results[index].Response = &Response{StatusCode: http.StatusUnprocessableEntity}
+			results[index].Err = providerError(results[index].Response, "Mailtrap API error: %s", strings.Join(result.Errors, ", "))
+			continue
+		}
+		results[index].Response = &Response{
+			StatusCode: response.StatusCode,
+			Headers:    map[string][]string{"X-Message-Id": result.MessageIDs},
+		}
+	}
 }
 
-func (ms *MailTrap) post(message []byte) (response *Response, err error) {
-	httpHost := "https://send.api.mailtrap.io/api/send"
-	request, err := http.NewRequest(http.MethodPost, httpHost, bytes.NewBuffer(message))
+func (ms *MailTrap) post(endpoint string, message []byte) (response *Response, err error) {
+	request, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(message))
 	if err != nil {
 		return nil, err
 	}
@@ -132,25 +312,35 @@ func (ms *MailTrap) post(message []byte) (response *Response, err error) {
 	if err != nil {
 		return nil, err
 	}
+	if res == nil {
+		return nil, fmt.Errorf("No results returned from mailtrap.io")
+	}
 
 	defer res.Body.Close()
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
 		return nil, err
 	}
-	if res == nil {
-		return nil, fmt.Errorf("No results returned from mailtrap.io")
-	}
-
-	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Mailtrap API error: status code %d, body: %s", res.StatusCode, string(body))
-	}
 
 	response = &Response{
 		StatusCode: res.StatusCode,
 		Body:       string(body),
 		Headers:    res.Header,
 	}
+	ms.logf("Send email: status_code=%d, body=%s", res.StatusCode, response.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return response, providerError(response, "Mailtrap API error: status code %d, body: %s", res.StatusCode, string(body))
+	}
 
 	return response, nil
 }
+
+// logf logs message either via defined user logger or via system one if no user logger is defined.
+func (ms *MailTrap) logf(f string, args ...interface{}) {
+	if ms.options != nil && ms.options.logger != nil {
+		ms.options.logger(f, args...)
+	} else {
+		log.Printf(f, args...)
+	}
+}
@@ -0,0 +1,153 @@
+package sendmail
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mailTrapStub records the last request received and replies with the configured status
+type mailTrapStub struct {
+	server  *httptest.Server
+	path    string
+	auth    string
+	payload map[string]interface{}
+	status  int
+}
+
+func newMailTrapStub(t *testing.T) *mailTrapStub {
+	stub := &mailTrapStub{status: http.StatusOK}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.path = r.URL.Path
+		stub.auth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		stub.payload = nil
+		json.Unmarshal(body, &stub.payload)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(stub.status)
+		if stub.status == http.StatusOK {
+			w.Write([]byte(`{"success":true,"message_ids":["0c7fd939-02cf-11ed-88c2-0a58a9feac02"]}`))
+		} else {
+			w.Write([]byte(`{"success":false,"errors":["Unauthorized"]}`))
+		}
+	}))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func TestNewMailTrap_Endpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected string
+	}{
+		{"transactional", nil, "https://send.api.mailtrap.io/api/send"},
+		{"bulk", []Option{WithMailTrapBulk()}, "https://bulk.api.mailtrap.io/api/send"},
+		{"sandbox", []Option{WithMailTrapSandbox("12345")}, "https://sandbox.api.mailtrap.io/api/send/12345"},
+		{"base url", []Option{WithBaseURL("http://localhost:8080/")}, "http://localhost:8080/api/send"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			send, err := NewMailTrap("token", tt.opts...)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, send.endpoint())
+		})
+	}
+}
+
+func TestNewMailTrap_SandboxRequiresInbox(t *testing.T) {
+	_, err := NewMailTrap("token", WithMailTrapSandbox(""))
+	assert.Error(t, err)
+}
+
+func TestMailTrap_SendMail(t *testing.T) {
+	stub := newMailTrapStub(t)
+	send, err := NewMailTrap("token", WithBaseURL(stub.server.URL), WithHTTPClient(stub.server.Client()))
+	require.NoError(t, err)
+
+	response, err := send.SendMail("Sender", "sender@example.com", "Recipient", "recipient@example.com", "Subject", "text", "<p>html</p>")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Contains(t, response.Body, "message_ids")
+
+	assert.Equal(t, "/api/send", stub.path)
+	assert.Equal(t, "Bearer token", stub.auth)
+	assert.Equal(t, map[string]interface{}{"name": "Sender", "email": "sender@example.com"}, stub.payload["from"])
+	assert.Equal(t, "Subject", stub.payload["subject"])
+	assert.Equal(t, "text", stub.payload["text"])
+	assert.Equal(t, "<p>html</p>", stub.payload["html"])
+}
+
+func TestMailTrap_SendMessage(t *testing.T) {
+	stub := newMailTrapStub(t)
+	send, err := NewMailTrap("token", WithMailTrapSandbox("42"), WithBaseURL(stub.server.URL))
+	require.NoError(t, err)
+
+	message, err := NewEmailMessage().
+		FromEmail("Sender", "sender@example.com").
+		AddRecipient("One", "one@example.com").
+		AddRecipient("Two", "two@example.com").
+		Subject("Subject").
+		PlainTextContent("text").
+		AddAttachment("text/plain", "test.txt", "dGVzdCBjb250ZW50").
+		Build()
+	require.NoError(t, err)
+
+	_, err = send.SendMessage(message)
+	require.NoError(t, err)
+
+	assert.Equal(t, "/api/send/42", stub.path)
+	assert.Len(t, stub.payload["to"], 2)
+	attachments := stub.payload["attachments"].([]interface{})
+	require.Len(t, attachments, 1)
+	assert.Equal(t, map[string]interface{}{
+		"type":        "text/plain",
+		"filename":    "test.txt",
+		"content":     "dGVzdCBjb250ZW50",
+		"disposition": "attachment",
+	}, attachments[0])
+}
+
+func TestMailTrap_SendMessage_IdempotencyKeyNotSent(t *testing.T) {
+	stub := newMailTrapStub(t)
+	send, err := NewMailTrap("token", WithBaseURL(stub.server.URL))
+	require.NoError(t, err)
+
+	message := testMessage(t)
+	message.IdempotencyKey = "order-42"
+	_, err = send.SendMessage(message)
+	require.NoError(t, err)
+
+	// the key is used by IdempotentSender, it is not part of the Mailtrap API
+	assert.NotContains(t, stub.payload, "idempotency_key")
+	assert.Equal(t, "Test Subject", stub.payload["subject"])
+}
+
+func TestMailTrap_SendMessage_APIError(t *testing.T) {
+	stub := newMailTrapStub(t)
+	stub.status = http.StatusUnauthorized
+	send, err := NewMailTrap("bad-token", WithBaseURL(stub.server.URL))
+	require.NoError(t, err)
+
+	response, err := send.SendMail("Sender", "sender@example.com", "Recipient", "recipient@example.com", "Subject", "text", "")
+	assert.Error(t, err)
+	require.NotNil(t, response)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	assert.Contains(t, response.Body, "Unauthorized")
+}
+
+func TestMailTrap_SendMessage_Invalid(t *testing.T) {
+	stub := newMailTrapStub(t)
+	send, err := NewMailTrap("token", WithBaseURL(stub.server.URL))
+	require.NoError(t, err)
+
+	_, err = send.SendMessage(&Message{FromEmail: &Email{Address: "sender@example.com"}})
+	assert.ErrorIs(t, err, ErrMissingRecipients)
+	assert.Empty(t, stub.path)
+}
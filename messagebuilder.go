@@ -2,6 +2,7 @@ package sendmail
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 )
 
@@ -19,28 +20,96 @@ type Attachment struct {
 }
 
 type Message struct {
-	FromEmail        *Email        `json:"from,omitempty"`
-	Recipients       []*Email      `json:"to,omitempty"`
-	Subject          string        `json:"subject,omitempty"`
-	PlainTextContent string        `json:"text,omitempty"`
-	HtmlContent      string        `json:"html,omitempty"`
-	Attachments      []*Attachment `json:"attachments,omitempty"`
+	FromEmail        *Email             `json:"from,omitempty"`
+	Recipients       []*Email           `json:"to,omitempty"`
+	Subject          string             `json:"subject,omitempty"`
+	PlainTextContent string             `json:"text,omitempty"`
+	HtmlContent      string             `json:"html,omitempty"`
+	Attachments      []*Attachment      `json:"attachments,omitempty"`
+	IdempotencyKey   string             `json:"idempotency_key,omitempty"`
+	Personalizations []*Personalization `json:"personalizations,omitempty"`
+	Unsubscribe      *Unsubscribe       `json:"unsubscribe,omitempty"`
 }
 
 var ErrMissingRecipients = errors.New("sendmail: missing recipient(s) address")
-var ErrMissingFrom = errors.New("sendmail: missing 'from' email address")
+var ErrMissingFrom = errors.New("sendmail: missing from email address")
 var ErrMissingSubject = errors.New("sendmail: missing subject")
 
+// ErrInvalidMessage is wrapped by every error returned by Validate, together with the specific error
+var ErrInvalidMessage = errors.New("sendmail: invalid message")
+
+// invalidMessageError marks a validation failure as ErrInvalidMessage, keeping the message of the specific error
+type invalidMessageError struct {
+	err error
+}
+
+func (e *invalidMessageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *invalidMessageError) Unwrap() []error {
+	return []error{ErrInvalidMessage, e.err}
+}
+
+// Validate checks the message is complete and its addresses are valid, an invalid address is reported as an
+// *AddressError. Every error wraps ErrInvalidMessage.
 func (m *Message) Validate() error {
-	if m.FromEmail == nil {
+	if err := m.validate(true); err != nil {
+		return &invalidMessageError{err: err}
+	}
+	return nil
+}
+
+// validateDeferred validates a message held for a sender, without requiring the from address the sender may set
+// with WithDefaultFrom. A from address that is present is checked.
+func (m *Message) validateDeferred() error {
+	if err := m.validate(false); err != nil {
+		return &invalidMessageError{err: err}
+	}
+	return nil
+}
+
+func (m *Message) validate(requireFrom bool) error {
+	if m.FromEmail == nil && requireFrom {
 		return ErrMissingFrom
 	}
-	if len(m.Recipients) == 0 {
+	if len(m.Recipients) > 0 && len(m.Personalizations) > 0 {
+		return ErrRecipientsWithPersonalizations
+	}
+	if len(m.Recipients) == 0 && len(m.Personalizations) == 0 {
 		return ErrMissingRecipients
 	}
+	for _, personalization := range m.Personalizations {
+		if personalization == nil || personalization.To == nil || personalization.To.Address == "" {
+			return ErrMissingRecipients
+		}
+	}
+	if m.FromEmail != nil {
+		if err := checkAddress("from", m.FromEmail); err != nil {
+			return err
+		}
+	}
+	for i, recipient := range m.Recipients {
+		if err := checkAddress(fmt.Sprintf("to[%d]", i), recipient); err != nil {
+			return err
+		}
+	}
+	for i, personalization := range m.Personalizations {
+		if err := checkAddress(fmt.Sprintf("personalizations[%d].to", i), personalization.To); err != nil {
+			return err
+		}
+	}
 	if m.Subject == "" {
 		return ErrMissingSubject
 	}
+	if err := m.Unsubscribe.validate(); err != nil {
+		return err
+	}
+	for _, personalization := range m.Personalizations {
+		if err := personalization.Unsubscribe.validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -51,15 +120,22 @@ type MessageBuilder interface {
 	PlainTextContent(plainTextContent string) MessageBuilder
 	HtmlContent(htmlContent string) MessageBuilder
 	AddAttachment(contentType, filename, base64Content string, disposition_optional ...string) MessageBuilder
+	IdempotencyKey(key string) MessageBuilder
+	AddPersonalization(name, address string, variables map[string]string) MessageBuilder
+	AutoPlainText(enabled bool) MessageBuilder
+	InlineCSS(enabled bool) MessageBuilder
+	Unsubscribe(mailto, url string) MessageBuilder
 	Build() (*Message, error)
 }
 
 type messageBuilder struct {
-	emailMessage *Message
+	emailMessage  *Message
+	autoPlainText bool
+	inlineCSS     bool
 }
 
 func NewEmailMessage() MessageBuilder {
-	return &messageBuilder{emailMessage: &Message{}}
+	return &messageBuilder{emailMessage: &Message{}, autoPlainText: true}
 }
 
 func (m *messageBuilder) FromEmail(name, address string) MessageBuilder {
@@ -107,9 +183,59 @@ func (m *messageBuilder) AddAttachment(contentType, filename, base64Content stri
 	return m
 }
 
+// AddPersonalization adds a recipient receiving their own copy of the message, with {{name}} placeholders replaced by variables
+func (m *messageBuilder) AddPersonalization(name, address string, variables map[string]string) MessageBuilder {
+	if strings.TrimSpace(address) == "" {
+		return m
+	}
+	m.emailMessage.Personalizations = append(m.emailMessage.Personalizations, &Personalization{
+		To:        &Email{Name: name, Address: address},
+		Variables: variables,
+	})
+	return m
+}
+
+// IdempotencyKey identifies the message across retries, see IdempotentSender. It is not sent to the provider.
+func (m *messageBuilder) IdempotencyKey(key string) MessageBuilder {
+	m.emailMessage.IdempotencyKey = strings.TrimSpace(key)
+	return m
+}
+
+// AutoPlainText controls whether Build fills an empty PlainTextContent from the HtmlContent, enabled by default
+func (m *messageBuilder) AutoPlainText(enabled bool) MessageBuilder {
+	m.autoPlainText = enabled
+	return m
+}
+
+// Unsubscribe sets the List-Unsubscribe targets, either may be empty. An https url enables one-click unsubscribe,
+// see Unsubscribe and Unsubscriber for per-recipient links.
+func (m *messageBuilder) Unsubscribe(mailto, url string) MessageBuilder {
+	m.emailMessage.Unsubscribe = &Unsubscribe{Mailto: strings.TrimSpace(mailto), URL: strings.TrimSpace(url)}
+	return m
+}
+
+// InlineCSS controls whether Build moves the <style> rules of the html content into style attributes, see InlineCSS
+func (m *messageBuilder) InlineCSS(enabled bool) MessageBuilder {
+	m.inlineCSS = enabled
+	return m
+}
+
+// Build validates the message and normalizes its addresses, see NormalizeAddress. Repeated recipients are dropped.
+// Without plain text content, the text is generated from the html content, see HTMLToText and AutoPlainText.
 func (m *messageBuilder) Build() (*Message, error) {
 	if err := m.emailMessage.Validate(); err != nil {
 		return nil, err
 	}
+	m.emailMessage.normalizeAddresses()
+	if m.inlineCSS && m.emailMessage.HtmlContent != "" {
+		content, err := InlineCSS(m.emailMessage.HtmlContent)
+		if err != nil {
+			return nil, err
+		}
+		m.emailMessage.HtmlContent = content
+	}
+	if m.autoPlainText && m.emailMessage.PlainTextContent == "" && m.emailMessage.HtmlContent != "" {
+		m.emailMessage.PlainTextContent = HTMLToText(m.emailMessage.HtmlContent)
+	}
 	return m.emailMessage, nil
 }
@@ -0,0 +1,345 @@
+package sendmail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// base64LineLength is the maximum encoded line length allowed by RFC 2045
+const base64LineLength = 76
+
+// renderMIME renders the message as an RFC 5322 document using CRLF line endings.
+// The messageID is written without angle brackets, e.g. 1234@example.com
+func renderMIME(message *Message, messageID string, date time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+
+	writeHeader(&buf, "From", formatAddress(message.FromEmail))
+	recipients := make([]string, 0, len(message.Recipients))
+	for _, recipient := range message.Recipients {
+		recipients = append(recipients, formatAddress(recipient))
+	}
+	writeHeader(&buf, "To", strings.Join(recipients, ", "))
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	writeHeader(&buf, "Date", date.Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", "<"+messageID+">")
+	for _, header := range message.Unsubscribe.headers() {
+		writeHeader(&buf, header.name, header.value)
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	if len(message.Attachments) == 0 {
+		if err := writeBody(&buf, message); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mixed := multipart.NewWriter(&buf)
+	writeHeader(&buf, "Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mixed.Boundary()}))
+	buf.WriteString("\r\n")
+
+	// the body is rendered into its own buffer so it can be added as the first part
+	var body bytes.Buffer
+	if err := writeBody(&body, message); err != nil {
+		return nil, err
+	}
+	header, content, err := splitPart(body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	part, err := mixed.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = part.Write(content); err != nil {
+		return nil, err
+	}
+
+	for _, attachment := range message.Attachments {
+		if err := writeAttachment(mixed, attachment); err != nil {
+			return nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeBody writes the content headers, a blank line and the text and/or html content
+func writeBody(w *bytes.Buffer, message *Message) error {
+	switch {
+	case message.PlainTextContent != "" && message.HtmlContent != "":
+		alternative := multipart.NewWriter(w)
+		writeHeader(w, "Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": alternative.Boundary()}))
+		w.WriteString("\r\n")
+		if err := writeTextPart(alternative, "text/plain", message.PlainTextContent); err != nil {
+			return err
+		}
+		if err := writeTextPart(alternative, "text/html", message.HtmlContent); err != nil {
+			return err
+		}
+		return alternative.Close()
+	case message.HtmlContent != "":
+		return writeSinglePart(w, "text/html", message.HtmlContent)
+	default:
+		return writeSinglePart(w, "text/plain", message.PlainTextContent)
+	}
+}
+
+func writeSinglePart(w *bytes.Buffer, contentType, content string) error {
+	writeHeader(w, "Content-Type", contentType+"; charset=utf-8")
+	writeHeader(w, "Content-Transfer-Encoding", "quoted-printable")
+	w.WriteString("\r\n")
+	return writeQuotedPrintable(w, content)
+}
+
+func writeTextPart(writer *multipart.Writer, contentType, content string) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType+"; charset=utf-8")
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	return writeQuotedPrintable(part, content)
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(normalizeNewlines(content))); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func writeAttachment(writer *multipart.Writer, attachment *Attachment) error {
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	disposition := attachment.Disposition
+	if disposition == "" {
+		disposition = "attachment"
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType(contentType, map[string]string{"name": attachment.Filename}))
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	header.Set("Content-Transfer-Encoding", "base64")
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	// the content is already base64 encoded, it only needs wrapping at the RFC line length
+	content := strings.Join(strings.Fields(attachment.Base64Content), "")
+	for len(content) > base64LineLength {
+		if _, err := io.WriteString(part, content[:base64LineLength]+"\r\n"); err != nil {
+			return err
+		}
+		content = content[base64LineLength:]
+	}
+	_, err = io.WriteString(part, content+"\r\n")
+	return err
+}
+
+// splitPart separates a rendered header block from its content
+func splitPart(rendered []byte) (textproto.MIMEHeader, []byte, error) {
+	index := bytes.Index(rendered, []byte("\r\n\r\n"))
+	if index < 0 {
+		return nil, nil, fmt.Errorf("sendmail: malformed MIME part")
+	}
+	header := textproto.MIMEHeader{}
+	for _, line := range strings.Split(string(rendered[:index]), "\r\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, nil, fmt.Errorf("sendmail: malformed MIME header %q", line)
+		}
+		header.Add(key, strings.TrimSpace(value))
+	}
+	return header, rendered[index+4:], nil
+}
+
+func writeHeader(w *bytes.Buffer, key, value string) {
+	w.WriteString(key)
+	w.WriteString(": ")
+	w.WriteString(value)
+	w.WriteString("\r\n")
+}
+
+func formatAddress(email *Email) string {
+	if email == nil {
+		return ""
+	}
+	address := mail.Address{Name: email.Name, Address: email.Address}
+	return address.String()
+}
+
+func normalizeNewlines(content string) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	return strings.ReplaceAll(content, "\n", "\r\n")
+}
+
+// newMessageID creates a unique message id using the domain of the from address
+func newMessageID(message *Message) string {
+	domain := "localhost"
+	if message.FromEmail != nil {
+		if index := strings.LastIndex(message.FromEmail.Address, "@"); index >= 0 && index < len(message.FromEmail.Address)-1 {
+			domain = message.FromEmail.Address[index+1:]
+		}
+	}
+	return fmt.Sprintf("%d.%s@%s", time.Now().UnixNano(), randomHex(8), domain)
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		// crypto/rand does not fail on supported platforms, fall back to the clock
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// parsedMIME holds the parts of a raw RFC 5322 message that are of interest when reading mail back
+type parsedMIME struct {
+	Header      mail.Header
+	Text        string
+	HTML        string
+	Attachments []*Attachment
+}
+
+// parseMIME reads a raw message, decoding the text, html and attachment parts
+func parseMIME(raw []byte) (*parsedMIME, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	parsed := &parsedMIME{Header: msg.Header}
+	err = parseMIMEPart(parsed, textproto.MIMEHeader(msg.Header), msg.Body)
+	return parsed, err
+}
+
+func parseMIMEPart(parsed *parsedMIME, header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := parseMIMEPart(parsed, part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	content, err := decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body)
+	if err != nil {
+		return err
+	}
+
+	disposition, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	switch {
+	case disposition != "attachment" && mediaType == "text/plain" && parsed.Text == "":
+		parsed.Text = decodedText(decodeCharset(params["charset"], content))
+	case disposition != "attachment" && mediaType == "text/html" && parsed.HTML == "":
+		parsed.HTML = decodedText(decodeCharset(params["charset"], content))
+	default:
+		filename := dispositionParams["filename"]
+		if filename == "" {
+			filename = params["name"]
+		}
+		if disposition == "" {
+			disposition = "attachment"
+		}
+		parsed.Attachments = append(parsed.Attachments, &Attachment{
+			ContentType:   mediaType,
+			Filename:      filename,
+			Base64Content: base64.StdEncoding.EncodeToString(content),
+			Disposition:   disposition,
+		})
+	}
+	return nil
+}
+
+// decodeCharset converts text in charset to UTF-8. Content without charset, or in a charset that is not known, is
+// returned as is.
+func decodeCharset(charset string, content []byte) []byte {
+	if charset == "" {
+		return content
+	}
+	reader, err := charsetReader(charset, bytes.NewReader(content))
+	if err != nil {
+		return content
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return content
+	}
+	return decoded
+}
+
+// charsetReader decodes input in charset to UTF-8, charsets are looked up by their WHATWG names and labels, e.g.
+// latin1 or windows-1252. It is the CharsetReader of the header word decoder too.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return encoding.NewDecoder().Reader(input), nil
+}
+
+// decodedText normalizes line endings and drops the line break the transport adds after the content
+func decodedText(content []byte) string {
+	return strings.TrimSuffix(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+}
+
+func decodeTransferEncoding(encoding string, body io.Reader) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return io.ReadAll(base64.NewDecoder(base64.StdEncoding, newlineStripper{body}))
+	case "quoted-printable":
+		return io.ReadAll(quotedprintable.NewReader(body))
+	default:
+		return io.ReadAll(body)
+	}
+}
+
+// newlineStripper drops line breaks so wrapped base64 can be decoded
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (n newlineStripper) Read(p []byte) (int, error) {
+	count, err := n.r.Read(p)
+	kept := 0
+	for _, b := range p[:count] {
+		if b != '\r' && b != '\n' {
+			p[kept] = b
+			kept++
+		}
+	}
+	return kept, err
+}
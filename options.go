@@ -0,0 +1,199 @@
+package sendmail
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Option configures a sender when it is constructed. Options that do not apply to a transport,
+// e.g. WithBaseURL for the SMTP transport, are ignored.
+type Option func(*options)
+
+type options struct {
+	httpClient  *http.Client
+	logger      func(string, ...interface{})
+	baseURL     string
+	timeout     time.Duration
+	userAgent   string
+	defaultFrom *Email
+	dkim        *DKIMSigner
+
+	// smtp specific
+	smtpNoStartTLS    bool
+	smtpPlaintextAuth bool
+
+	// mailtrap specific
+	mailTrapMode    MailTrapMode
+	mailTrapInboxID string
+}
+
+// WithHTTPClient sets the http.Client used for API requests. The client is copied, it is not modified.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// WithLogger overrides the default log.Printf logging
+func WithLogger(logger func(string, ...interface{})) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithBaseURL replaces the scheme and host of the provider API, e.g. to point at a regional endpoint or a local stub.
+// The provider specific path, such as /v3, is still appended.
+func WithBaseURL(baseURL string) Option {
+	return func(o *options) {
+		o.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithTimeout sets the request timeout, for SMTP it bounds the whole exchange with the server, default 30 seconds
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with API requests
+func WithUserAgent(userAgent string) Option {
+	return func(o *options) {
+		o.userAgent = userAgent
+	}
+}
+
+// WithDefaultFrom sets the from address used when a message does not define one
+func WithDefaultFrom(name, address string) Option {
+	return func(o *options) {
+		o.defaultFrom = &Email{Name: name, Address: address}
+	}
+}
+
+// WithDKIM signs the messages sent by the SMTP transport and written by the file and Maildir sinks, the API providers
+// sign with their own keys
+func WithDKIM(signer *DKIMSigner) Option {
+	return func(o *options) {
+		o.dkim = signer
+	}
+}
+
+// WithoutSMTPStartTLS keeps the SMTP connection unencrypted even when the server offers STARTTLS, e.g. for a local
+// capture server
+func WithoutSMTPStartTLS() Option {
+	return func(o *options) {
+		o.smtpNoStartTLS = true
+	}
+}
+
+// WithSMTPPlaintextAuth allows sending the SMTP credentials over an unencrypted connection. Without it the send fails
+// with ErrSMTPPlaintextAuth when the connection could not be upgraded with STARTTLS.
+func WithSMTPPlaintextAuth() Option {
+	return func(o *options) {
+		o.smtpPlaintextAuth = true
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// client builds the http.Client for a provider. defaultTimeout applies when neither a client nor a timeout is configured.
+// When rewriteBaseURL is set the base url is applied by the transport, for libraries that do not allow changing it.
+func (o *options) client(defaultTimeout time.Duration, rewriteBaseURL bool) *http.Client {
+	client := &http.Client{Timeout: defaultTimeout}
+	if o.httpClient != nil {
+		copied := *o.httpClient
+		client = &copied
+	}
+	if o.timeout > 0 {
+		client.Timeout = o.timeout
+	}
+
+	transport := &optionsTransport{base: client.Transport, userAgent: o.userAgent}
+	if rewriteBaseURL && o.baseURL != "" {
+		if baseURL, err := url.Parse(o.baseURL); err == nil {
+			transport.baseURL = baseURL
+		}
+	}
+	if transport.userAgent != "" || transport.baseURL != nil {
+		client.Transport = transport
+	}
+	return client
+}
+
+// recording makes sure client fills response recorders, see responseRecorder
+func recording(client *http.Client) *http.Client {
+	if _, ok := client.Transport.(*optionsTransport); !ok {
+		client.Transport = &optionsTransport{base: client.Transport}
+	}
+	return client
+}
+
+// from returns the from address to use, falling back to the default from address
+func (o *options) from(name, address string) (string, string) {
+	if o != nil && strings.TrimSpace(address) == "" && o.defaultFrom != nil {
+		return o.defaultFrom.Name, o.defaultFrom.Address
+	}
+	return name, address
+}
+
+// withDefaults returns the message with the default from address applied, the caller's message is not modified
+func (o *options) withDefaults(message *Message) *Message {
+	if o == nil || message == nil || message.FromEmail != nil || o.defaultFrom == nil {
+		return message
+	}
+	copied := *message
+	copied.FromEmail = o.defaultFrom
+	return &copied
+}
+
+// responseRecorder keeps the status and headers of the response to a request, for provider libraries which drop the
+// response when they return an error
+type responseRecorder struct {
+	response *Response
+}
+
+type responseRecorderKey struct{}
+
+// record is a request option, the transport records the response of the request into r
+func (r *responseRecorder) record(request *http.Request) {
+	*request = *request.WithContext(context.WithValue(request.Context(), responseRecorderKey{}, r))
+}
+
+// optionsTransport applies the user agent and base url options to each request and fills the response recorder
+type optionsTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	baseURL   *url.URL
+}
+
+func (t *optionsTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	if t.userAgent != "" {
+		request.Header.Set("User-Agent", t.userAgent)
+	}
+	if t.baseURL != nil {
+		request.URL.Scheme = t.baseURL.Scheme
+		request.URL.Host = t.baseURL.Host
+		request.URL.Path = strings.TrimRight(t.baseURL.Path, "/") + request.URL.Path
+		request.Host = ""
+	}
+
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	response, err := base.RoundTrip(request)
+	if recorder, ok := request.Context().Value(responseRecorderKey{}).(*responseRecorder); ok && err == nil {
+		recorder.response = &Response{StatusCode: response.StatusCode, Headers: response.Header}
+	}
+	return response, err
+}
@@ -0,0 +1,112 @@
+package sendmail
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingServer captures the last request received by a provider stub
+type recordingServer struct {
+	*httptest.Server
+	request *http.Request
+	body    map[string]interface{}
+}
+
+func newRecordingServer(t *testing.T, reply string) *recordingServer {
+	recorder := &recordingServer{}
+	recorder.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.request = r
+		body, _ := io.ReadAll(r.Body)
+		recorder.body = nil
+		json.Unmarshal(body, &recorder.body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(reply))
+	}))
+	t.Cleanup(recorder.Close)
+	return recorder
+}
+
+func TestOptions_Client(t *testing.T) {
+	base := &http.Client{Timeout: time.Minute}
+	o := newOptions([]Option{WithHTTPClient(base), WithTimeout(5 * time.Second), WithUserAgent("agent/1.0")})
+
+	client := o.client(10*time.Second, false)
+	assert.NotSame(t, base, client)
+	assert.Equal(t, 5*time.Second, client.Timeout)
+	assert.Equal(t, time.Minute, base.Timeout)
+	assert.IsType(t, &optionsTransport{}, client.Transport)
+
+	client = newOptions(nil).client(10*time.Second, false)
+	assert.Equal(t, 10*time.Second, client.Timeout)
+	assert.Nil(t, client.Transport)
+}
+
+func TestOptions_DefaultFrom(t *testing.T) {
+	o := newOptions([]Option{WithDefaultFrom("No Reply", "no-reply@example.com")})
+
+	message := &Message{Recipients: []*Email{{Address: "to@example.com"}}, Subject: "Subject"}
+	withDefaults := o.withDefaults(message)
+	assert.Equal(t, "no-reply@example.com", withDefaults.FromEmail.Address)
+	assert.Nil(t, message.FromEmail)
+
+	name, address := o.from("", "")
+	assert.Equal(t, "No Reply", name)
+	assert.Equal(t, "no-reply@example.com", address)
+
+	name, address = o.from("Sender", "sender@example.com")
+	assert.Equal(t, "Sender", name)
+	assert.Equal(t, "sender@example.com", address)
+}
+
+func TestSendGrid_Options(t *testing.T) {
+	server := newRecordingServer(t, "")
+	var logged []string
+	send, err := NewSendGrid("key",
+		WithBaseURL(server.URL),
+		WithUserAgent("agent/1.0"),
+		WithDefaultFrom("No Reply", "no-reply@example.com"),
+		WithLogger(func(format string, args ...interface{}) { logged = append(logged, format) }))
+	require.NoError(t, err)
+
+	response, err := send.SendMail("", "", "Recipient", "recipient@example.com", "Subject", "text", "")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, response.StatusCode)
+
+	assert.Equal(t, "/v3/mail/send", server.request.URL.Path)
+	assert.Equal(t, "agent/1.0", server.request.Header.Get("User-Agent"))
+	assert.Equal(t, "Bearer key", server.request.Header.Get("Authorization"))
+	assert.Equal(t, "no-reply@example.com", server.body["from"].(map[string]interface{})["email"])
+	assert.NotEmpty(t, logged)
+}
+
+func TestMailerSend_Options(t *testing.T) {
+	server := newRecordingServer(t, "")
+	send, err := NewMailerSend("token", WithBaseURL(server.URL), WithUserAgent("agent/1.0"))
+	require.NoError(t, err)
+
+	_, err = send.SendMail("Sender", "sender@example.com", "Recipient", "recipient@example.com", "Subject", "text", "")
+	require.NoError(t, err)
+	assert.Equal(t, "/v1/email", server.request.URL.Path)
+	assert.Equal(t, "agent/1.0", server.request.Header.Get("User-Agent"))
+}
+
+func TestSmtp2go_Options(t *testing.T) {
+	server := newRecordingServer(t, `{"request_id":"abc","data":{"succeeded":1}}`)
+	send, err := NewSmtp2go("api-key", WithBaseURL(server.URL))
+	require.NoError(t, err)
+
+	response, err := send.SendMail("Sender", "sender@example.com", "Recipient", "recipient@example.com", "Subject", "text", "")
+	require.NoError(t, err)
+	assert.Equal(t, "RequestId: abc", response.Body)
+	assert.Equal(t, "/v3/email/send", server.request.URL.Path)
+	assert.Equal(t, "api-key", server.request.Header.Get("X-Smtp2go-Api-Key"))
+	assert.Equal(t, "Sender <sender@example.com>", server.body["sender"])
+}
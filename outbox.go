@@ -0,0 +1,246 @@
+package sendmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// OutboxStatus is the delivery state of an outbox entry
+type OutboxStatus string
+
+const (
+	// OutboxPending entries are waiting to be sent, or to be retried
+	OutboxPending OutboxStatus = "pending"
+	// OutboxSent entries have been accepted by the sender
+	OutboxSent OutboxStatus = "sent"
+	// OutboxDead entries failed permanently and will not be retried
+	OutboxDead OutboxStatus = "dead"
+)
+
+var ErrOutboxEntryNotFound = errors.New("sendmail: outbox entry not found")
+
+// OutboxEntry is a message persisted in an outbox together with its delivery state
+type OutboxEntry struct {
+	ID          string       `json:"id"`
+	Message     *Message     `json:"message"`
+	Status      OutboxStatus `json:"status"`
+	Attempts    int          `json:"attempts"`
+	LastError   string       `json:"last_error,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	NextAttempt time.Time    `json:"next_attempt"`
+	SentAt      time.Time    `json:"sent_at,omitzero"`
+}
+
+// OutboxStore persists outbox entries. Claim must hand each due entry to a single caller until its lease expires,
+// an entry whose lease expires without an Update is claimed again, which gives at-least-once delivery.
+type OutboxStore interface {
+	// Enqueue persists the message as a pending entry. The from address may be left to the WithDefaultFrom of the
+	// sender, the rest of the message is validated.
+	Enqueue(ctx context.Context, message *Message) (*OutboxEntry, error)
+	// Claim returns up to limit pending entries that are due, oldest first, and pushes their next attempt back by lease
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEntry, error)
+	// Update saves the status, attempts, last error, next attempt and sent time of the entry
+	Update(ctx context.Context, entry *OutboxEntry) error
+	// List returns the entries with the given status, oldest first
+	List(ctx context.Context, status OutboxStatus) ([]*OutboxEntry, error)
+}
+
+// OutboxDispatcher sends the pending entries of an outbox. Failed sends are retried with backoff, an entry that
+// fails validation, is rejected permanently or exhausts its attempts is moved to the dead state. Failures are
+// classified with IsProviderFailure.
+type OutboxDispatcher struct {
+	store  OutboxStore
+	sender SendMail
+
+	batchSize    int
+	pollInterval time.Duration
+	lease        time.Duration
+	maxAttempts  int
+	backoff      func(attempts int) time.Duration
+	logger       func(string, ...interface{})
+	now          func() time.Time
+}
+
+// DispatcherOption configures an OutboxDispatcher
+type DispatcherOption func(*OutboxDispatcher)
+
+// WithBatchSize sets the number of entries claimed at a time, default 50
+func WithBatchSize(size int) DispatcherOption {
+	return func(d *OutboxDispatcher) {
+		d.batchSize = size
+	}
+}
+
+// WithPollInterval sets how long Run waits when the outbox is empty, default 5 seconds
+func WithPollInterval(interval time.Duration) DispatcherOption {
+	return func(d *OutboxDispatcher) {
+		d.pollInterval = interval
+	}
+}
+
+// WithLease sets how long a claimed entry is hidden from other dispatchers, default 5 minutes.
+// It must be longer than a send takes, or a slow send may be repeated by another dispatcher.
+func WithLease(lease time.Duration) DispatcherOption {
+	return func(d *OutboxDispatcher) {
+		d.lease = lease
+	}
+}
+
+// WithMaxAttempts sets the attempts after which an entry is moved to the dead state, default 5
+func WithMaxAttempts(attempts int) DispatcherOption {
+	return func(d *OutboxDispatcher) {
+		d.maxAttempts = attempts
+	}
+}
+
+// WithBackoff sets the delay before an entry is retried, given the attempts made so far.
+// The default doubles from 30 seconds up to an hour.
+func WithBackoff(backoff func(attempts int) time.Duration) DispatcherOption {
+	return func(d *OutboxDispatcher) {
+		d.backoff = backoff
+	}
+}
+
+// WithDispatcherLogger overrides the default log.Printf logging of failed sends
+func WithDispatcherLogger(logger func(string, ...interface{})) DispatcherOption {
+	return func(d *OutboxDispatcher) {
+		d.logger = logger
+	}
+}
+
+// NewOutboxDispatcher creates a dispatcher sending the entries of store through sender
+func NewOutboxDispatcher(store OutboxStore, sender SendMail, opts ...DispatcherOption) *OutboxDispatcher {
+	d := &OutboxDispatcher{
+		store:        store,
+		sender:       sender,
+		batchSize:    50,
+		pollInterval: 5 * time.Second,
+		lease:        5 * time.Minute,
+		maxAttempts:  5,
+		backoff:      exponentialBackoff,
+		now:          time.Now,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.batchSize < 1 {
+		d.batchSize = 1
+	}
+	if d.maxAttempts < 1 {
+		d.maxAttempts = 1
+	}
+	return d
+}
+
+// Run dispatches entries until the context is done
+func (d *OutboxDispatcher) Run(ctx context.Context) error {
+	for {
+		processed, err := d.DispatchOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			d.logf("Outbox dispatch failed: error=%v", err)
+		}
+
+		// a full batch suggests more entries are due
+		wait := d.pollInterval
+		if err == nil && processed == d.batchSize {
+			wait = 0
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// DispatchOnce claims a batch of due entries and sends them, returning the number of entries processed
+func (d *OutboxDispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	entries, err := d.store.Claim(ctx, d.batchSize, d.lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			// the remaining claims expire with their lease
+			return i, err
+		}
+		d.dispatch(entry)
+		if err := d.store.Update(ctx, entry); err != nil {
+			return i, fmt.Errorf("sendmail: outbox entry %s: %w", entry.ID, err)
+		}
+	}
+	return len(entries), nil
+}
+
+func (d *OutboxDispatcher) dispatch(entry *OutboxEntry) {
+	now := d.now()
+	entry.Attempts++
+
+	if err := entry.Message.validateDeferred(); err != nil {
+		d.kill(entry, err)
+		return
+	}
+	response, err := d.sender.SendMessage(entry.Message)
+	if err == nil {
+		entry.Status = OutboxSent
+		entry.LastError = ""
+		entry.SentAt = now
+		return
+	}
+	// a message rejected by the sender or the provider fails the same way on every attempt
+	if !IsProviderFailure(response, err) || entry.Attempts >= d.maxAttempts {
+		d.kill(entry, err)
+		return
+	}
+
+	entry.LastError = err.Error()
+	entry.NextAttempt = now.Add(d.backoff(entry.Attempts))
+	d.logf("Outbox send failed: id=%s, attempts=%d, retry_at=%s, error=%v", entry.ID, entry.Attempts, entry.NextAttempt.Format(time.RFC3339), err)
+}
+
+// kill moves a poison entry to the dead state
+func (d *OutboxDispatcher) kill(entry *OutboxEntry, err error) {
+	entry.Status = OutboxDead
+	entry.LastError = err.Error()
+	d.logf("Outbox entry dead: id=%s, attempts=%d, error=%v", entry.ID, entry.Attempts, err)
+}
+
+// logf logs message either via defined user logger or via system one if no user logger is defined.
+func (d *OutboxDispatcher) logf(f string, args ...interface{}) {
+	if d.logger != nil {
+		d.logger(f, args...)
+	} else {
+		log.Printf(f, args...)
+	}
+}
+
+func exponentialBackoff(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	return min(delay, time.Hour)
+}
+
+// newOutboxEntry creates a pending entry, IDs sort in creation order
+func newOutboxEntry(message *Message, now time.Time) *OutboxEntry {
+	return &OutboxEntry{
+		ID:          fmt.Sprintf("%020d-%s", now.UnixNano(), randomHex(4)),
+		Message:     message,
+		Status:      OutboxPending,
+		CreatedAt:   now,
+		NextAttempt: now,
+	}
+}
+
+// sortOutboxEntries sorts entries oldest first
+func sortOutboxEntries(entries []*OutboxEntry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+}
@@ -0,0 +1,254 @@
+package sendmail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboxDispatcher_RetriesAndDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryOutboxStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	good, err := store.Enqueue(ctx, testMessage(t))
+	require.NoError(t, err)
+	poison := testMessage(t)
+	poison.Subject = "poison"
+	_, err = store.Enqueue(ctx, poison)
+	require.NoError(t, err)
+
+	backend := &fakeSender{sendFunc: func(message *Message) (*Response, error) {
+		if message.Subject == "poison" {
+			return nil, errors.New("rejected")
+		}
+		return &Response{StatusCode: 202}, nil
+	}}
+	dispatcher := NewOutboxDispatcher(store, backend,
+		WithMaxAttempts(2),
+		WithBackoff(func(int) time.Duration { return time.Minute }),
+		WithDispatcherLogger(func(string, ...interface{}) {}))
+	dispatcher.now = store.now
+
+	processed, err := dispatcher.DispatchOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, processed)
+
+	sent, err := store.List(ctx, OutboxSent)
+	require.NoError(t, err)
+	require.Len(t, sent, 1)
+	assert.Equal(t, good.ID, sent[0].ID)
+	assert.Equal(t, 1, sent[0].Attempts)
+
+	pending, err := store.List(ctx, OutboxPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, "rejected", pending[0].LastError)
+	assert.Equal(t, now.Add(time.Minute), pending[0].NextAttempt)
+
+	// not due until the backoff has passed
+	processed, err = dispatcher.DispatchOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, processed)
+
+	now = now.Add(time.Minute)
+	_, err = dispatcher.DispatchOnce(ctx)
+	require.NoError(t, err)
+	dead, err := store.List(ctx, OutboxDead)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, 2, dead[0].Attempts)
+	assert.Equal(t, 3, backend.count())
+}
+
+func TestOutboxDispatcher_InvalidMessageIsDead(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryOutboxStore()
+	entry, err := store.Enqueue(ctx, testMessage(t))
+	require.NoError(t, err)
+	entry.Message.Subject = ""
+	require.NoError(t, store.Update(ctx, entry))
+
+	backend := &fakeSender{}
+	dispatcher := NewOutboxDispatcher(store, backend, WithDispatcherLogger(func(string, ...interface{}) {}))
+	_, err = dispatcher.DispatchOnce(ctx)
+	require.NoError(t, err)
+
+	dead, err := store.List(ctx, OutboxDead)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, ErrMissingSubject.Error(), dead[0].LastError)
+	assert.Equal(t, 0, backend.count())
+}
+
+func TestOutboxDispatcher_PermanentRejectionIsDead(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryOutboxStore()
+	// the from address is left to the sender's default
+	message := testMessage(t)
+	message.FromEmail = nil
+	_, err := store.Enqueue(ctx, message)
+	require.NoError(t, err)
+	throttled := testMessage(t)
+	throttled.Subject = "throttled"
+	_, err = store.Enqueue(ctx, throttled)
+	require.NoError(t, err)
+
+	backend := &fakeSender{sendFunc: func(message *Message) (*Response, error) {
+		if message.Subject == "throttled" {
+			return &Response{StatusCode: 429}, &ProviderError{StatusCode: 429, Err: errors.New("slow down")}
+		}
+		return &Response{StatusCode: 422}, &ProviderError{StatusCode: 422, Err: errors.New("unprocessable")}
+	}}
+	dispatcher := NewOutboxDispatcher(store, backend, WithDispatcherLogger(func(string, ...interface{}) {}))
+	_, err = dispatcher.DispatchOnce(ctx)
+	require.NoError(t, err)
+
+	dead, err := store.List(ctx, OutboxDead)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Nil(t, dead[0].Message.FromEmail)
+	assert.Equal(t, 1, dead[0].Attempts)
+
+	pending, err := store.List(ctx, OutboxPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, "throttled", pending[0].Message.Subject)
+
+	_, err = store.Enqueue(ctx, &Message{FromEmail: &Email{Address: "not an address"}})
+	assert.ErrorIs(t, err, ErrInvalidMessage)
+}
+
+func TestFileOutboxStore_SurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewFileOutboxStore(dir)
+	require.NoError(t, err)
+
+	_, err = store.Enqueue(ctx, &Message{})
+	assert.ErrorIs(t, err, ErrMissingRecipients)
+
+	first, err := store.Enqueue(ctx, testMessage(t))
+	require.NoError(t, err)
+	second, err := store.Enqueue(ctx, testMessage(t))
+	require.NoError(t, err)
+
+	claimed, err := store.Claim(ctx, 1, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, first.ID, claimed[0].ID)
+
+	// a new process sees the entries, the claimed one stays hidden until its lease expires
+	reopened, err := NewFileOutboxStore(dir)
+	require.NoError(t, err)
+	claimed, err = reopened.Claim(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, second.ID, claimed[0].ID)
+	assert.Equal(t, testMessage(t), claimed[0].Message)
+
+	reopened.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	claimed, err = reopened.Claim(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	assert.Len(t, claimed, 2)
+
+	claimed[0].Status = OutboxSent
+	require.NoError(t, reopened.Update(ctx, claimed[0]))
+	sent, err := store.List(ctx, OutboxSent)
+	require.NoError(t, err)
+	require.Len(t, sent, 1)
+	assert.Equal(t, first.ID, sent[0].ID)
+
+	assert.ErrorIs(t, store.Update(ctx, &OutboxEntry{ID: "missing"}), ErrOutboxEntryNotFound)
+}
+
+func TestFileOutboxStore_Layout(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewFileOutboxStore(dir)
+	require.NoError(t, err)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	var entries []*OutboxEntry
+	for i := 0; i < 3; i++ {
+		entry, err := store.Enqueue(ctx, testMessage(t))
+		require.NoError(t, err)
+		entries = append(entries, entry)
+	}
+	entries[0].Status, entries[0].SentAt = OutboxSent, now.Add(-2*time.Hour)
+	entries[1].Status, entries[1].SentAt = OutboxSent, now
+	entries[2].Status = OutboxDead
+	for _, entry := range entries {
+		require.NoError(t, store.Update(ctx, entry))
+	}
+
+	// delivered entries leave the directory read by Claim
+	assert.FileExists(t, filepath.Join(dir, "sent", entries[0].ID+".json"))
+	assert.FileExists(t, filepath.Join(dir, "dead", entries[2].ID+".json"))
+	assert.NoFileExists(t, filepath.Join(dir, entries[0].ID+".json"))
+	claimed, err := store.Claim(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+
+	purged, err := store.Purge(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	sent, err := store.List(ctx, OutboxSent)
+	require.NoError(t, err)
+	require.Len(t, sent, 1)
+	assert.Equal(t, entries[1].ID, sent[0].ID)
+	dead, err := store.List(ctx, OutboxDead)
+	require.NoError(t, err)
+	assert.Len(t, dead, 1)
+
+	// a pending copy left by a crash is dropped, an entry of the flat layout is moved to its directory
+	pending := *entries[1]
+	pending.Status = OutboxPending
+	data, err := json.Marshal(&pending)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, pending.ID+".json"), data, 0o644))
+	legacy := &OutboxEntry{ID: "legacy", Message: testMessage(t), Status: OutboxDead, CreatedAt: now}
+	data, err = json.Marshal(legacy)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "legacy.json"), data, 0o644))
+
+	claimed, err = store.Claim(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+	assert.NoFileExists(t, filepath.Join(dir, pending.ID+".json"))
+	assert.FileExists(t, filepath.Join(dir, "dead", "legacy.json"))
+
+	assert.Error(t, store.Update(ctx, &OutboxEntry{ID: entries[1].ID, Status: "../archived"}))
+}
+
+func TestOutboxDispatcher_Run(t *testing.T) {
+	store := NewMemoryOutboxStore()
+	backend := &fakeSender{}
+	dispatcher := NewOutboxDispatcher(store, backend, WithPollInterval(time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- dispatcher.Run(ctx) }()
+
+	_, err := store.Enqueue(ctx, testMessage(t))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return backend.count() == 1 }, time.Second, time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, exponentialBackoff(1))
+	assert.Equal(t, 2*time.Minute, exponentialBackoff(3))
+	assert.Equal(t, time.Hour, exponentialBackoff(20))
+}
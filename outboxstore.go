@@ -0,0 +1,309 @@
+package sendmail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryOutboxStore keeps the outbox in memory, for tests and for processes that can afford to lose it
+type MemoryOutboxStore struct {
+	mu      sync.Mutex
+	entries map[string]*OutboxEntry
+	now     func() time.Time
+}
+
+// NewMemoryOutboxStore creates an empty in-memory outbox
+func NewMemoryOutboxStore() *MemoryOutboxStore {
+	return &MemoryOutboxStore{entries: map[string]*OutboxEntry{}, now: time.Now}
+}
+
+func (s *MemoryOutboxStore) Enqueue(ctx context.Context, message *Message) (*OutboxEntry, error) {
+	if err := message.validateDeferred(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := newOutboxEntry(message, s.now())
+	s.entries[entry.ID] = entry
+	copied := *entry
+	return &copied, nil
+}
+
+func (s *MemoryOutboxStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	var claimed []*OutboxEntry
+	for _, entry := range s.sorted(OutboxPending) {
+		if len(claimed) == limit {
+			break
+		}
+		if entry.NextAttempt.After(now) {
+			continue
+		}
+		entry.NextAttempt = now.Add(lease)
+		copied := *entry
+		claimed = append(claimed, &copied)
+	}
+	return claimed, nil
+}
+
+func (s *MemoryOutboxStore) Update(ctx context.Context, entry *OutboxEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[entry.ID]; !ok {
+		return ErrOutboxEntryNotFound
+	}
+	copied := *entry
+	s.entries[entry.ID] = &copied
+	return nil
+}
+
+func (s *MemoryOutboxStore) List(ctx context.Context, status OutboxStatus) ([]*OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []*OutboxEntry
+	for _, entry := range s.sorted(status) {
+		copied := *entry
+		list = append(list, &copied)
+	}
+	return list, nil
+}
+
+// Purge deletes the sent entries sent more than olderThan ago and returns how many were deleted
+func (s *MemoryOutboxStore) Purge(ctx context.Context, olderThan time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := s.now().Add(-olderThan)
+	purged := 0
+	for id, entry := range s.entries {
+		if entry.Status == OutboxSent && entry.SentAt.Before(cutoff) {
+			delete(s.entries, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (s *MemoryOutboxStore) sorted(status OutboxStatus) []*OutboxEntry {
+	var list []*OutboxEntry
+	for _, entry := range s.entries {
+		if entry.Status == status {
+			list = append(list, entry)
+		}
+	}
+	sortOutboxEntries(list)
+	return list
+}
+
+// FileOutboxStore keeps each outbox entry as a JSON file in a directory. Files are replaced atomically, so an entry
+// survives a crash in either its old or its new state. Pending entries are kept at the top of the directory, sent
+// and dead entries in the sent and dead subdirectories, so that claiming does not read the delivered entries.
+// Claims are only coordinated within the process, a directory must not be shared by dispatchers running in different
+// processes.
+type FileOutboxStore struct {
+	Dir string
+
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// fileOutboxStatuses are the statuses a FileOutboxStore keeps a directory for
+var fileOutboxStatuses = []OutboxStatus{OutboxPending, OutboxSent, OutboxDead}
+
+// NewFileOutboxStore creates the directories if necessary
+func NewFileOutboxStore(dir string) (*FileOutboxStore, error) {
+	s := &FileOutboxStore{Dir: dir, now: time.Now}
+	for _, status := range fileOutboxStatuses {
+		if err := os.MkdirAll(s.dir(status), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *FileOutboxStore) Enqueue(ctx context.Context, message *Message) (*OutboxEntry, error) {
+	if err := message.validateDeferred(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := newOutboxEntry(message, s.now())
+	if err := s.write(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *FileOutboxStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.read(OutboxPending)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	var claimed []*OutboxEntry
+	for _, entry := range entries {
+		if len(claimed) == limit {
+			break
+		}
+		if entry.NextAttempt.After(now) {
+			continue
+		}
+		entry.NextAttempt = now.Add(lease)
+		if err := s.write(entry); err != nil {
+			return claimed, err
+		}
+		claimed = append(claimed, entry)
+	}
+	return claimed, nil
+}
+
+func (s *FileOutboxStore) Update(ctx context.Context, entry *OutboxEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, status := range fileOutboxStatuses {
+		if _, err := os.Stat(s.path(status, entry.ID)); err == nil {
+			return s.write(entry)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return ErrOutboxEntryNotFound
+}
+
+func (s *FileOutboxStore) List(ctx context.Context, status OutboxStatus) ([]*OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(status)
+}
+
+// Purge deletes the sent entries sent more than olderThan ago and returns how many were deleted. Dead entries are
+// kept for inspection.
+func (s *FileOutboxStore) Purge(ctx context.Context, olderThan time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.read(OutboxSent)
+	if err != nil {
+		return 0, err
+	}
+	cutoff := s.now().Add(-olderThan)
+	purged := 0
+	for _, entry := range entries {
+		if !entry.SentAt.Before(cutoff) {
+			continue
+		}
+		if err := os.Remove(s.path(OutboxSent, entry.ID)); err != nil && !os.IsNotExist(err) {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// dir returns the directory holding the entries with the given status
+func (s *FileOutboxStore) dir(status OutboxStatus) string {
+	if status == OutboxPending {
+		return s.Dir
+	}
+	return filepath.Join(s.Dir, string(status))
+}
+
+func (s *FileOutboxStore) path(status OutboxStatus, id string) string {
+	return filepath.Join(s.dir(status), id+".json")
+}
+
+// write replaces the entry file through a rename, so a crash never leaves a partial file behind. The files of the
+// entry in the other status directories are removed afterwards, a crash in between leaves both, see read.
+func (s *FileOutboxStore) write(entry *OutboxEntry) error {
+	if !slices.Contains(fileOutboxStatuses, entry.Status) {
+		return fmt.Errorf("sendmail: unknown outbox status %q", entry.Status)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir(entry.Status), ".tmp-"+entry.ID+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path(entry.Status, entry.ID)); err != nil {
+		return err
+	}
+	for _, status := range fileOutboxStatuses {
+		if status == entry.Status {
+			continue
+		}
+		if err := os.Remove(s.path(status, entry.ID)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// read loads the entries with the given status, file names sort in creation order. A pending file left behind by a
+// crash during write is removed once the entry is found in another status, and files of another status, e.g. written
+// before the subdirectories were introduced, are moved to their directory.
+func (s *FileOutboxStore) read(status OutboxStatus) ([]*OutboxEntry, error) {
+	files, err := os.ReadDir(s.dir(status))
+	if err != nil {
+		return nil, err
+	}
+	var entries []*OutboxEntry
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir(status), name))
+		if err != nil {
+			return nil, err
+		}
+		entry := &OutboxEntry{}
+		if err := json.Unmarshal(data, entry); err != nil {
+			return nil, fmt.Errorf("sendmail: outbox entry %s: %w", name, err)
+		}
+		if entry.Status != status {
+			if err := s.write(entry); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if status == OutboxPending && s.settled(entry.ID) {
+			if err := os.Remove(s.path(OutboxPending, entry.ID)); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// settled reports whether the entry has a sent or dead file
+func (s *FileOutboxStore) settled(id string) bool {
+	for _, status := range []OutboxStatus{OutboxSent, OutboxDead} {
+		if _, err := os.Stat(s.path(status, id)); err == nil {
+			return true
+		}
+	}
+	return false
+}
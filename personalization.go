@@ -0,0 +1,129 @@
+package sendmail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"maps"
+	"regexp"
+	"strings"
+)
+
+// Personalization is a recipient of a personalized message. Each recipient receives their own copy, in which
+// {{name}} placeholders of the subject and content are replaced by their Variables, escaped in the html content. Recipients do not see each other.
+// Unsubscribe replaces the unsubscribe targets of the message for this recipient, see Unsubscriber.
+type Personalization struct {
+	To          *Email            `json:"to"`
+	Variables   map[string]string `json:"variables,omitempty"`
+	Unsubscribe *Unsubscribe      `json:"unsubscribe,omitempty"`
+}
+
+var ErrRecipientsWithPersonalizations = errors.New("sendmail: recipients and personalizations cannot be combined")
+
+// placeholder matches {{name}}, spaces inside the braces are allowed
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// Personalize expands a personalized message into one message per recipient, with the variables substituted.
+// Placeholders without a variable are left as they are. A message without personalizations is returned unchanged.
+func Personalize(message *Message) []*Message {
+	if len(message.Personalizations) == 0 {
+		return []*Message{message}
+	}
+	messages := make([]*Message, 0, len(message.Personalizations))
+	for _, personalization := range message.Personalizations {
+		copied := *message
+		copied.Personalizations = nil
+		copied.Recipients = []*Email{personalization.To}
+		copied.Unsubscribe = message.unsubscribeFor(personalization)
+		copied.Subject = substitute(message.Subject, personalization.Variables)
+		copied.PlainTextContent = substitute(message.PlainTextContent, personalization.Variables)
+		copied.HtmlContent = substituteHTML(message.HtmlContent, personalization.Variables)
+		messages = append(messages, &copied)
+	}
+	return messages
+}
+
+func substitute(content string, variables map[string]string) string {
+	return rewritePlaceholders(content, func(name string) (string, bool) {
+		value, ok := variables[name]
+		return value, ok
+	})
+}
+
+// substituteHTML replaces the placeholders with the HTML escaped values, so a variable can't add markup
+func substituteHTML(content string, variables map[string]string) string {
+	return rewritePlaceholders(content, func(name string) (string, bool) {
+		value, ok := variables[name]
+		return html.EscapeString(value), ok
+	})
+}
+
+// rewritePlaceholders replaces the placeholders for which replace returns true
+func rewritePlaceholders(content string, replace func(name string) (string, bool)) string {
+	return placeholder.ReplaceAllStringFunc(content, func(match string) string {
+		name := placeholder.FindStringSubmatch(match)[1]
+		if value, ok := replace(name); ok {
+			return value
+		}
+		return match
+	})
+}
+
+// sendPersonalized sends a personalized message as individual messages, for senders without native support.
+// The copies are sent one at a time, the senders are not required to be safe for concurrent use.
+func sendPersonalized(sender SendMail, message *Message) (*Response, error) {
+	results, err := SendBatch(context.Background(), sender, Personalize(message), WithBatchConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return personalizedResponse(results)
+}
+
+// personalizedResponse combines the results of the copies of a personalized message. The status is the one of the
+// first copy sent, the message ids of all copies are returned and the failures are joined into the error.
+func personalizedResponse(results []BatchResult) (*Response, error) {
+	var response, failed *Response
+	var errs []error
+	var messageIDs []string
+	seen := map[*Response]bool{}
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", result.Message.Recipients[0].Address, result.Err))
+			if failed == nil {
+				failed = result.Response
+			}
+			continue
+		}
+		// copies sent in a single request share the response
+		if result.Response == nil || seen[result.Response] {
+			continue
+		}
+		seen[result.Response] = true
+		if response == nil {
+			response = &Response{StatusCode: result.Response.StatusCode, Body: result.Response.Body, Headers: maps.Clone(result.Response.Headers)}
+		}
+		messageIDs = append(messageIDs, headerValues(result.Response.Headers, "X-Message-Id")...)
+	}
+
+	if response == nil {
+		return failed, errors.Join(errs...)
+	}
+	if len(messageIDs) > 0 {
+		if response.Headers == nil {
+			response.Headers = map[string][]string{}
+		}
+		response.Headers["X-Message-Id"] = messageIDs
+	}
+	return response, errors.Join(errs...)
+}
+
+// headerValues returns all values of a header, looked up case-insensitively
+func headerValues(headers map[string][]string, key string) []string {
+	for name, values := range headers {
+		if strings.EqualFold(name, key) {
+			return values
+		}
+	}
+	return nil
+}
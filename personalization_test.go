@@ -0,0 +1,153 @@
+package sendmail
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func personalizedMessage(t *testing.T) *Message {
+	message, err := NewEmailMessage().
+		FromEmail("Shop", "shop@example.com").
+		Subject("Hello {{first_name}}").
+		PlainTextContent("Hi {{ first_name }}, unsubscribe with {{token}}. {{unknown}}").
+		HtmlContent("<p>Hi {{first_name}}</p>").
+		AddPersonalization("Jane", "jane@example.com", map[string]string{"first_name": "Jane", "token": "t1"}).
+		AddPersonalization("", "joe@example.com", map[string]string{"first_name": "Joe", "token": "t2"}).
+		Build()
+	require.NoError(t, err)
+	return message
+}
+
+func TestPersonalize(t *testing.T) {
+	messages := Personalize(personalizedMessage(t))
+	require.Len(t, messages, 2)
+
+	assert.Equal(t, []*Email{{Name: "Jane", Address: "jane@example.com"}}, messages[0].Recipients)
+	assert.Equal(t, "Hello Jane", messages[0].Subject)
+	assert.Equal(t, "Hi Jane, unsubscribe with t1. {{unknown}}", messages[0].PlainTextContent)
+	assert.Equal(t, "<p>Hi Jane</p>", messages[0].HtmlContent)
+	assert.Empty(t, messages[0].Personalizations)
+
+	assert.Equal(t, "Hello Joe", messages[1].Subject)
+	assert.Equal(t, "Hi Joe, unsubscribe with t2. {{unknown}}", messages[1].PlainTextContent)
+
+	plain := testMessage(t)
+	assert.Equal(t, []*Message{plain}, Personalize(plain))
+}
+
+func TestPersonalize_EscapesHTML(t *testing.T) {
+	message := personalizedMessage(t)
+	message.Personalizations[0].Variables["first_name"] = `<a href="//evil.example">Jane</a> & co`
+	messages := Personalize(message)
+
+	assert.Equal(t, "<p>Hi &lt;a href=&#34;//evil.example&#34;&gt;Jane&lt;/a&gt; &amp; co</p>", messages[0].HtmlContent)
+	assert.Equal(t, `Hello <a href="//evil.example">Jane</a> & co`, messages[0].Subject)
+	assert.Contains(t, messages[0].PlainTextContent, `Hi <a href="//evil.example">Jane</a> & co,`)
+}
+
+func TestMessage_ValidatePersonalizations(t *testing.T) {
+	message := personalizedMessage(t)
+	message.Recipients = []*Email{{Address: "other@example.com"}}
+	assert.ErrorIs(t, message.Validate(), ErrRecipientsWithPersonalizations)
+	assert.ErrorIs(t, message.Validate(), ErrInvalidMessage)
+
+	message = personalizedMessage(t)
+	message.Personalizations[1].To = nil
+	assert.ErrorIs(t, message.Validate(), ErrMissingRecipients)
+}
+
+func TestFileSender_PersonalizedFanOut(t *testing.T) {
+	dir := t.TempDir()
+	send, err := NewFileSender(dir, WithLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+
+	response, err := send.SendMessage(personalizedMessage(t))
+	require.NoError(t, err)
+	require.Len(t, response.Headers["X-Message-Id"], 2)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	subjects := map[string]bool{}
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		require.NoError(t, err)
+		parsed, err := parseMIME(raw)
+		require.NoError(t, err)
+		subjects[parsed.Header.Get("To")+" "+parsed.Header.Get("Subject")] = true
+	}
+	assert.Equal(t, map[string]bool{`"Jane" <jane@example.com> Hello Jane`: true, "<joe@example.com> Hello Joe": true}, subjects)
+}
+
+func TestSendGrid_Personalizations(t *testing.T) {
+	server := newRecordingServer(t, "")
+	send, err := NewSendGrid("key", WithBaseURL(server.URL), WithLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+
+	_, err = send.SendMessage(personalizedMessage(t))
+	require.NoError(t, err)
+
+	assert.Equal(t, "Hello {{first_name}}", server.body["subject"])
+	content := server.body["content"].([]interface{})
+	assert.Equal(t, "Hi {{first_name}}, unsubscribe with {{token}}. {{unknown}}", content[0].(map[string]interface{})["value"])
+
+	personalizations := server.body["personalizations"].([]interface{})
+	require.Len(t, personalizations, 2)
+	jane := personalizations[0].(map[string]interface{})
+	assert.Equal(t, "jane@example.com", jane["to"].([]interface{})[0].(map[string]interface{})["email"])
+	assert.Equal(t, map[string]interface{}{
+		"{{first_name}}": "Jane", "{{token}}": "t1", "{{first_name|html}}": "Jane", "{{token|html}}": "t1",
+	}, jane["substitutions"])
+	assert.Equal(t, "<p>Hi {{first_name|html}}</p>", content[1].(map[string]interface{})["value"])
+}
+
+func TestMailJet_Personalizations(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"Messages":[{"Status":"success"},{"Status":"success"}]}`))
+	}))
+	defer server.Close()
+
+	send, err := NewMailJet("key", "secret", WithBaseURL(server.URL), WithLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+
+	message := personalizedMessage(t)
+	message.Personalizations[1].Variables["first_name"] = "<b>Joe</b> {{var:token}}"
+	_, err = send.SendMessage(message)
+	require.NoError(t, err)
+
+	messages := body["Messages"].([]interface{})
+	require.Len(t, messages, 2)
+	joe := messages[1].(map[string]interface{})
+	assert.Equal(t, "<p>Hi &lt;b&gt;Joe&lt;/b&gt; &#123;&#123;var:token}}</p>", joe["HTMLPart"])
+	assert.Equal(t, "Hello {{var:first_name}}", joe["Subject"])
+	assert.Equal(t, "Hi {{var:first_name}}, unsubscribe with {{var:token}}. {{unknown}}", joe["TextPart"])
+	assert.Equal(t, true, joe["TemplateLanguage"])
+	assert.Equal(t, map[string]interface{}{"first_name": "<b>Joe</b> {{var:token}}", "token": "t2"}, joe["Variables"])
+	assert.Equal(t, "joe@example.com", joe["To"].([]interface{})[0].(map[string]interface{})["Email"])
+}
+
+func TestMailerSend_Personalizations(t *testing.T) {
+	server := newRecordingServer(t, "")
+	send, err := NewMailerSend("token", WithBaseURL(server.URL), WithLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+
+	_, err = send.SendMessage(personalizedMessage(t))
+	require.NoError(t, err)
+
+	// the recording server keeps the last of the two requests
+	assert.Equal(t, "joe@example.com", server.body["to"].([]interface{})[0].(map[string]interface{})["email"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"email": "joe@example.com",
+		"data":  map[string]interface{}{"first_name": "Joe", "token": "t2"},
+	}}, server.body["personalization"])
+}
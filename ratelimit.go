@@ -0,0 +1,334 @@
+package sendmail
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("sendmail: rate limit exceeded")
+
+// RateLimitedSender limits the rate messages are passed to the wrapped sender.
+// A token bucket enforces the per-second limit and a rolling 24 hour window enforces the daily cap.
+// When the provider reports its own limits, via X-RateLimit-Remaining/X-RateLimit-Reset or a 429 with Retry-After,
+// sending is paused until the provider's window resets.
+type RateLimitedSender struct {
+	sender SendMail
+
+	perSecond float64
+	burst     int
+	daily     int
+	failFast  bool
+	adaptive  bool
+
+	mu          sync.Mutex
+	tokens      float64
+	lastRefill  time.Time
+	window      []minuteCount
+	pausedUntil time.Time
+	now         func() time.Time
+}
+
+// minuteCount is the number of messages sent within a minute, the granularity of the daily window
+type minuteCount struct {
+	minute time.Time
+	count  int
+}
+
+// RateLimitOption configures a RateLimitedSender
+type RateLimitOption func(*RateLimitedSender)
+
+// WithRatePerSecond limits the sustained rate, allowing bursts of up to burst messages
+func WithRatePerSecond(rate float64, burst int) RateLimitOption {
+	return func(r *RateLimitedSender) {
+		r.perSecond = rate
+		r.burst = burst
+	}
+}
+
+// WithDailyLimit caps the number of messages sent within any 24 hour window
+func WithDailyLimit(limit int) RateLimitOption {
+	return func(r *RateLimitedSender) {
+		r.daily = limit
+	}
+}
+
+// WithFailFast returns ErrRateLimited rather than waiting when a limit is reached
+func WithFailFast() RateLimitOption {
+	return func(r *RateLimitedSender) {
+		r.failFast = true
+	}
+}
+
+// WithoutProviderLimits ignores the rate limit headers returned by the provider
+func WithoutProviderLimits() RateLimitOption {
+	return func(r *RateLimitedSender) {
+		r.adaptive = false
+	}
+}
+
+// NewRateLimitedSender wraps sender with client side rate limiting
+func NewRateLimitedSender(sender SendMail, opts ...RateLimitOption) *RateLimitedSender {
+	r := &RateLimitedSender{
+		sender:   sender,
+		adaptive: true,
+		now:      time.Now,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.perSecond > 0 && r.burst < 1 {
+		r.burst = 1
+	}
+	r.tokens = float64(r.burst)
+	r.lastRefill = r.now()
+	return r
+}
+
+func (r *RateLimitedSender) SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent string) (*Response, error) {
+	return r.SendMailContext(context.Background(), fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent)
+}
+
+func (r *RateLimitedSender) SendMessage(message *Message) (*Response, error) {
+	return r.SendMessageContext(context.Background(), message)
+}
+
+// SendMailContext waits for the rate limit, giving up when the context is done
+func (r *RateLimitedSender) SendMailContext(ctx context.Context, fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent string) (*Response, error) {
+	if err := r.Wait(ctx); err != nil {
+		return nil, err
+	}
+	response, err := r.sender.SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent)
+	r.observe(response)
+	return response, err
+}
+
+// SendMessageContext waits for the rate limit, giving up when the context is done
+func (r *RateLimitedSender) SendMessageContext(ctx context.Context, message *Message) (*Response, error) {
+	if err := message.validateDeferred(); err != nil {
+		return nil, err
+	}
+	if err := r.Wait(ctx); err != nil {
+		return nil, err
+	}
+	response, err := r.sender.SendMessage(message)
+	r.observe(response)
+	return response, err
+}
+
+// SendBatch sends the messages in chunks as the limits allow: every message that can be reserved right away is
+// sent with a single SendBatch of the wrapped sender, which keeps its native batch endpoint, and the wait for the
+// next token only starts once that chunk is sent. With WithFailFast the messages over the limit fail with
+// ErrRateLimited and the others are sent.
+func (r *RateLimitedSender) SendBatch(ctx context.Context, messages []*Message, opts ...BatchOption) ([]BatchResult, error) {
+	results := newBatchResults(messages)
+	var pending []int
+	for i, message := range messages {
+		if err := message.validateDeferred(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	for len(pending) > 0 {
+		reserved := 0
+		for reserved < len(pending) && r.tryReserve() {
+			reserved++
+		}
+		if reserved == 0 {
+			if err := r.Wait(ctx); err != nil {
+				for _, index := range pending {
+					results[index].Err = err
+				}
+				if errors.Is(err, ErrRateLimited) {
+					return results, nil
+				}
+				return results, err
+			}
+			reserved = 1
+		}
+
+		prepared := make([]*Message, len(messages))
+		for _, index := range pending[:reserved] {
+			prepared[index] = messages[index]
+		}
+		err := forwardBatch(ctx, r.sender, prepared, results, opts...)
+		for _, index := range pending[:reserved] {
+			r.observe(results[index].Response)
+		}
+		pending = pending[reserved:]
+		if err != nil {
+			for _, index := range pending {
+				results[index].Err = err
+			}
+			return results, err
+		}
+	}
+	return results, ctx.Err()
+}
+
+// Wait blocks until a message may be sent and reserves it. With WithFailFast it returns ErrRateLimited instead of blocking.
+func (r *RateLimitedSender) Wait(ctx context.Context) error {
+	for {
+		r.mu.Lock()
+		delay := r.reserve()
+		r.mu.Unlock()
+		if delay == 0 {
+			return nil
+		}
+		if r.failFast {
+			return ErrRateLimited
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// tryReserve reserves a message when the limits allow it without waiting
+func (r *RateLimitedSender) tryReserve() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reserve() == 0
+}
+
+// reserve takes a token and records the send when allowed, otherwise it returns how long to wait
+func (r *RateLimitedSender) reserve() time.Duration {
+	now := r.now()
+	var delay time.Duration
+
+	if now.Before(r.pausedUntil) {
+		delay = r.pausedUntil.Sub(now)
+	}
+
+	if r.perSecond > 0 {
+		elapsed := now.Sub(r.lastRefill).Seconds()
+		r.tokens = math.Min(float64(r.burst), r.tokens+elapsed*r.perSecond)
+		r.lastRefill = now
+		if r.tokens < 1 {
+			wait := time.Duration((1 - r.tokens) / r.perSecond * float64(time.Second))
+			delay = max(delay, wait)
+		}
+	}
+
+	if r.daily > 0 {
+		r.pruneWindow(now)
+		sent := 0
+		for _, bucket := range r.window {
+			sent += bucket.count
+		}
+		if sent >= r.daily {
+			// wait for the oldest minute to leave the window
+			delay = max(delay, r.window[0].minute.Add(24*time.Hour+time.Minute).Sub(now))
+		}
+	}
+
+	if delay > 0 {
+		return delay
+	}
+
+	if r.perSecond > 0 {
+		r.tokens--
+	}
+	if r.daily > 0 {
+		minute := now.Truncate(time.Minute)
+		if last := len(r.window) - 1; last >= 0 && r.window[last].minute.Equal(minute) {
+			r.window[last].count++
+		} else {
+			r.window = append(r.window, minuteCount{minute: minute, count: 1})
+		}
+	}
+	return 0
+}
+
+func (r *RateLimitedSender) pruneWindow(now time.Time) {
+	cutoff := now.Add(-24 * time.Hour)
+	index := 0
+	for index < len(r.window) && !r.window[index].minute.Add(time.Minute).After(cutoff) {
+		index++
+	}
+	r.window = r.window[index:]
+}
+
+// observe pauses sending when the provider reports that its limit is exhausted
+func (r *RateLimitedSender) observe(response *Response) {
+	if !r.adaptive || response == nil {
+		return
+	}
+
+	now := r.now()
+	var until time.Time
+	if response.StatusCode == http.StatusTooManyRequests {
+		until = now.Add(time.Second)
+		if retryAfter, ok := parseRetryAfter(headerValue(response.Headers, "Retry-After"), now); ok {
+			until = retryAfter
+		}
+	}
+	if remaining, err := strconv.Atoi(headerValue(response.Headers, "X-RateLimit-Remaining")); err == nil && remaining <= 0 {
+		if reset, ok := parseRateLimitReset(headerValue(response.Headers, "X-RateLimit-Reset"), now); ok && reset.After(until) {
+			until = reset
+		} else if until.IsZero() {
+			until = now.Add(time.Second)
+		}
+	}
+
+	if until.IsZero() {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if until.After(r.pausedUntil) {
+		r.pausedUntil = until
+	}
+}
+
+// headerValue looks up a header case-insensitively, provider libraries do not all canonicalize the keys
+func headerValue(headers map[string][]string, key string) string {
+	if values := http.Header(headers).Values(key); len(values) > 0 {
+		return values[0]
+	}
+	for name, values := range headers {
+		if strings.EqualFold(name, key) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// parseRetryAfter reads the delay-seconds or HTTP-date form of Retry-After
+func parseRetryAfter(value string, now time.Time) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date, true
+	}
+	return time.Time{}, false
+}
+
+// parseRateLimitReset reads X-RateLimit-Reset, which providers send either as seconds until the reset or as a unix time
+func parseRateLimitReset(value string, now time.Time) (time.Time, bool) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return time.Time{}, false
+	}
+	// anything larger than a day is taken to be a unix timestamp
+	if seconds > 24*60*60 {
+		return time.Unix(seconds, 0), true
+	}
+	return now.Add(time.Duration(seconds) * time.Second), true
+}
@@ -0,0 +1,174 @@
+package sendmail
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitedSender_FailFast(t *testing.T) {
+	backend := &fakeSender{}
+	limited := NewRateLimitedSender(backend, WithRatePerSecond(1, 2), WithFailFast())
+
+	_, err := limited.SendMessage(testMessage(t))
+	require.NoError(t, err)
+	_, err = limited.SendMessage(testMessage(t))
+	require.NoError(t, err)
+	_, err = limited.SendMessage(testMessage(t))
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Equal(t, 2, backend.count())
+}
+
+func TestRateLimitedSender_Blocks(t *testing.T) {
+	backend := &fakeSender{}
+	limited := NewRateLimitedSender(backend, WithRatePerSecond(20, 1))
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := limited.SendMessage(testMessage(t))
+		require.NoError(t, err)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+func TestRateLimitedSender_ContextCancelled(t *testing.T) {
+	limited := NewRateLimitedSender(&fakeSender{}, WithRatePerSecond(0.1, 1))
+	_, err := limited.SendMessage(testMessage(t))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = limited.SendMessageContext(ctx, testMessage(t))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRateLimitedSender_DailyLimit(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	limited := NewRateLimitedSender(&fakeSender{}, WithDailyLimit(2), WithFailFast())
+	limited.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		_, err := limited.SendMessage(testMessage(t))
+		require.NoError(t, err)
+		now = now.Add(time.Hour)
+	}
+	_, err := limited.SendMessage(testMessage(t))
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	// the first send leaves the rolling window a day later
+	now = now.Add(23 * time.Hour)
+	_, err = limited.SendMessage(testMessage(t))
+	assert.NoError(t, err)
+}
+
+func TestRateLimitedSender_ProviderHeaders(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	backend := &fakeSender{response: &Response{
+		StatusCode: http.StatusOK,
+		Headers: map[string][]string{
+			"X-Ratelimit-Remaining": {"0"},
+			"X-Ratelimit-Reset":     {strconv.FormatInt(now.Add(time.Minute).Unix(), 10)},
+		},
+	}}
+	limited := NewRateLimitedSender(backend, WithFailFast())
+	limited.now = func() time.Time { return now }
+
+	_, err := limited.SendMessage(testMessage(t))
+	require.NoError(t, err)
+	_, err = limited.SendMessage(testMessage(t))
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	now = now.Add(61 * time.Second)
+	_, err = limited.SendMessage(testMessage(t))
+	assert.NoError(t, err)
+}
+
+func TestRateLimitedSender_RetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	backend := &fakeSender{response: &Response{
+		StatusCode: http.StatusTooManyRequests,
+		Headers:    map[string][]string{"retry-after": {"30"}},
+	}}
+	limited := NewRateLimitedSender(backend, WithFailFast())
+	limited.now = func() time.Time { return now }
+
+	_, err := limited.SendMessage(testMessage(t))
+	require.NoError(t, err)
+	now = now.Add(29 * time.Second)
+	_, err = limited.SendMessage(testMessage(t))
+	assert.ErrorIs(t, err, ErrRateLimited)
+}
+
+func TestRateLimitedSender_ProviderRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"message":"Too Many Attempts.","ErrorMessage":"Too Many Attempts."}`))
+	}))
+	t.Cleanup(server.Close)
+	quiet := WithLogger(func(string, ...interface{}) {})
+
+	mailerSend, err := NewMailerSend("token", WithBaseURL(server.URL), quiet)
+	require.NoError(t, err)
+	mailJet, err := NewMailJet("key", "secret", WithBaseURL(server.URL), quiet)
+	require.NoError(t, err)
+
+	for _, sender := range []SendMail{mailerSend, mailJet} {
+		now := time.Now()
+		limited := NewRateLimitedSender(sender, WithFailFast())
+		limited.now = func() time.Time { return now }
+
+		response, err := limited.SendMessage(testMessage(t))
+		require.Error(t, err)
+		require.NotNil(t, response)
+		assert.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+
+		now = now.Add(29 * time.Second)
+		_, err = limited.SendMessage(testMessage(t))
+		assert.ErrorIs(t, err, ErrRateLimited)
+	}
+}
+
+// batchRecorder records the size of each batch it is sent
+type batchRecorder struct {
+	fakeSender
+	batches []int
+}
+
+func (b *batchRecorder) SendBatch(ctx context.Context, messages []*Message, opts ...BatchOption) ([]BatchResult, error) {
+	b.batches = append(b.batches, len(messages))
+	results := newBatchResults(messages)
+	for i, message := range messages {
+		results[i].Response, results[i].Err = b.SendMessage(message)
+	}
+	return results, nil
+}
+
+func TestRateLimitedSender_SendBatch(t *testing.T) {
+	backend := &batchRecorder{}
+	limited := NewRateLimitedSender(backend, WithRatePerSecond(20, 2))
+
+	// the from address is left to the wrapped sender
+	messages := batchMessages(t, 5)
+	messages[0].FromEmail = nil
+	results, err := limited.SendBatch(context.Background(), messages)
+	require.NoError(t, err)
+	for _, result := range results {
+		require.NoError(t, result.Err)
+	}
+	// the burst is sent at once, the rest as the tokens refill
+	assert.Equal(t, []int{2, 1, 1, 1}, backend.batches)
+
+	failFast := NewRateLimitedSender(&batchRecorder{}, WithRatePerSecond(1, 2), WithFailFast())
+	results, err = failFast.SendBatch(context.Background(), batchMessages(t, 3))
+	require.NoError(t, err)
+	assert.NoError(t, results[1].Err)
+	assert.ErrorIs(t, results[2].Err, ErrRateLimited)
+}
@@ -0,0 +1,317 @@
+package sendmail
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Factory builds a sender from its configuration
+type Factory func(config *ProviderConfig) (SendMail, error)
+
+// Registry holds the factories senders are built from, keyed by provider name
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]Factory
+}
+
+var ErrUnknownProvider = errors.New("sendmail: unknown provider")
+var ErrMissingCredential = errors.New("sendmail: missing credential")
+
+// DefaultRegistry contains the built-in providers: sendgrid, mailjet, mailersend, smtp2go, mailtrap, smtp, file and dsn
+var DefaultRegistry = newDefaultRegistry()
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{factories: map[string]Factory{}}
+}
+
+func newDefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.Register("sendgrid", func(c *ProviderConfig) (SendMail, error) {
+		key, err := c.Credential("api_key")
+		if err != nil {
+			return nil, err
+		}
+		return newWithOptions(c, func(opts []Option) (SendMail, error) { return NewSendGrid(key, opts...) })
+	})
+	r.Register("mailjet", func(c *ProviderConfig) (SendMail, error) {
+		key, err := c.Credential("api_key")
+		if err != nil {
+			return nil, err
+		}
+		secret, err := c.Credential("secret_key")
+		if err != nil {
+			return nil, err
+		}
+		return newWithOptions(c, func(opts []Option) (SendMail, error) { return NewMailJet(key, secret, opts...) })
+	})
+	r.Register("mailersend", func(c *ProviderConfig) (SendMail, error) {
+		token, err := c.Credential("api_token")
+		if err != nil {
+			return nil, err
+		}
+		return newWithOptions(c, func(opts []Option) (SendMail, error) { return NewMailerSend(token, opts...) })
+	})
+	r.Register("smtp2go", func(c *ProviderConfig) (SendMail, error) {
+		key, err := c.Credential("api_key")
+		if err != nil {
+			return nil, err
+		}
+		return newWithOptions(c, func(opts []Option) (SendMail, error) { return NewSmtp2go(key, opts...) })
+	})
+	r.Register("mailtrap", func(c *ProviderConfig) (SendMail, error) {
+		token, err := c.Credential("api_token")
+		if err != nil {
+			return nil, err
+		}
+		streamOpts, err := mailTrapStreamOptions(c.Options.Extra["stream"], c.Options.Extra["inbox"])
+		if err != nil {
+			return nil, err
+		}
+		return newWithOptions(c, func(opts []Option) (SendMail, error) {
+			return NewMailTrap(token, append(streamOpts, opts...)...)
+		})
+	})
+	r.Register("smtp", func(c *ProviderConfig) (SendMail, error) {
+		host, err := c.Credential("host")
+		if err != nil {
+			return nil, err
+		}
+		port := 0
+		if value := c.optionalCredential("port"); value != "" {
+			if port, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("sendmail: invalid smtp port: %w", err)
+			}
+		}
+		username := c.optionalCredential("username")
+		password := c.optionalCredential("password")
+		smtpOpts, err := startTLSOptions("starttls", c.Options.Extra["starttls"])
+		if err != nil {
+			return nil, err
+		}
+		return newWithOptions(c, func(opts []Option) (SendMail, error) {
+			return NewSMTP(host, port, username, password, append(smtpOpts, opts...)...)
+		})
+	})
+	r.Register("file", func(c *ProviderConfig) (SendMail, error) {
+		dir := c.Options.Extra["dir"]
+		if dir == "" {
+			return nil, fmt.Errorf("sendmail: file provider requires the dir option")
+		}
+		maildir, err := parseBool("maildir", c.Options.Extra["maildir"], false)
+		if err != nil {
+			return nil, err
+		}
+		return newWithOptions(c, func(opts []Option) (SendMail, error) {
+			if maildir {
+				return NewMaildirSender(dir, opts...)
+			}
+			return NewFileSender(dir, opts...)
+		})
+	})
+	r.Register("dsn", func(c *ProviderConfig) (SendMail, error) {
+		dsn, err := c.Credential("dsn")
+		if err != nil {
+			return nil, err
+		}
+		return newWithOptions(c, func(opts []Option) (SendMail, error) { return Open(dsn, opts...) })
+	})
+	return r
+}
+
+// Register adds a factory under the provider name, replacing any earlier registration
+func (r *Registry) Register(name string, factory Factory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[strings.ToLower(name)] = factory
+}
+
+// Providers returns the sorted list of registered provider names
+func (r *Registry) Providers() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Build creates a sender from a single provider configuration
+func (r *Registry) Build(config *ProviderConfig) (SendMail, error) {
+	r.mu.RLock()
+	factory, ok := r.factories[strings.ToLower(config.Provider)]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownProvider, config.Provider)
+	}
+	return factory(config)
+}
+
+// Load builds every sender described by the configuration
+func (r *Registry) Load(config *Config) (Senders, error) {
+	senders := Senders{}
+	for name, provider := range config.Senders {
+		send, err := r.Build(provider)
+		if err != nil {
+			return nil, fmt.Errorf("sendmail: sender %q: %w", name, err)
+		}
+		senders[name] = send
+	}
+	return senders, nil
+}
+
+// LoadFile reads a YAML or JSON configuration file and builds the senders using the DefaultRegistry
+func LoadFile(path string) (Senders, error) {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return DefaultRegistry.Load(config)
+}
+
+// Senders are the named senders built from a configuration
+type Senders map[string]SendMail
+
+// Get returns the named sender
+func (s Senders) Get(name string) (SendMail, error) {
+	send, ok := s[name]
+	if !ok {
+		return nil, fmt.Errorf("sendmail: no sender named %q", name)
+	}
+	return send, nil
+}
+
+// Config describes multiple named senders, e.g.
+//
+//	senders:
+//	  tenant-a:
+//	    provider: sendgrid
+//	    credentials:
+//	      api_key_env: TENANT_A_SENDGRID_KEY
+//	    options:
+//	      timeout: 5s
+//	    default_from:
+//	      name: Tenant A
+//	      email: no-reply@a.example.com
+//	  tenant-b:
+//	    provider: mailtrap
+//	    credentials:
+//	      api_token_env: TENANT_B_MAILTRAP_TOKEN
+//	    options:
+//	      stream: bulk
+type Config struct {
+	Senders map[string]*ProviderConfig `yaml:"senders" json:"senders"`
+}
+
+// ProviderConfig describes a single sender. A credential named with an _env suffix, e.g. api_key_env,
+// names the environment variable holding the value, so secrets do not need to be kept in the file.
+type ProviderConfig struct {
+	Provider    string            `yaml:"provider" json:"provider"`
+	Credentials map[string]string `yaml:"credentials" json:"credentials"`
+	Options     ProviderOptions   `yaml:"options" json:"options"`
+	DefaultFrom *FromConfig       `yaml:"default_from" json:"default_from"`
+}
+
+// ProviderOptions holds the common options, provider specific settings are kept in Extra
+type ProviderOptions struct {
+	Timeout   string            `yaml:"timeout" json:"timeout"`
+	BaseURL   string            `yaml:"base_url" json:"base_url"`
+	UserAgent string            `yaml:"user_agent" json:"user_agent"`
+	Extra     map[string]string `yaml:",inline" json:"-"`
+}
+
+// FromConfig is the default from address of a sender
+type FromConfig struct {
+	Name  string `yaml:"name" json:"name"`
+	Email string `yaml:"email" json:"email"`
+}
+
+// LoadConfig reads a YAML or JSON configuration file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("sendmail: %s: %w", filepath.Base(path), err)
+	}
+	return config, nil
+}
+
+// ParseConfig parses a YAML or JSON configuration, JSON being a subset of YAML
+func ParseConfig(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	for name, provider := range config.Senders {
+		if provider == nil || provider.Provider == "" {
+			return nil, fmt.Errorf("sender %q is missing a provider", name)
+		}
+	}
+	return config, nil
+}
+
+// Credential returns the named credential, resolving the name_env indirection.
+// ErrMissingCredential is returned when neither is set.
+func (c *ProviderConfig) Credential(name string) (string, error) {
+	if value := c.optionalCredential(name); value != "" {
+		return value, nil
+	}
+	if env, ok := c.Credentials[name+"_env"]; ok {
+		return "", fmt.Errorf("%w %q, environment variable %s is not set", ErrMissingCredential, name, env)
+	}
+	return "", fmt.Errorf("%w %q", ErrMissingCredential, name)
+}
+
+func (c *ProviderConfig) optionalCredential(name string) string {
+	if value := c.Credentials[name]; value != "" {
+		return value
+	}
+	if env := c.Credentials[name+"_env"]; env != "" {
+		return os.Getenv(env)
+	}
+	return ""
+}
+
+// SenderOptions converts the configured options into Options
+func (c *ProviderConfig) SenderOptions() ([]Option, error) {
+	var opts []Option
+	if c.Options.Timeout != "" {
+		timeout, err := time.ParseDuration(c.Options.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("sendmail: invalid timeout: %w", err)
+		}
+		opts = append(opts, WithTimeout(timeout))
+	}
+	if c.Options.BaseURL != "" {
+		opts = append(opts, WithBaseURL(c.Options.BaseURL))
+	}
+	if c.Options.UserAgent != "" {
+		opts = append(opts, WithUserAgent(c.Options.UserAgent))
+	}
+	if c.DefaultFrom != nil {
+		opts = append(opts, WithDefaultFrom(c.DefaultFrom.Name, c.DefaultFrom.Email))
+	}
+	return opts, nil
+}
+
+func newWithOptions(c *ProviderConfig, create func([]Option) (SendMail, error)) (SendMail, error) {
+	opts, err := c.SenderOptions()
+	if err != nil {
+		return nil, err
+	}
+	return create(opts)
+}
@@ -0,0 +1,112 @@
+package sendmail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+senders:
+  tenant-a:
+    provider: sendgrid
+    credentials:
+      api_key_env: TEST_TENANT_A_KEY
+    options:
+      timeout: 5s
+    default_from:
+      name: Tenant A
+      email: no-reply@a.example.com
+  tenant-b:
+    provider: mailtrap
+    credentials:
+      api_token: token
+    options:
+      stream: sandbox
+      inbox: 12345
+  tenant-c:
+    provider: file
+    options:
+      dir: %s
+      maildir: true
+`
+
+func TestRegistry_Load(t *testing.T) {
+	t.Setenv("TEST_TENANT_A_KEY", "key-a")
+	config, err := ParseConfig([]byte(fmt.Sprintf(testConfig, t.TempDir())))
+	require.NoError(t, err)
+
+	senders, err := DefaultRegistry.Load(config)
+	require.NoError(t, err)
+	assert.Len(t, senders, 3)
+
+	tenantA, err := senders.Get("tenant-a")
+	require.NoError(t, err)
+	sendGrid := tenantA.(*TrilloSendMail)
+	assert.Equal(t, "key-a", sendGrid.APIKey)
+	assert.Equal(t, "no-reply@a.example.com", sendGrid.options.defaultFrom.Address)
+
+	tenantB, err := senders.Get("tenant-b")
+	require.NoError(t, err)
+	assert.Equal(t, "https://sandbox.api.mailtrap.io/api/send/12345", tenantB.(*MailTrap).endpoint())
+
+	tenantC, err := senders.Get("tenant-c")
+	require.NoError(t, err)
+	assert.True(t, tenantC.(*FileSender).Maildir)
+
+	_, err = senders.Get("tenant-d")
+	assert.Error(t, err)
+}
+
+func TestRegistry_MissingCredential(t *testing.T) {
+	config, err := ParseConfig([]byte(`{"senders": {"tenant": {"provider": "sendgrid", "credentials": {"api_key_env": "TEST_UNSET_KEY"}}}}`))
+	require.NoError(t, err)
+
+	_, err = DefaultRegistry.Load(config)
+	assert.ErrorIs(t, err, ErrMissingCredential)
+	assert.Contains(t, err.Error(), "TEST_UNSET_KEY")
+}
+
+func TestRegistry_InvalidBool(t *testing.T) {
+	smtp := &ProviderConfig{Provider: "smtp", Credentials: map[string]string{"host": "smtp.example.com"},
+		Options: ProviderOptions{Extra: map[string]string{"starttls": "on"}}}
+	_, err := DefaultRegistry.Build(smtp)
+	assert.ErrorContains(t, err, "sendmail: invalid starttls")
+
+	smtp.Options.Extra["starttls"] = "false"
+	send, err := DefaultRegistry.Build(smtp)
+	require.NoError(t, err)
+	assert.False(t, send.(*SMTPMail).startTLS)
+
+	file := &ProviderConfig{Provider: "file", Options: ProviderOptions{Extra: map[string]string{"dir": t.TempDir(), "maildir": "yes"}}}
+	_, err = DefaultRegistry.Build(file)
+	assert.ErrorContains(t, err, "sendmail: invalid maildir")
+}
+
+func TestRegistry_Custom(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("Custom", func(c *ProviderConfig) (SendMail, error) {
+		return NewFileSender(c.Options.Extra["dir"])
+	})
+	assert.Equal(t, []string{"custom"}, registry.Providers())
+
+	send, err := registry.Build(&ProviderConfig{Provider: "custom", Options: ProviderOptions{Extra: map[string]string{"dir": t.TempDir()}}})
+	require.NoError(t, err)
+	assert.IsType(t, &FileSender{}, send)
+
+	_, err = registry.Build(&ProviderConfig{Provider: "sendgrid"})
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "senders.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"senders": {"dev": {"provider": "dsn", "credentials": {"dsn": "file://`+t.TempDir()+`"}}}}`), 0o644))
+
+	senders, err := LoadFile(path)
+	require.NoError(t, err)
+	assert.IsType(t, &FileSender{}, senders["dev"])
+}
@@ -3,33 +3,39 @@
 package sendmail
 
 import (
-	"fmt"
+	"html"
 	"log"
 
 	"github.com/malcolm-davis/go-stopwatch"
+	"github.com/sendgrid/rest"
 	sendgrid "github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
 )
 
 // SendMailManager provides functionality to send emails via SendGrid
 type TrilloSendMail struct {
-	APIKey string
-	client *sendgrid.Client
-
-	// User defined logger function.
-	Logger func(string, ...interface{})
+	APIKey  string
+	client  *rest.Client
+	request rest.Request
+	options *options
 }
 
 // NewSendGrid creates a new instance of TrilloSendMail
-func NewSendGrid(apiKey string) (*TrilloSendMail, error) {
-	client := sendgrid.NewSendClient(apiKey)
-	if client == nil {
-		return nil, fmt.Errorf("Failed to create SendGrid client")
+func NewSendGrid(apiKey string, opts ...Option) (*TrilloSendMail, error) {
+	o := newOptions(opts)
+
+	// an empty host selects the default https://api.sendgrid.com
+	request := sendgrid.GetRequest(apiKey, "/v3/mail/send", o.baseURL)
+	request.Method = rest.Post
+	if o.userAgent != "" {
+		request.Headers["User-Agent"] = o.userAgent
 	}
 
 	manager := &TrilloSendMail{
-		APIKey: apiKey,
-		client: client,
+		APIKey:  apiKey,
+		client:  &rest.Client{HTTPClient: o.client(0, false)},
+		request: request,
+		options: o,
 	}
 
 	return manager, nil
@@ -41,6 +47,7 @@ func (t *TrilloSendMail) SendMail(fromName, fromEmail, toName, toEmail, subject,
 		timer.StopE(err)
 	}()
 
+	fromName, fromEmail = t.options.from(fromName, fromEmail)
 	from := mail.NewEmail(fromName, fromEmail)
 	to := mail.NewEmail(toName, toEmail)
 	message := mail.NewSingleEmail(from, subject, to, plainTextContent, htmlContent)
@@ -53,18 +60,82 @@ func (t *TrilloSendMail) SendMessage(message *Message) (response *Response, err
 		timer.StopE(err)
 	}()
 
+	message = t.options.withDefaults(message)
 	err = message.Validate()
 	if err != nil {
 		return nil, err
 	}
 
+	if len(message.Personalizations) > 0 {
+		return t.sendPersonalized(message)
+	}
+
 	from := mail.NewEmail(message.FromEmail.Name, message.FromEmail.Address)
 	to := mail.NewEmail(message.Recipients[0].Name, message.Recipients[0].Address)
 
 	// sendgrid use a single email
 	email := mail.NewSingleEmail(from, message.Subject, to, message.PlainTextContent, message.HtmlContent)
+	addSendGridAttachments(email, message)
+	for _, header := range message.Unsubscribe.headers() {
+		email.SetHeader(header.name, header.value)
+	}
+
+	return t.post(email)
+}
+
+// sendGridPersonalizationLimit is the number of personalizations accepted by a single request
+const sendGridPersonalizationLimit = 1000
+
+// sendPersonalized maps each personalization to a SendGrid personalization, the variables become substitutions
+func (t *TrilloSendMail) sendPersonalized(message *Message) (*Response, error) {
+	// substitution tags are matched literally, so placeholders are written in a single form. The html content uses
+	// its own tags, substituted with the escaped values.
+	canonical := func(content string) string {
+		return rewritePlaceholders(content, func(name string) (string, bool) { return "{{" + name + "}}", true })
+	}
+	canonicalHTML := func(content string) string {
+		return rewritePlaceholders(content, func(name string) (string, bool) { return "{{" + name + "|html}}", true })
+	}
+
+	results := newBatchResults(Personalize(message))
+	for start := 0; start < len(message.Personalizations); start += sendGridPersonalizationLimit {
+		end := min(start+sendGridPersonalizationLimit, len(message.Personalizations))
 
-	// add any attachments
+		email := mail.NewV3Mail()
+		email.SetFrom(mail.NewEmail(message.FromEmail.Name, message.FromEmail.Address))
+		email.Subject = canonical(message.Subject)
+		if message.PlainTextContent != "" {
+			email.AddContent(mail.NewContent("text/plain", canonical(message.PlainTextContent)))
+		}
+		if message.HtmlContent != "" {
+			email.AddContent(mail.NewContent("text/html", canonicalHTML(message.HtmlContent)))
+		}
+		addSendGridAttachments(email, message)
+
+		for _, personalization := range message.Personalizations[start:end] {
+			p := mail.NewPersonalization()
+			p.AddTos(mail.NewEmail(personalization.To.Name, personalization.To.Address))
+			for _, header := range message.unsubscribeFor(personalization).headers() {
+				p.SetHeader(header.name, header.value)
+			}
+			for name, value := range personalization.Variables {
+				p.SetSubstitution("{{"+name+"}}", value)
+				if message.HtmlContent != "" {
+					p.SetSubstitution("{{"+name+"|html}}", html.EscapeString(value))
+				}
+			}
+			email.AddPersonalizations(p)
+		}
+
+		response, err := t.post(email)
+		for i := start; i < end; i++ {
+			results[i].Response, results[i].Err = response, err
+		}
+	}
+	return personalizedResponse(results)
+}
+
+func addSendGridAttachments(email *mail.SGMailV3, message *Message) {
 	for _, attachment := range message.Attachments {
 		// Create a new attach
 		attach := mail.NewAttachment()
@@ -78,21 +149,19 @@ func (t *TrilloSendMail) SendMessage(message *Message) (response *Response, err
 		// Add the attachment to the message
 		email.AddAttachment(attach)
 	}
-
-	return t.post(email)
 }
 
 func (t *TrilloSendMail) post(email *mail.SGMailV3) (response *Response, err error) {
-	client := t.client
-	if client == nil {
-		client = sendgrid.NewSendClient(t.APIKey)
-		if client == nil {
-			return nil, fmt.Errorf("Failed to create SendGrid client")
-		}
+	if t.client == nil {
+		t.client = rest.DefaultClient
+		t.request = sendgrid.GetRequest(t.APIKey, "/v3/mail/send", "")
+		t.request.Method = rest.Post
 		t.logf("Created new SendGrid client")
 	}
 
-	trilloResponse, err := client.Send(email)
+	request := t.request
+	request.Body = mail.GetRequestBody(email)
+	trilloResponse, err := t.client.Send(request)
 	if err != nil {
 		return nil, err
 	}
@@ -108,15 +177,15 @@ func (t *TrilloSendMail) post(email *mail.SGMailV3) (response *Response, err err
 
 	// Accept any 2xx response as success (SendGrid returns 202 Accepted).
 	if trilloResponse.StatusCode < 200 || trilloResponse.StatusCode >= 300 {
-		return response, fmt.Errorf("Failed to send email: %s", response.Body)
+		return response, providerError(response, "Failed to send email: %s", response.Body)
 	}
 	return response, nil
 }
 
 // logf logs message either via defined user logger or via system one if no user logger is defined.
 func (t *TrilloSendMail) logf(f string, args ...interface{}) {
-	if t.Logger != nil {
-		t.Logger(f, args...)
+	if t.options != nil && t.options.logger != nil {
+		t.options.logger(f, args...)
 	} else {
 		log.Printf(f, args...)
 	}
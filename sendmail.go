@@ -1,6 +1,7 @@
 package sendmail
 
 import (
+	"fmt"
 	"io"
 	"strings"
 )
@@ -12,6 +13,29 @@ type Response struct {
 	Headers    map[string][]string // e.g. map[X-Ratelimit-Limit:[600]]
 }
 
+// ProviderError is returned when a provider rejects a request, StatusCode is the status of the rejecting response
+type ProviderError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *ProviderError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// providerError reports a rejected request, the status code of a response outside the 2xx range is attached
+func providerError(response *Response, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	if response == nil || (response.StatusCode >= 200 && response.StatusCode < 300) {
+		return err
+	}
+	return &ProviderError{StatusCode: response.StatusCode, Err: err}
+}
+
 type SendMail interface {
 	// send mail is intended for a single recipient
 	SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent string) (response *Response, err error)
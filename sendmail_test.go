@@ -0,0 +1,55 @@
+package sendmail
+
+import (
+	"sync"
+)
+
+// fakeSender is an in-memory SendMail used by the decorator tests
+type fakeSender struct {
+	mu       sync.Mutex
+	messages []*Message
+	err      error
+	response *Response
+	// sendFunc, when set, replaces the default behaviour
+	sendFunc func(message *Message) (*Response, error)
+}
+
+func (f *fakeSender) SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent string) (*Response, error) {
+	return f.SendMessage(&Message{
+		FromEmail:        &Email{Name: fromName, Address: fromEmail},
+		Recipients:       []*Email{{Name: toName, Address: toEmail}},
+		Subject:          subject,
+		PlainTextContent: plainTextContent,
+		HtmlContent:      htmlContent,
+	})
+}
+
+func (f *fakeSender) SendMessage(message *Message) (*Response, error) {
+	f.mu.Lock()
+	f.messages = append(f.messages, message)
+	sendFunc, err, response := f.sendFunc, f.err, f.response
+	f.mu.Unlock()
+
+	if sendFunc != nil {
+		return sendFunc(message)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if response == nil {
+		response = &Response{StatusCode: 200}
+	}
+	return response, nil
+}
+
+func (f *fakeSender) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *fakeSender) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.messages)
+}
@@ -0,0 +1,206 @@
+// SMTP transport using the standard library net/smtp package
+package sendmail
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/malcolm-davis/go-stopwatch"
+)
+
+var ErrSMTPPlaintextAuth = errors.New("sendmail: SMTP server does not offer STARTTLS, refusing to authenticate in plaintext")
+
+// SMTPMail sends emails via any SMTP server, e.g. a relay, Postfix, or a local capture server.
+// The connection is upgraded with STARTTLS when the server offers it. Credentials are only sent over an encrypted
+// connection, unless WithSMTPPlaintextAuth is given.
+type SMTPMail struct {
+	host     string
+	port     int
+	username string
+	password string
+	startTLS bool
+	// timeout bounds the whole exchange with the server, connecting included
+	timeout time.Duration
+
+	options *options
+}
+
+// NewSMTP creates a new SMTP sender. Authentication is only attempted when a username is provided.
+// Of the options, WithTimeout, WithLogger, WithDefaultFrom, WithDKIM, WithoutSMTPStartTLS and WithSMTPPlaintextAuth
+// apply to SMTP.
+func NewSMTP(host string, port int, username, password string, opts ...Option) (*SMTPMail, error) {
+	o := newOptions(opts)
+
+	if host == "" {
+		return nil, fmt.Errorf("Failed to create SMTP client: missing host")
+	}
+	if port == 0 {
+		port = 587
+	}
+
+	manager := &SMTPMail{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		startTLS: !o.smtpNoStartTLS,
+		timeout:  30 * time.Second,
+		options:  o,
+	}
+	if o.timeout > 0 {
+		manager.timeout = o.timeout
+	}
+	return manager, nil
+}
+
+func (s *SMTPMail) SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent string) (response *Response, err error) {
+	timer := stopwatch.Start("SendMail", stopwatch.LogStop)
+	defer func() {
+		timer.StopE(err)
+	}()
+
+	fromName, fromEmail = s.options.from(fromName, fromEmail)
+	message, err := NewEmailMessage().
+		FromEmail(fromName, fromEmail).
+		AddRecipient(toName, toEmail).
+		Subject(subject).
+		PlainTextContent(plainTextContent).
+		HtmlContent(htmlContent).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	return s.post(message)
+}
+
+func (s *SMTPMail) SendMessage(message *Message) (response *Response, err error) {
+	timer := stopwatch.Start("SendMessage", stopwatch.LogStop)
+	defer func() {
+		timer.StopE(err)
+	}()
+
+	message = s.options.withDefaults(message)
+	err = message.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if len(message.Personalizations) > 0 {
+		return sendPersonalized(s, message)
+	}
+	return s.post(message)
+}
+
+func (s *SMTPMail) post(message *Message) (response *Response, err error) {
+	messageID := newMessageID(message)
+	data, err := renderMIME(message, messageID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if s.options != nil && s.options.dkim != nil {
+		if data, err = s.options.dkim.Sign(data); err != nil {
+			return nil, err
+		}
+	}
+
+	address := net.JoinHostPort(s.host, strconv.Itoa(s.port))
+	timeout := s.timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	// a stalled server fails the send instead of blocking it
+	if err = conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer client.Close()
+
+	if s.startTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err = client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if s.username != "" {
+		_, encrypted := client.TLSConnectionState()
+		if !encrypted && !s.options.smtpPlaintextAuth {
+			return nil, ErrSMTPPlaintextAuth
+		}
+		auth := smtp.PlainAuth("", s.username, s.password, s.host)
+		if !encrypted {
+			auth = plaintextAuth{auth}
+		}
+		if err = client.Auth(auth); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = client.Mail(message.FromEmail.Address); err != nil {
+		return nil, err
+	}
+	for _, recipient := range message.Recipients {
+		if err = client.Rcpt(recipient.Address); err != nil {
+			return nil, err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	if err = client.Quit(); err != nil {
+		return nil, err
+	}
+
+	s.logf("Send email: host=%s, message_id=%s", address, messageID)
+
+	response = &Response{
+		StatusCode: 200,
+		Headers:    map[string][]string{"X-Message-Id": {messageID}},
+	}
+	return response, nil
+}
+
+// plaintextAuth sends PLAIN credentials over an unencrypted connection, which smtp.PlainAuth only allows to
+// localhost. It is used once the caller opted in with WithSMTPPlaintextAuth.
+type plaintextAuth struct {
+	smtp.Auth
+}
+
+func (a plaintextAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	encrypted := *server
+	encrypted.TLS = true
+	return a.Auth.Start(&encrypted)
+}
+
+// logf logs message either via defined user logger or via system one if no user logger is defined.
+func (s *SMTPMail) logf(f string, args ...interface{}) {
+	if s.options != nil && s.options.logger != nil {
+		s.options.logger(f, args...)
+	} else {
+		log.Printf(f, args...)
+	}
+}
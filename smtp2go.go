@@ -3,8 +3,12 @@
 package sendmail
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
 
 	"github.com/malcolm-davis/go-stopwatch"
 	"github.com/smtp2go-oss/smtp2go-go"
@@ -16,15 +20,26 @@ import (
 
 // SendMailManager provides functionality to send emails via Smtp2go
 type Smtp2goMail struct {
+	// APIToken falls back to the SMTP2GO_API_KEY environment variable when empty
 	APIToken string
-
-	// User defined logger function.
-	Logger func(string, ...interface{})
+	client   *http.Client
+	apiRoot  string
+	options  *options
 }
 
-func NewSmtp2go(smtp2goKey string) (*Smtp2goMail, error) {
+func NewSmtp2go(smtp2goKey string, opts ...Option) (*Smtp2goMail, error) {
+	o := newOptions(opts)
+
+	apiRoot := "https://api.smtp2go.com/v3"
+	if o.baseURL != "" {
+		apiRoot = o.baseURL + "/v3"
+	}
+
 	manager := &Smtp2goMail{
 		APIToken: smtp2goKey,
+		client:   o.client(0, false),
+		apiRoot:  apiRoot,
+		options:  o,
 	}
 
 	return manager, nil
@@ -36,6 +51,7 @@ func (ms *Smtp2goMail) SendMail(fromName, fromEmail, toName, toEmail, subject, p
 		timer.StopE(err)
 	}()
 
+	fromName, fromEmail = ms.options.from(fromName, fromEmail)
 	from := fmt.Sprintf("%s <%s>", fromName, fromEmail)
 	message := smtp2go.Email{
 		From:     from,
@@ -54,10 +70,14 @@ func (ms *Smtp2goMail) SendMessage(message *Message) (response *Response, err er
 		timer.StopE(err)
 	}()
 
+	message = ms.options.withDefaults(message)
 	err = message.Validate()
 	if err != nil {
 		return nil, err
 	}
+	if len(message.Personalizations) > 0 {
+		return sendPersonalized(ms, message)
+	}
 
 	from := fmt.Sprintf("%s <%s>", message.FromEmail.Name, message.FromEmail.Address)
 
@@ -83,37 +103,83 @@ func (ms *Smtp2goMail) SendMessage(message *Message) (response *Response, err er
 		HtmlBody:    message.HtmlContent,
 		Attachments: attachmentList,
 	}
+	for _, header := range message.Unsubscribe.headers() {
+		email.CustomHeaders = append(email.CustomHeaders, &smtp2go.EmailCustomHeader{Header: header.name, Value: header.value})
+	}
 
 	return ms.post(email)
 }
 
+// post sends the email via the smtp2go api. The request is made directly, rather than via smtp2go.Send,
+// as the library only reads the api key and root from environment variables and uses its own http.Client.
 func (ms *Smtp2goMail) post(email smtp2go.Email) (response *Response, err error) {
+	apiKey := ms.APIToken
+	if apiKey == "" {
+		apiKey = os.Getenv(smtp2go.APIKeyEnv)
+	}
+	if apiKey == "" {
+		return nil, smtp2go.MissingAPIKeyError("")
+	}
+
+	apiRoot := ms.apiRoot
+	if apiRoot == "" {
+		apiRoot = "https://api.smtp2go.com/v3"
+	}
+	if ms.client == nil {
+		ms.client = &http.Client{}
+	}
 
-	res, err := smtp2go.Send(&email)
+	payload, err := json.Marshal(email)
 	if err != nil {
 		return nil, err
 	}
+	request, err := http.NewRequest(http.MethodPost, apiRoot+"/email/send", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(smtp2go.APIHeader, "smtp2go-go")
+	request.Header.Set(smtp2go.APIKeyHeader, apiKey)
 
-	if res == nil {
-		return nil, fmt.Errorf("No results returned from Smtp2go")
+	httpResponse, err := ms.client.Do(request)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(res.Data.Error) != 0 {
-		return nil, fmt.Errorf("Smtp2go error: %s", res.Data.Error)
+	body, err := readBody(httpResponse.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	res := &smtp2go.Smtp2goApiResult{}
+	if err = json.Unmarshal([]byte(body), res); err != nil {
+		// the status and headers are kept, a rate limited response may not have a json body
+		response = &Response{StatusCode: httpResponse.StatusCode, Body: body, Headers: httpResponse.Header}
+		return response, providerError(response, "Smtp2go error: status code %d, body: %s", httpResponse.StatusCode, body)
 	}
 
 	response = &Response{
-		StatusCode: 200,
+		StatusCode: httpResponse.StatusCode,
 		Body:       fmt.Sprintf("RequestId: %s", res.RequestId),
+		Headers:    httpResponse.Header,
+	}
+
+	if len(res.Data.Error) != 0 {
+		return response, providerError(response, "Smtp2go error: %s", res.Data.Error)
+	}
+	if httpResponse.StatusCode < 200 || httpResponse.StatusCode >= 300 {
+		return response, providerError(response, "Smtp2go error: status code %d, body: %s", httpResponse.StatusCode, body)
 	}
 
+	ms.logf("Send email: status_code=%d, request_id=%s", httpResponse.StatusCode, res.RequestId)
+
 	return response, nil
 }
 
 // logf logs message either via defined user logger or via system one if no user logger is defined.
 func (ms *Smtp2goMail) logf(f string, args ...interface{}) {
-	if ms.Logger != nil {
-		ms.Logger(f, args...)
+	if ms.options != nil && ms.options.logger != nil {
+		ms.options.logger(f, args...)
 	} else {
 		log.Printf(f, args...)
 	}
@@ -0,0 +1,66 @@
+package sendmail
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeSMTPAddress(t *testing.T, listener net.Listener) (string, int) {
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	portNumber, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	return host, portNumber
+}
+
+func TestSMTP_PlaintextAuth(t *testing.T) {
+	fake := newFakeCapture(t)
+	host, port := fakeSMTPAddress(t, fake.smtp)
+	quiet := WithLogger(func(string, ...interface{}) {})
+
+	// the fake server does not offer STARTTLS, the credentials are not sent
+	send, err := NewSMTP(host, port, "user", "secret", quiet)
+	require.NoError(t, err)
+	_, err = send.SendMessage(testMessage(t))
+	assert.ErrorIs(t, err, ErrSMTPPlaintextAuth)
+
+	send, err = NewSMTP(host, port, "user", "secret", WithSMTPPlaintextAuth(), quiet)
+	require.NoError(t, err)
+	_, err = send.SendMessage(testMessage(t))
+	require.NoError(t, err)
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	require.Len(t, fake.messages, 1)
+	assert.Equal(t, "AUTH PLAIN AHVzZXIAc2VjcmV0", fake.messages[0].auth)
+}
+
+func TestSMTP_Timeout(t *testing.T) {
+	// a server accepting the connection without ever answering
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	host, port := fakeSMTPAddress(t, listener)
+	send, err := NewSMTP(host, port, "", "", WithTimeout(100*time.Millisecond), WithLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = send.SendMessage(testMessage(t))
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,276 @@
+package sendmail
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLDialect selects the SQL flavour used by SQLOutboxStore
+type SQLDialect int
+
+const (
+	DialectSQLite SQLDialect = iota
+	DialectPostgres
+)
+
+// SQLOutboxStore keeps the outbox in a database/sql table. Timestamps are stored as unix nanoseconds.
+// With Postgres, entries are claimed with FOR UPDATE SKIP LOCKED, so any number of dispatchers can share the table.
+// SQLite serializes writers, the claiming UPDATE is atomic without row locks.
+type SQLOutboxStore struct {
+	db      *sql.DB
+	dialect SQLDialect
+	table   string
+	now     func() time.Time
+}
+
+// SQLOutboxOption configures an SQLOutboxStore
+type SQLOutboxOption func(*SQLOutboxStore)
+
+// WithOutboxTable sets the table name, default sendmail_outbox. The migrations table is named after it.
+func WithOutboxTable(table string) SQLOutboxOption {
+	return func(s *SQLOutboxStore) {
+		s.table = table
+	}
+}
+
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// NewSQLOutboxStore creates a store over db. Migrate must be called before the store is used.
+func NewSQLOutboxStore(db *sql.DB, dialect SQLDialect, opts ...SQLOutboxOption) (*SQLOutboxStore, error) {
+	s := &SQLOutboxStore{db: db, dialect: dialect, table: "sendmail_outbox", now: time.Now}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if dialect != DialectSQLite && dialect != DialectPostgres {
+		return nil, fmt.Errorf("sendmail: unknown SQL dialect %d", dialect)
+	}
+	if !sqlIdentifier.MatchString(s.table) {
+		return nil, fmt.Errorf("sendmail: invalid outbox table name %q", s.table)
+	}
+	return s, nil
+}
+
+// sqlMigrations are applied in order, the index plus one being the schema version
+var sqlMigrations = []string{
+	`CREATE TABLE {table} (
+		id TEXT PRIMARY KEY,
+		message TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at BIGINT NOT NULL,
+		next_attempt BIGINT NOT NULL,
+		sent_at BIGINT
+	)`,
+	`CREATE INDEX {name}_due ON {table} (status, next_attempt)`,
+}
+
+// Migrate creates or upgrades the outbox schema. It is safe to call from several instances starting at the same time,
+// the migrations are applied in a single transaction that holds a lock until it ends.
+func (s *SQLOutboxStore) Migrate(ctx context.Context) error {
+	migrations := s.table + "_migrations"
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		if s.dialect == DialectPostgres {
+			if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", s.migrationLock()); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+migrations+" (version INTEGER PRIMARY KEY)"); err != nil {
+			return err
+		}
+		if s.dialect == DialectSQLite {
+			// a write takes the SQLite write lock before the version is read, an existing table made the create a no-op
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+migrations+" WHERE version < 0"); err != nil {
+				return err
+			}
+		}
+
+		var version int
+		if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM "+migrations).Scan(&version); err != nil {
+			return err
+		}
+		for i := version; i < len(sqlMigrations); i++ {
+			if _, err := tx.ExecContext(ctx, s.migration(i)); err != nil {
+				return fmt.Errorf("migration %d: %w", i+1, err)
+			}
+			if _, err := tx.ExecContext(ctx, s.bind("INSERT INTO "+migrations+" (version) VALUES (?)"), i+1); err != nil {
+				return fmt.Errorf("migration %d: %w", i+1, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("sendmail: outbox migration: %w", err)
+	}
+	return nil
+}
+
+// migrationLock returns the Postgres advisory lock key of the table
+func (s *SQLOutboxStore) migrationLock() int64 {
+	key := fnv.New64a()
+	key.Write([]byte(s.table))
+	return int64(key.Sum64())
+}
+
+// migration returns the statement of migration i for the table, indexes are named after the unqualified table name
+func (s *SQLOutboxStore) migration(i int) string {
+	name := s.table[strings.LastIndex(s.table, ".")+1:]
+	return strings.NewReplacer("{table}", s.table, "{name}", name).Replace(sqlMigrations[i])
+}
+
+func (s *SQLOutboxStore) Enqueue(ctx context.Context, message *Message) (*OutboxEntry, error) {
+	return s.enqueue(ctx, s.db, message)
+}
+
+// EnqueueTx writes the message within tx, so it is only sent if the surrounding business transaction commits
+func (s *SQLOutboxStore) EnqueueTx(ctx context.Context, tx *sql.Tx, message *Message) (*OutboxEntry, error) {
+	return s.enqueue(ctx, tx, message)
+}
+
+func (s *SQLOutboxStore) enqueue(ctx context.Context, exec sqlExecer, message *Message) (*OutboxEntry, error) {
+	if err := message.validateDeferred(); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	entry := newOutboxEntry(message, s.now())
+	_, err = exec.ExecContext(ctx, s.bind("INSERT INTO "+s.table+
+		" (id, message, status, attempts, last_error, created_at, next_attempt) VALUES (?, ?, ?, ?, ?, ?, ?)"),
+		entry.ID, string(data), entry.Status, entry.Attempts, entry.LastError, entry.CreatedAt.UnixNano(), entry.NextAttempt.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *SQLOutboxStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEntry, error) {
+	now := s.now()
+	lock := ""
+	if s.dialect == DialectPostgres {
+		lock = " FOR UPDATE SKIP LOCKED"
+	}
+	query := "UPDATE " + s.table + " SET next_attempt = ? WHERE id IN (" +
+		"SELECT id FROM " + s.table + " WHERE status = ? AND next_attempt <= ? ORDER BY id LIMIT ?" + lock +
+		") RETURNING " + sqlOutboxColumns
+
+	rows, err := s.db.QueryContext(ctx, s.bind(query), now.Add(lease).UnixNano(), OutboxPending, now.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := scanOutboxEntries(rows)
+	if err != nil {
+		return nil, err
+	}
+	// RETURNING does not preserve the order of the subquery
+	sortOutboxEntries(entries)
+	return entries, nil
+}
+
+func (s *SQLOutboxStore) Update(ctx context.Context, entry *OutboxEntry) error {
+	var sentAt any
+	if !entry.SentAt.IsZero() {
+		sentAt = entry.SentAt.UnixNano()
+	}
+	result, err := s.db.ExecContext(ctx, s.bind("UPDATE "+s.table+
+		" SET status = ?, attempts = ?, last_error = ?, next_attempt = ?, sent_at = ? WHERE id = ?"),
+		entry.Status, entry.Attempts, entry.LastError, entry.NextAttempt.UnixNano(), sentAt, entry.ID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrOutboxEntryNotFound
+	}
+	return nil
+}
+
+func (s *SQLOutboxStore) List(ctx context.Context, status OutboxStatus) ([]*OutboxEntry, error) {
+	rows, err := s.db.QueryContext(ctx, s.bind("SELECT "+sqlOutboxColumns+" FROM "+s.table+" WHERE status = ? ORDER BY id"), status)
+	if err != nil {
+		return nil, err
+	}
+	return scanOutboxEntries(rows)
+}
+
+// Purge deletes the sent entries sent more than olderThan ago and returns how many were deleted
+func (s *SQLOutboxStore) Purge(ctx context.Context, olderThan time.Duration) (int, error) {
+	result, err := s.db.ExecContext(ctx, s.bind("DELETE FROM "+s.table+" WHERE status = ? AND sent_at < ?"),
+		OutboxSent, s.now().Add(-olderThan).UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	purged, err := result.RowsAffected()
+	return int(purged), err
+}
+
+const sqlOutboxColumns = "id, message, status, attempts, last_error, created_at, next_attempt, sent_at"
+
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func scanOutboxEntries(rows *sql.Rows) ([]*OutboxEntry, error) {
+	defer rows.Close()
+	var entries []*OutboxEntry
+	for rows.Next() {
+		var message string
+		var createdAt, nextAttempt int64
+		var sentAt sql.NullInt64
+		entry := &OutboxEntry{}
+		if err := rows.Scan(&entry.ID, &message, &entry.Status, &entry.Attempts, &entry.LastError, &createdAt, &nextAttempt, &sentAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(message), &entry.Message); err != nil {
+			return nil, fmt.Errorf("sendmail: outbox entry %s: %w", entry.ID, err)
+		}
+		entry.CreatedAt = time.Unix(0, createdAt)
+		entry.NextAttempt = time.Unix(0, nextAttempt)
+		if sentAt.Valid {
+			entry.SentAt = time.Unix(0, sentAt.Int64)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *SQLOutboxStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// bind rewrites ? placeholders to the $n form used by Postgres
+func (s *SQLOutboxStore) bind(query string) string {
+	if s.dialect != DialectPostgres {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,19 @@
+package sendmail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The SQLite tests live in the integration module, keeping the driver out of this module's dependencies
+func TestSQLOutboxStore_Postgres(t *testing.T) {
+	store, err := NewSQLOutboxStore(nil, DialectPostgres, WithOutboxTable("mail.outbox"))
+	require.NoError(t, err)
+	assert.Equal(t, "UPDATE t SET a = $1 WHERE b = $2", store.bind("UPDATE t SET a = ? WHERE b = ?"))
+	assert.Equal(t, "CREATE INDEX outbox_due ON mail.outbox (status, next_attempt)", store.migration(1))
+
+	_, err = NewSQLOutboxStore(nil, DialectPostgres, WithOutboxTable("outbox; DROP TABLE users"))
+	assert.Error(t, err)
+}
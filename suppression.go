@@ -0,0 +1,367 @@
+package sendmail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRecipientSuppressed = errors.New("sendmail: recipient is suppressed")
+
+// SuppressionReason records why an address is suppressed
+type SuppressionReason string
+
+const (
+	SuppressionHardBounce  SuppressionReason = "hard_bounce"
+	SuppressionComplaint   SuppressionReason = "complaint"
+	SuppressionUnsubscribe SuppressionReason = "unsubscribe"
+	SuppressionManual      SuppressionReason = "manual"
+)
+
+// Suppression is an address that must not be mailed until ExpiresAt, a zero ExpiresAt never expires
+type Suppression struct {
+	Address   string            `json:"address"`
+	Reason    SuppressionReason `json:"reason"`
+	Detail    string            `json:"detail,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at,omitzero"`
+}
+
+func (s *Suppression) expired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt)
+}
+
+// SuppressionList stores the suppressed addresses. Addresses are compared case-insensitively.
+type SuppressionList interface {
+	// Suppress adds the entry, replacing an earlier entry for the same address
+	Suppress(ctx context.Context, entry *Suppression) error
+	// Lookup returns the entry for address, false when the address is not suppressed or the entry expired
+	Lookup(ctx context.Context, address string) (*Suppression, bool, error)
+	// Remove deletes the entry for address, removing an unknown address is not an error
+	Remove(ctx context.Context, address string) error
+	// List returns the entries which have not expired, ordered by address
+	List(ctx context.Context) ([]*Suppression, error)
+}
+
+func suppressionKey(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+// MemorySuppressionList keeps the suppressions in memory
+type MemorySuppressionList struct {
+	mu      sync.Mutex
+	entries map[string]*Suppression
+	now     func() time.Time
+}
+
+func NewMemorySuppressionList() *MemorySuppressionList {
+	return &MemorySuppressionList{entries: map[string]*Suppression{}, now: time.Now}
+}
+
+func (l *MemorySuppressionList) Suppress(ctx context.Context, entry *Suppression) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[suppressionKey(entry.Address)] = l.prepare(entry)
+	return nil
+}
+
+func (l *MemorySuppressionList) Lookup(ctx context.Context, address string) (*Suppression, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, ok := l.entries[suppressionKey(address)]
+	if !ok || entry.expired(l.now()) {
+		return nil, false, nil
+	}
+	copied := *entry
+	return &copied, true, nil
+}
+
+func (l *MemorySuppressionList) Remove(ctx context.Context, address string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, suppressionKey(address))
+	return nil
+}
+
+func (l *MemorySuppressionList) List(ctx context.Context) ([]*Suppression, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return activeSuppressions(l.entries, l.now()), nil
+}
+
+// prepare copies the entry, normalizing the address and defaulting the creation time
+func (l *MemorySuppressionList) prepare(entry *Suppression) *Suppression {
+	copied := *entry
+	copied.Address = suppressionKey(entry.Address)
+	if copied.CreatedAt.IsZero() {
+		copied.CreatedAt = l.now()
+	}
+	return &copied
+}
+
+// activeSuppressions returns copies of the entries which have not expired, ordered by address
+func activeSuppressions(entries map[string]*Suppression, now time.Time) []*Suppression {
+	active := make([]*Suppression, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.expired(now) {
+			copied := *entry
+			active = append(active, &copied)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].Address < active[j].Address
+	})
+	return active
+}
+
+// FileSuppressionList keeps the suppressions in a JSON file. The file is read once when the list is created and
+// rewritten on every change, expired entries are dropped when the file is rewritten.
+type FileSuppressionList struct {
+	Path string
+
+	memory *MemorySuppressionList
+}
+
+// NewFileSuppressionList loads the list from path, a missing file is an empty list
+func NewFileSuppressionList(path string) (*FileSuppressionList, error) {
+	l := &FileSuppressionList{Path: path, memory: NewMemorySuppressionList()}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*Suppression
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("sendmail: suppression list %s: %w", path, err)
+	}
+	for _, entry := range entries {
+		l.memory.entries[suppressionKey(entry.Address)] = entry
+	}
+	return l, nil
+}
+
+func (l *FileSuppressionList) Suppress(ctx context.Context, entry *Suppression) error {
+	l.memory.mu.Lock()
+	defer l.memory.mu.Unlock()
+	key := suppressionKey(entry.Address)
+	previous, existed := l.memory.entries[key]
+	l.memory.entries[key] = l.memory.prepare(entry)
+	if err := l.write(); err != nil {
+		if existed {
+			l.memory.entries[key] = previous
+		} else {
+			delete(l.memory.entries, key)
+		}
+		return err
+	}
+	return nil
+}
+
+func (l *FileSuppressionList) Lookup(ctx context.Context, address string) (*Suppression, bool, error) {
+	return l.memory.Lookup(ctx, address)
+}
+
+func (l *FileSuppressionList) Remove(ctx context.Context, address string) error {
+	l.memory.mu.Lock()
+	defer l.memory.mu.Unlock()
+	key := suppressionKey(address)
+	previous, existed := l.memory.entries[key]
+	if !existed {
+		return nil
+	}
+	delete(l.memory.entries, key)
+	if err := l.write(); err != nil {
+		l.memory.entries[key] = previous
+		return err
+	}
+	return nil
+}
+
+func (l *FileSuppressionList) List(ctx context.Context) ([]*Suppression, error) {
+	return l.memory.List(ctx)
+}
+
+// write replaces the file through a rename, so a crash never leaves a partial file behind
+func (l *FileSuppressionList) write() error {
+	data, err := json.MarshalIndent(activeSuppressions(l.memory.entries, l.memory.now()), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.Path), ".tmp-"+filepath.Base(l.Path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.Path)
+}
+
+// SuppressingSender checks the recipients against a SuppressionList before sending. Suppressed recipients are
+// removed from the message, the message fails with ErrRecipientSuppressed when no recipient is left.
+// WithRejectSuppressed fails the whole message instead.
+type SuppressingSender struct {
+	sender SendMail
+	list   SuppressionList
+	reject bool
+}
+
+// SuppressionOption configures a SuppressingSender
+type SuppressionOption func(*SuppressingSender)
+
+// WithRejectSuppressed fails a message with ErrRecipientSuppressed when any of its recipients is suppressed
+func WithRejectSuppressed() SuppressionOption {
+	return func(s *SuppressingSender) {
+		s.reject = true
+	}
+}
+
+func NewSuppressingSender(sender SendMail, list SuppressionList, opts ...SuppressionOption) *SuppressingSender {
+	s := &SuppressingSender{sender: sender, list: list}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *SuppressingSender) SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent string) (*Response, error) {
+	if err := s.check(context.Background(), toEmail); err != nil {
+		return nil, err
+	}
+	return s.sender.SendMail(fromName, fromEmail, toName, toEmail, subject, plainTextContent, htmlContent)
+}
+
+func (s *SuppressingSender) SendMessage(message *Message) (*Response, error) {
+	return s.SendMessageContext(context.Background(), message)
+}
+
+// SendMessageContext passes the context to the suppression list
+func (s *SuppressingSender) SendMessageContext(ctx context.Context, message *Message) (*Response, error) {
+	filtered, err := s.filter(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	return s.sender.SendMessage(filtered)
+}
+
+// SendBatch removes the suppressed recipients of each message, then sends the messages with a single SendBatch of
+// the wrapped sender, which keeps its native batch endpoint
+func (s *SuppressingSender) SendBatch(ctx context.Context, messages []*Message, opts ...BatchOption) ([]BatchResult, error) {
+	results := newBatchResults(messages)
+	prepared := make([]*Message, len(messages))
+	for i, message := range messages {
+		prepared[i], results[i].Err = s.filter(ctx, message)
+	}
+	return results, forwardBatch(ctx, s.sender, prepared, results, opts...)
+}
+
+// filter returns the message without its suppressed recipients, or an error when it must not be sent
+func (s *SuppressingSender) filter(ctx context.Context, message *Message) (*Message, error) {
+	if message == nil {
+		return message, nil
+	}
+
+	var suppressed []error
+	recipients := make([]*Email, 0, len(message.Recipients))
+	for _, recipient := range message.Recipients {
+		if err := s.check(ctx, recipient.Address); err != nil {
+			if !errors.Is(err, ErrRecipientSuppressed) {
+				return nil, err
+			}
+			suppressed = append(suppressed, err)
+			continue
+		}
+		recipients = append(recipients, recipient)
+	}
+	personalizations := make([]*Personalization, 0, len(message.Personalizations))
+	for _, personalization := range message.Personalizations {
+		if personalization.To != nil {
+			if err := s.check(ctx, personalization.To.Address); err != nil {
+				if !errors.Is(err, ErrRecipientSuppressed) {
+					return nil, err
+				}
+				suppressed = append(suppressed, err)
+				continue
+			}
+		}
+		personalizations = append(personalizations, personalization)
+	}
+
+	if len(suppressed) == 0 {
+		return message, nil
+	}
+	if s.reject || len(recipients)+len(personalizations) == 0 {
+		return nil, errors.Join(suppressed...)
+	}
+	filtered := *message
+	filtered.Recipients = recipients
+	filtered.Personalizations = personalizations
+	if len(personalizations) == 0 {
+		filtered.Personalizations = nil
+	}
+	return &filtered, nil
+}
+
+// check returns an error wrapping ErrRecipientSuppressed when address is suppressed
+func (s *SuppressingSender) check(ctx context.Context, address string) error {
+	entry, ok, err := s.list.Lookup(ctx, address)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return fmt.Errorf("%w: %s (%s)", ErrRecipientSuppressed, address, entry.Reason)
+	}
+	return nil
+}
+
+// suppressedEvents maps the delivery events which suppress the recipient
+var suppressedEvents = map[DeliveryEventKind]SuppressionReason{
+	EventHardBounce:    SuppressionHardBounce,
+	EventSpamComplaint: SuppressionComplaint,
+	EventUnsubscribe:   SuppressionUnsubscribe,
+}
+
+// SuppressionCallback returns a webhook callback adding the recipients of hard bounces, spam complaints and
+// unsubscribes to list. The entries expire after ttl, a zero ttl never expires.
+func SuppressionCallback(list SuppressionList, ttl time.Duration) func(ctx context.Context, events []*DeliveryEvent) error {
+	return func(ctx context.Context, events []*DeliveryEvent) error {
+		for _, event := range events {
+			reason, ok := suppressedEvents[event.Kind]
+			if !ok || event.Recipient == "" {
+				continue
+			}
+			entry := &Suppression{
+				Address:   event.Recipient,
+				Reason:    reason,
+				Detail:    event.Reason,
+				CreatedAt: event.Timestamp,
+			}
+			if entry.CreatedAt.IsZero() {
+				entry.CreatedAt = time.Now()
+			}
+			if ttl > 0 {
+				entry.ExpiresAt = entry.CreatedAt.Add(ttl)
+			}
+			if err := list.Suppress(ctx, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
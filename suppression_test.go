@@ -0,0 +1,143 @@
+package sendmail
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemorySuppressionList(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	list := NewMemorySuppressionList()
+	list.now = func() time.Time { return now }
+
+	require.NoError(t, list.Suppress(ctx, &Suppression{Address: " Jane@Example.com", Reason: SuppressionHardBounce}))
+	require.NoError(t, list.Suppress(ctx, &Suppression{Address: "joe@example.com", Reason: SuppressionManual, ExpiresAt: now.Add(time.Hour)}))
+
+	entry, ok, err := list.Lookup(ctx, "JANE@example.com")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, &Suppression{Address: "jane@example.com", Reason: SuppressionHardBounce, CreatedAt: now}, entry)
+
+	entries, err := list.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	now = now.Add(time.Hour)
+	_, ok, err = list.Lookup(ctx, "joe@example.com")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, list.Remove(ctx, "jane@example.com"))
+	entries, err = list.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestFileSuppressionList(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "suppressions.json")
+	list, err := NewFileSuppressionList(path)
+	require.NoError(t, err)
+
+	require.NoError(t, list.Suppress(ctx, &Suppression{Address: "jane@example.com", Reason: SuppressionComplaint, Detail: "abuse report"}))
+	require.NoError(t, list.Suppress(ctx, &Suppression{Address: "joe@example.com", Reason: SuppressionUnsubscribe}))
+	require.NoError(t, list.Remove(ctx, "joe@example.com"))
+
+	reloaded, err := NewFileSuppressionList(path)
+	require.NoError(t, err)
+	entry, ok, err := reloaded.Lookup(ctx, "jane@example.com")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, SuppressionComplaint, entry.Reason)
+	assert.Equal(t, "abuse report", entry.Detail)
+	_, ok, err = reloaded.Lookup(ctx, "joe@example.com")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestSuppressingSender(t *testing.T) {
+	ctx := context.Background()
+	list := NewMemorySuppressionList()
+	require.NoError(t, list.Suppress(ctx, &Suppression{Address: "bounced@example.com", Reason: SuppressionHardBounce}))
+
+	backend := &fakeSender{}
+	send := NewSuppressingSender(backend, list)
+
+	message := testMessage(t)
+	message.Recipients = []*Email{{Address: "jane@example.com"}, {Address: "Bounced@example.com"}}
+	_, err := send.SendMessage(message)
+	require.NoError(t, err)
+	require.Equal(t, 1, backend.count())
+	assert.Equal(t, []*Email{{Address: "jane@example.com"}}, backend.messages[0].Recipients)
+	assert.Len(t, message.Recipients, 2, "the caller's message is not modified")
+
+	message.Recipients = []*Email{{Address: "bounced@example.com"}}
+	_, err = send.SendMessage(message)
+	assert.ErrorIs(t, err, ErrRecipientSuppressed)
+	assert.ErrorContains(t, err, "hard_bounce")
+
+	_, err = send.SendMail("", "from@example.com", "", "bounced@example.com", "subject", "text", "")
+	assert.ErrorIs(t, err, ErrRecipientSuppressed)
+	assert.Equal(t, 1, backend.count())
+
+	reject := NewSuppressingSender(backend, list, WithRejectSuppressed())
+	message.Recipients = []*Email{{Address: "jane@example.com"}, {Address: "bounced@example.com"}}
+	_, err = reject.SendMessage(message)
+	assert.ErrorIs(t, err, ErrRecipientSuppressed)
+	assert.Equal(t, 1, backend.count())
+
+	personalized := personalizedMessage(t)
+	require.NoError(t, list.Suppress(ctx, &Suppression{Address: "joe@example.com", Reason: SuppressionUnsubscribe}))
+	_, err = send.SendMessage(personalized)
+	require.NoError(t, err)
+	require.Len(t, backend.messages[1].Personalizations, 1)
+	assert.Equal(t, "jane@example.com", backend.messages[1].Personalizations[0].To.Address)
+}
+
+func TestSuppressingSender_LookupError(t *testing.T) {
+	send := NewSuppressingSender(&fakeSender{}, failingSuppressionList{})
+	_, err := send.SendMessage(testMessage(t))
+	assert.EqualError(t, err, "lookup failed")
+}
+
+type failingSuppressionList struct {
+	SuppressionList
+}
+
+func (failingSuppressionList) Lookup(context.Context, string) (*Suppression, bool, error) {
+	return nil, false, errors.New("lookup failed")
+}
+
+func TestSuppressionCallback(t *testing.T) {
+	ctx := context.Background()
+	list := NewMemorySuppressionList()
+	bounced := time.Now().Add(-time.Minute).UTC()
+	callback := SuppressionCallback(list, 30*24*time.Hour)
+
+	err := callback(ctx, []*DeliveryEvent{
+		{Provider: "sendgrid", Kind: EventHardBounce, Recipient: "jane@example.com", Timestamp: bounced, Reason: "550 unknown user"},
+		{Provider: "sendgrid", Kind: EventSoftBounce, Recipient: "joe@example.com"},
+		{Provider: "sendgrid", Kind: EventSpamComplaint, Recipient: "jim@example.com"},
+		{Provider: "sendgrid", Kind: EventDelivered, Recipient: "ann@example.com"},
+	})
+	require.NoError(t, err)
+
+	entries, err := list.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, &Suppression{
+		Address:   "jane@example.com",
+		Reason:    SuppressionHardBounce,
+		Detail:    "550 unknown user",
+		CreatedAt: bounced,
+		ExpiresAt: bounced.Add(30 * 24 * time.Hour),
+	}, entries[0])
+	assert.Equal(t, SuppressionComplaint, entries[1].Reason)
+}
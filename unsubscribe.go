@@ -0,0 +1,257 @@
+package sendmail
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrInvalidUnsubscribe = errors.New("sendmail: invalid unsubscribe target")
+var ErrInvalidUnsubscribeToken = errors.New("sendmail: invalid unsubscribe token")
+
+// Unsubscribe holds the targets of the List-Unsubscribe header, RFC 2369. Mailto is an address, optionally with a
+// ?subject= query. An HTTPS URL also enables one-click unsubscribe, RFC 8058, which Gmail and Yahoo require from
+// bulk senders: List-Unsubscribe-Post is added and the client POSTs "List-Unsubscribe=One-Click" to the URL.
+type Unsubscribe struct {
+	Mailto string `json:"mailto,omitempty"`
+	URL    string `json:"url,omitempty"`
+}
+
+// messageHeader is a header added to the sent message, in the order written
+type messageHeader struct {
+	name  string
+	value string
+}
+
+// headers returns the List-Unsubscribe headers, none for a nil Unsubscribe
+func (u *Unsubscribe) headers() []messageHeader {
+	if u == nil || (u.Mailto == "" && u.URL == "") {
+		return nil
+	}
+	var targets []string
+	if u.Mailto != "" {
+		targets = append(targets, "<mailto:"+strings.TrimPrefix(u.Mailto, "mailto:")+">")
+	}
+	if u.URL != "" {
+		targets = append(targets, "<"+u.URL+">")
+	}
+	headers := []messageHeader{{name: "List-Unsubscribe", value: strings.Join(targets, ", ")}}
+	if u.URL != "" {
+		headers = append(headers, messageHeader{name: "List-Unsubscribe-Post", value: "List-Unsubscribe=One-Click"})
+	}
+	return headers
+}
+
+func (u *Unsubscribe) validate() error {
+	if u == nil {
+		return nil
+	}
+	// the targets are written into the List-Unsubscribe header as is, they must not be able to end the header or
+	// the angle bracket list
+	for _, target := range []string{u.Mailto, u.URL} {
+		unsafe := func(r rune) bool { return r < ' ' || r == 0x7f || strings.ContainsRune("<>,", r) }
+		if strings.ContainsFunc(target, unsafe) {
+			return fmt.Errorf("%w: %q contains control characters, angle brackets or commas", ErrInvalidUnsubscribe, target)
+		}
+	}
+	if u.Mailto != "" {
+		address, _, _ := strings.Cut(strings.TrimPrefix(u.Mailto, "mailto:"), "?")
+		if _, err := NormalizeAddress(address); err != nil {
+			return fmt.Errorf("%w: mailto %q", ErrInvalidUnsubscribe, u.Mailto)
+		}
+	}
+	if u.URL != "" {
+		if parsed, err := url.Parse(u.URL); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return fmt.Errorf("%w: url %q is not an https URL", ErrInvalidUnsubscribe, u.URL)
+		}
+	}
+	return nil
+}
+
+// unsubscribeFor returns the targets of a personalization, falling back to the ones of the message
+func (m *Message) unsubscribeFor(personalization *Personalization) *Unsubscribe {
+	if personalization.Unsubscribe != nil {
+		return personalization.Unsubscribe
+	}
+	return m.Unsubscribe
+}
+
+// Unsubscriber creates signed per-recipient unsubscribe links and handles them, adding the addresses which
+// unsubscribe to a SuppressionList. A GET shows a confirmation page, so that link scanners opening the URL do not
+// unsubscribe anyone, and a POST, one-click or from the page, unsubscribes.
+//
+//	unsubscriber, err := sendmail.NewUnsubscriber("https://example.com/unsubscribe", secret, list)
+//	http.Handle("/unsubscribe", unsubscriber)
+//	err = unsubscriber.Apply(message)
+type Unsubscriber struct {
+	url    string
+	mailto string
+	secret []byte
+	list   SuppressionList
+	logger func(string, ...interface{})
+}
+
+// UnsubscribeOption configures an Unsubscriber
+type UnsubscribeOption func(*Unsubscriber)
+
+// WithUnsubscribeMailto adds a mailto target, the token of the recipient is passed in the subject, see Verify
+func WithUnsubscribeMailto(address string) UnsubscribeOption {
+	return func(u *Unsubscriber) {
+		u.mailto = address
+	}
+}
+
+// WithUnsubscribeLogger overrides the default log.Printf logging of unsubscribes and rejected requests
+func WithUnsubscribeLogger(logger func(string, ...interface{})) UnsubscribeOption {
+	return func(u *Unsubscriber) {
+		u.logger = logger
+	}
+}
+
+// NewUnsubscriber creates an Unsubscriber for the https URL it is served at. The secret signs the tokens, changing
+// it invalidates the links already sent.
+func NewUnsubscriber(baseURL string, secret []byte, list SuppressionList, opts ...UnsubscribeOption) (*Unsubscriber, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("%w: missing secret", ErrInvalidUnsubscribe)
+	}
+	u := &Unsubscriber{url: baseURL, secret: secret, list: list}
+	for _, opt := range opts {
+		opt(u)
+	}
+	if err := (&Unsubscribe{Mailto: u.mailto, URL: u.url}).validate(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Token signs address, the token is URL safe
+func (u *Unsubscriber) Token(address string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(address)) + "." + base64.RawURLEncoding.EncodeToString(u.sign(address))
+}
+
+func (u *Unsubscriber) sign(address string) []byte {
+	mac := hmac.New(sha256.New, u.secret)
+	mac.Write([]byte(suppressionKey(address)))
+	return mac.Sum(nil)[:16]
+}
+
+// Verify returns the address of a token, or an error wrapping ErrInvalidUnsubscribeToken
+func (u *Unsubscriber) Verify(token string) (string, error) {
+	encoded, signature, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	address, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidUnsubscribeToken, err)
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidUnsubscribeToken, err)
+	}
+	if !hmac.Equal(mac, u.sign(string(address))) {
+		return "", ErrInvalidUnsubscribeToken
+	}
+	return string(address), nil
+}
+
+// For returns the unsubscribe targets of address
+func (u *Unsubscriber) For(address string) *Unsubscribe {
+	token := u.Token(address)
+	separator := "?"
+	if strings.Contains(u.url, "?") {
+		separator = "&"
+	}
+	unsubscribe := &Unsubscribe{URL: u.url + separator + "token=" + token}
+	if u.mailto != "" {
+		unsubscribe.Mailto = u.mailto + "?subject=" + url.PathEscape("unsubscribe "+token)
+	}
+	return unsubscribe
+}
+
+// Apply sets the unsubscribe targets of each personalization, or of a message with a single recipient. The link
+// is also available to the content as the {{unsubscribe_url}} placeholder. A message sent to several recipients at
+// once fails with ErrInvalidUnsubscribe, since the links are per recipient.
+func (u *Unsubscriber) Apply(message *Message) error {
+	if len(message.Personalizations) > 0 {
+		for _, personalization := range message.Personalizations {
+			personalization.Unsubscribe = u.For(personalization.To.Address)
+			if personalization.Variables == nil {
+				personalization.Variables = map[string]string{}
+			}
+			personalization.Variables["unsubscribe_url"] = personalization.Unsubscribe.URL
+		}
+		return nil
+	}
+	if len(message.Recipients) != 1 {
+		return fmt.Errorf("%w: per-recipient links need a single recipient or personalizations", ErrInvalidUnsubscribe)
+	}
+	message.Unsubscribe = u.For(message.Recipients[0].Address)
+	variables := map[string]string{"unsubscribe_url": message.Unsubscribe.URL}
+	message.PlainTextContent = substitute(message.PlainTextContent, variables)
+	message.HtmlContent = substituteHTML(message.HtmlContent, variables)
+	return nil
+}
+
+// maxUnsubscribeBody limits the form read from a POST, one-click requests only carry List-Unsubscribe=One-Click
+const maxUnsubscribeBody = 64 << 10
+
+func (u *Unsubscriber) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	address, err := u.Verify(r.URL.Query().Get("token"))
+	if err != nil {
+		u.logf("Rejected unsubscribe request: %v", err)
+		http.Error(w, "invalid unsubscribe link", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<!DOCTYPE html><html><body><form method="post"><p>Unsubscribe %s?</p><button type="submit">Unsubscribe</button></form></body></html>`,
+			html.EscapeString(address))
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxUnsubscribeBody)
+		detail := "unsubscribe page"
+		if err := r.ParseForm(); err == nil && r.PostForm.Get("List-Unsubscribe") == "One-Click" {
+			detail = "one-click"
+		}
+		if err := u.unsubscribe(r.Context(), address, detail); err != nil {
+			u.logf("Failed to unsubscribe %s: %v", address, err)
+			http.Error(w, "unsubscribe failed", http.StatusInternalServerError)
+			return
+		}
+		u.logf("Unsubscribed %s (%s)", address, detail)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "%s has been unsubscribed\n", address)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (u *Unsubscriber) unsubscribe(ctx context.Context, address, detail string) error {
+	return u.list.Suppress(ctx, &Suppression{
+		Address:   address,
+		Reason:    SuppressionUnsubscribe,
+		Detail:    detail,
+		CreatedAt: time.Now(),
+	})
+}
+
+// logf logs message either via defined user logger or via system one if no user logger is defined.
+func (u *Unsubscriber) logf(f string, args ...interface{}) {
+	if u.logger != nil {
+		u.logger(f, args...)
+	} else {
+		log.Printf(f, args...)
+	}
+}
@@ -0,0 +1,197 @@
+package sendmail
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnsubscribe_Headers(t *testing.T) {
+	var unsubscribe *Unsubscribe
+	assert.Nil(t, unsubscribe.headers())
+
+	unsubscribe = &Unsubscribe{Mailto: "unsubscribe@example.com", URL: "https://example.com/u?token=abc"}
+	assert.Equal(t, []messageHeader{
+		{name: "List-Unsubscribe", value: "<mailto:unsubscribe@example.com>, <https://example.com/u?token=abc>"},
+		{name: "List-Unsubscribe-Post", value: "List-Unsubscribe=One-Click"},
+	}, unsubscribe.headers())
+
+	unsubscribe = &Unsubscribe{Mailto: "mailto:unsubscribe@example.com?subject=stop"}
+	assert.Equal(t, []messageHeader{{name: "List-Unsubscribe", value: "<mailto:unsubscribe@example.com?subject=stop>"}}, unsubscribe.headers())
+}
+
+func TestMessage_ValidateUnsubscribe(t *testing.T) {
+	message := testMessage(t)
+	message.Unsubscribe = &Unsubscribe{URL: "http://example.com/unsubscribe"}
+	assert.ErrorIs(t, message.Validate(), ErrInvalidUnsubscribe)
+
+	message.Unsubscribe = &Unsubscribe{Mailto: "not an address"}
+	assert.ErrorIs(t, message.Validate(), ErrInvalidUnsubscribe)
+
+	message.Unsubscribe = &Unsubscribe{Mailto: "unsubscribe@example.com?subject=stop", URL: "https://example.com/unsubscribe"}
+	assert.NoError(t, message.Validate())
+
+	for _, injected := range []*Unsubscribe{
+		{Mailto: "unsubscribe@example.com?subject=stop\r\nBcc: victim@example.com"},
+		{Mailto: "unsubscribe@example.com?subject=a>, <https://evil.example"},
+		{URL: "https://example.com/u?token=abc\nX-Injected: 1"},
+		{URL: "https://example.com/u>, <https://evil.example/u"},
+		{URL: "https://example.com/u?a=1,2"},
+	} {
+		message.Unsubscribe = injected
+		assert.ErrorIs(t, message.Validate(), ErrInvalidUnsubscribe, "%+v", injected)
+	}
+
+	_, err := NewEmailMessage().
+		FromEmail("Shop", "shop@example.com").
+		AddRecipient("Jane", "jane@example.com").
+		Subject("Hello").
+		Unsubscribe("", "ftp://example.com").
+		Build()
+	assert.ErrorIs(t, err, ErrInvalidUnsubscribe)
+}
+
+func TestUnsubscriber_Token(t *testing.T) {
+	unsubscriber, err := NewUnsubscriber("https://example.com/unsubscribe", []byte("secret"), NewMemorySuppressionList())
+	require.NoError(t, err)
+
+	token := unsubscriber.Token("Jane@example.com")
+	address, err := unsubscriber.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, "Jane@example.com", address)
+
+	other, err := NewUnsubscriber("https://example.com/unsubscribe", []byte("other"), NewMemorySuppressionList())
+	require.NoError(t, err)
+	_, err = other.Verify(token)
+	assert.ErrorIs(t, err, ErrInvalidUnsubscribeToken)
+
+	// the address can't be changed without the signature
+	_, signature, _ := strings.Cut(token, ".")
+	_, err = unsubscriber.Verify(strings.Split(unsubscriber.Token("joe@example.com"), ".")[0] + "." + signature)
+	assert.ErrorIs(t, err, ErrInvalidUnsubscribeToken)
+	_, err = unsubscriber.Verify("garbage")
+	assert.ErrorIs(t, err, ErrInvalidUnsubscribeToken)
+
+	_, err = NewUnsubscriber("http://example.com/unsubscribe", []byte("secret"), NewMemorySuppressionList())
+	assert.ErrorIs(t, err, ErrInvalidUnsubscribe)
+	_, err = NewUnsubscriber("https://example.com/unsubscribe", nil, NewMemorySuppressionList())
+	assert.ErrorIs(t, err, ErrInvalidUnsubscribe)
+}
+
+func TestUnsubscriber_Apply(t *testing.T) {
+	unsubscriber, err := NewUnsubscriber("https://example.com/unsubscribe?list=news", []byte("secret"), NewMemorySuppressionList(),
+		WithUnsubscribeMailto("unsubscribe@example.com"))
+	require.NoError(t, err)
+
+	message := personalizedMessage(t)
+	message.HtmlContent = `<a href="{{unsubscribe_url}}">Unsubscribe</a>`
+	require.NoError(t, unsubscriber.Apply(message))
+	require.NoError(t, message.Validate())
+
+	messages := Personalize(message)
+	janeURL := "https://example.com/unsubscribe?list=news&token=" + unsubscriber.Token("jane@example.com")
+	assert.Equal(t, &Unsubscribe{
+		Mailto: "unsubscribe@example.com?subject=unsubscribe%20" + unsubscriber.Token("jane@example.com"),
+		URL:    janeURL,
+	}, messages[0].Unsubscribe)
+	assert.Equal(t, `<a href="`+strings.ReplaceAll(janeURL, "&", "&amp;")+`">Unsubscribe</a>`, messages[0].HtmlContent)
+	assert.NotEqual(t, messages[0].Unsubscribe.URL, messages[1].Unsubscribe.URL)
+
+	single := testMessage(t)
+	single.PlainTextContent = "Unsubscribe: {{unsubscribe_url}}"
+	require.NoError(t, unsubscriber.Apply(single))
+	assert.Equal(t, "Unsubscribe: "+single.Unsubscribe.URL, single.PlainTextContent)
+
+	single.Recipients = append(single.Recipients, &Email{Address: "joe@example.com"})
+	assert.ErrorIs(t, unsubscriber.Apply(single), ErrInvalidUnsubscribe)
+}
+
+func TestUnsubscriber_ServeHTTP(t *testing.T) {
+	ctx := context.Background()
+	list := NewMemorySuppressionList()
+	unsubscriber, err := NewUnsubscriber("https://example.com/unsubscribe", []byte("secret"), list)
+	require.NoError(t, err)
+	target := "/unsubscribe?token=" + unsubscriber.Token("jane@example.com")
+
+	// opening the link only shows the confirmation
+	recorder := httptest.NewRecorder()
+	unsubscriber.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `<form method="post">`)
+	_, suppressed, err := list.Lookup(ctx, "jane@example.com")
+	require.NoError(t, err)
+	assert.False(t, suppressed)
+
+	request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(url.Values{"List-Unsubscribe": {"One-Click"}}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	unsubscriber.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	entry, suppressed, err := list.Lookup(ctx, "jane@example.com")
+	require.NoError(t, err)
+	require.True(t, suppressed)
+	assert.Equal(t, SuppressionUnsubscribe, entry.Reason)
+	assert.Equal(t, "one-click", entry.Detail)
+	assert.WithinDuration(t, time.Now(), entry.CreatedAt, time.Minute)
+
+	recorder = httptest.NewRecorder()
+	unsubscriber.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/unsubscribe?token=forged", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	unsubscriber.ServeHTTP(recorder, httptest.NewRequest(http.MethodDelete, target, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestUnsubscribe_ProviderHeaders(t *testing.T) {
+	unsubscribe := &Unsubscribe{Mailto: "unsubscribe@example.com", URL: "https://example.com/u?token=abc"}
+	listUnsubscribe := "<mailto:unsubscribe@example.com>, <https://example.com/u?token=abc>"
+	quiet := WithLogger(func(string, ...interface{}) {})
+
+	message := testMessage(t)
+	message.Unsubscribe = unsubscribe
+	raw, err := renderMIME(message, "id@example.com", time.Now())
+	require.NoError(t, err)
+	parsed, err := parseMIME(raw)
+	require.NoError(t, err)
+	assert.Equal(t, listUnsubscribe, parsed.Header.Get("List-Unsubscribe"))
+	assert.Equal(t, "List-Unsubscribe=One-Click", parsed.Header.Get("List-Unsubscribe-Post"))
+
+	server := newRecordingServer(t, "")
+	sendGrid, err := NewSendGrid("key", WithBaseURL(server.URL), quiet)
+	require.NoError(t, err)
+	personalized := personalizedMessage(t)
+	personalized.Personalizations[1].Unsubscribe = unsubscribe
+	_, err = sendGrid.SendMessage(personalized)
+	require.NoError(t, err)
+	personalizations := server.body["personalizations"].([]interface{})
+	assert.Nil(t, personalizations[0].(map[string]interface{})["headers"])
+	assert.Equal(t, map[string]interface{}{
+		"List-Unsubscribe":      listUnsubscribe,
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}, personalizations[1].(map[string]interface{})["headers"])
+
+	mailTrap, err := NewMailTrap("token", WithBaseURL(server.URL), quiet)
+	require.NoError(t, err)
+	_, err = mailTrap.SendMessage(message)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"List-Unsubscribe":      listUnsubscribe,
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}, server.body["headers"])
+
+	mailerSend, err := NewMailerSend("token", WithBaseURL(server.URL), quiet)
+	require.NoError(t, err)
+	_, err = mailerSend.SendMessage(message)
+	require.NoError(t, err)
+	assert.Equal(t, listUnsubscribe, server.body["list_unsubscribe"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"name": "List-Unsubscribe-Post", "value": "List-Unsubscribe=One-Click"}}, server.body["headers"])
+}
@@ -0,0 +1,544 @@
+// Delivery event webhooks. Each provider posts delivery, bounce, open, click and complaint events in its own
+// shape, they are parsed into DeliveryEvent so bounce processing does not depend on the provider.
+package sendmail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeliveryEventKind is the provider-independent kind of a delivery event
+type DeliveryEventKind string
+
+const (
+	// EventProcessed the provider accepted the message for delivery
+	EventProcessed DeliveryEventKind = "processed"
+	// EventDelivered the receiving server accepted the message
+	EventDelivered DeliveryEventKind = "delivered"
+	// EventDeferred delivery is delayed and will be retried by the provider
+	EventDeferred DeliveryEventKind = "deferred"
+	// EventSoftBounce delivery failed temporarily, e.g. a full mailbox
+	EventSoftBounce DeliveryEventKind = "soft_bounce"
+	// EventHardBounce delivery failed permanently, e.g. an unknown mailbox
+	EventHardBounce DeliveryEventKind = "hard_bounce"
+	// EventDropped the provider did not attempt delivery, e.g. a suppressed recipient
+	EventDropped DeliveryEventKind = "dropped"
+	// EventOpen the recipient opened the message
+	EventOpen DeliveryEventKind = "open"
+	// EventClick the recipient clicked a link
+	EventClick DeliveryEventKind = "click"
+	// EventSpamComplaint the recipient marked the message as spam
+	EventSpamComplaint DeliveryEventKind = "spam_complaint"
+	// EventUnsubscribe the recipient unsubscribed
+	EventUnsubscribe DeliveryEventKind = "unsubscribe"
+	// EventUnknown an event without a provider-independent equivalent, see Metadata["event"]
+	EventUnknown DeliveryEventKind = "unknown"
+)
+
+// DeliveryEvent is a delivery event posted by a provider webhook. MessageID matches the X-Message-Id header of
+// the Response returned when the message was sent. Metadata holds the remaining provider fields, including the
+// provider's own event name under "event".
+type DeliveryEvent struct {
+	Provider  string
+	Kind      DeliveryEventKind
+	Recipient string
+	MessageID string
+	Timestamp time.Time
+	Reason    string
+	Metadata  map[string]string
+}
+
+var ErrUnknownWebhookProvider = errors.New("sendmail: unknown webhook provider")
+
+// EventParser parses a webhook request body into delivery events
+type EventParser func(body []byte) ([]*DeliveryEvent, error)
+
+var eventParsers = map[string]EventParser{
+	"sendgrid":   parseSendGridEvents,
+	"mailjet":    parseMailJetEvents,
+	"mailersend": parseMailerSendEvents,
+	"mailtrap":   parseMailTrapEvents,
+	"smtp2go":    parseSmtp2goEvents,
+}
+
+// ParseDeliveryEvents parses the webhook body posted by a provider, named as in Open: sendgrid, mailjet,
+// mailersend, mailtrap or smtp2go
+func ParseDeliveryEvents(provider string, body []byte) ([]*DeliveryEvent, error) {
+	parse, ok := eventParsers[provider]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownWebhookProvider, provider)
+	}
+	return parse(body)
+}
+
+// WebhookHandler is an http.Handler receiving the delivery events of a provider webhook. The parsed events are
+// passed to the callback, a callback error is answered with a 500 so the provider retries the delivery.
+type WebhookHandler struct {
+	webhookEndpoint
+	parse    EventParser
+	callback func(ctx context.Context, events []*DeliveryEvent) error
+}
+
+// webhookEndpoint holds the request handling shared by the webhook handlers
+type webhookEndpoint struct {
+	provider string
+	maxBody  int64
+	verifier WebhookVerifier
+	logger   func(string, ...interface{})
+}
+
+// WebhookOption configures a WebhookHandler or InboundHandler
+type WebhookOption func(*webhookEndpoint)
+
+// WithWebhookBodyLimit sets the largest accepted request body, default 10 MB
+func WithWebhookBodyLimit(limit int64) WebhookOption {
+	return func(e *webhookEndpoint) {
+		e.maxBody = limit
+	}
+}
+
+// WithWebhookLogger sets the logger used for rejected requests
+func WithWebhookLogger(logger func(string, ...interface{})) WebhookOption {
+	return func(e *webhookEndpoint) {
+		e.logger = logger
+	}
+}
+
+func newWebhookEndpoint(provider string, opts []WebhookOption) webhookEndpoint {
+	e := webhookEndpoint{provider: provider, maxBody: 10 << 20}
+	for _, opt := range opts {
+		opt(&e)
+	}
+	return e
+}
+
+// NewWebhookHandler creates the handler for the webhook of the given provider
+func NewWebhookHandler(provider string, callback func(ctx context.Context, events []*DeliveryEvent) error, opts ...WebhookOption) (*WebhookHandler, error) {
+	parse, ok := eventParsers[provider]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownWebhookProvider, provider)
+	}
+	if callback == nil {
+		return nil, errors.New("sendmail: webhook callback is required")
+	}
+	return &WebhookHandler{
+		webhookEndpoint: newWebhookEndpoint(provider, opts),
+		parse:           parse,
+		callback:        callback,
+	}, nil
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, ok := h.read(w, r)
+	if !ok {
+		return
+	}
+	events, err := h.parse(body)
+	if err != nil {
+		h.reject(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.callback(r.Context(), events); err != nil {
+		h.forget(r)
+		h.reject(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// read returns the body of a verified POST request, otherwise the request is answered and false returned
+func (e *webhookEndpoint) read(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, e.maxBody))
+	if err != nil {
+		e.reject(w, http.StatusRequestEntityTooLarge, err)
+		return nil, false
+	}
+	if e.verifier != nil {
+		err := e.verifier.Verify(r, body)
+		if errors.Is(err, ErrDuplicateWebhook) {
+			// the provider retries a request it did not see answered, it was handled already
+			e.logf("Webhook %s acknowledged: %v", e.provider, err)
+			w.WriteHeader(http.StatusOK)
+			return nil, false
+		}
+		if err != nil {
+			e.reject(w, http.StatusUnauthorized, err)
+			return nil, false
+		}
+	}
+	return body, true
+}
+
+// forget lets the verifier accept the request again, after its events could not be handled, see HMACVerifier
+func (e *webhookEndpoint) forget(r *http.Request) {
+	if verifier, ok := e.verifier.(interface{ forget(*http.Request) }); ok {
+		verifier.forget(r)
+	}
+}
+
+func (e *webhookEndpoint) reject(w http.ResponseWriter, status int, err error) {
+	e.logf("Webhook %s rejected: status_code=%d, error=%v", e.provider, status, err)
+	http.Error(w, http.StatusText(status), status)
+}
+
+func (e *webhookEndpoint) logf(f string, args ...interface{}) {
+	if e.logger != nil {
+		e.logger(f, args...)
+	}
+}
+
+// sendGridEvents maps the SendGrid Event Webhook event names
+var sendGridEvents = map[string]DeliveryEventKind{
+	"processed":         EventProcessed,
+	"delivered":         EventDelivered,
+	"deferred":          EventDeferred,
+	"bounce":            EventHardBounce,
+	"dropped":           EventDropped,
+	"open":              EventOpen,
+	"click":             EventClick,
+	"spamreport":        EventSpamComplaint,
+	"unsubscribe":       EventUnsubscribe,
+	"group_unsubscribe": EventUnsubscribe,
+}
+
+// parseSendGridEvents parses the SendGrid Event Webhook, a JSON array of events
+// https://www.twilio.com/docs/sendgrid/for-developers/tracking-events/event
+func parseSendGridEvents(body []byte) ([]*DeliveryEvent, error) {
+	var raw []map[string]interface{}
+	if err := decodeEvents(body, &raw); err != nil {
+		return nil, fmt.Errorf("SendGrid events: %w", err)
+	}
+
+	events := make([]*DeliveryEvent, 0, len(raw))
+	for _, fields := range raw {
+		name := stringField(fields, "event")
+		kind := eventKind(sendGridEvents, name)
+		// a blocked bounce is a temporary rejection by the receiving server
+		if kind == EventHardBounce && stringField(fields, "type") == "blocked" {
+			kind = EventSoftBounce
+		}
+		reason := stringField(fields, "reason")
+		if reason == "" {
+			reason = stringField(fields, "response")
+		}
+		// sg_message_id is the X-Message-Id of the send response followed by a filter suffix
+		messageID, _, _ := strings.Cut(stringField(fields, "sg_message_id"), ".")
+		events = append(events, &DeliveryEvent{
+			Provider:  "sendgrid",
+			Kind:      kind,
+			Recipient: stringField(fields, "email"),
+			MessageID: messageID,
+			Timestamp: timeField(fields, "timestamp"),
+			Reason:    reason,
+			Metadata:  eventMetadata(fields, "email", "sg_message_id", "timestamp", "reason"),
+		})
+	}
+	return events, nil
+}
+
+// mailJetEvents maps the MailJet event callback names
+var mailJetEvents = map[string]DeliveryEventKind{
+	"sent":    EventDelivered,
+	"bounce":  EventSoftBounce,
+	"blocked": EventDropped,
+	"open":    EventOpen,
+	"click":   EventClick,
+	"spam":    EventSpamComplaint,
+	"unsub":   EventUnsubscribe,
+}
+
+// parseMailJetEvents parses a MailJet event callback, a single event or an array when grouping is enabled
+// https://dev.mailjet.com/email/guides/webhooks/
+func parseMailJetEvents(body []byte) ([]*DeliveryEvent, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '{' {
+		body = append(append([]byte{'['}, body...), ']')
+	}
+	var raw []map[string]interface{}
+	if err := decodeEvents(body, &raw); err != nil {
+		return nil, fmt.Errorf("MailJet events: %w", err)
+	}
+
+	events := make([]*DeliveryEvent, 0, len(raw))
+	for _, fields := range raw {
+		kind := eventKind(mailJetEvents, stringField(fields, "event"))
+		if kind == EventSoftBounce && fields["hard_bounce"] == true {
+			kind = EventHardBounce
+		}
+		reason := stringField(fields, "error")
+		if related := stringField(fields, "error_related_to"); related != "" && reason != "" {
+			reason = related + ": " + reason
+		}
+		// Message_GUID is the MessageUUID returned by the Send API
+		messageID := stringField(fields, "Message_GUID")
+		if messageID == "" {
+			messageID = stringField(fields, "MessageID")
+		}
+		events = append(events, &DeliveryEvent{
+			Provider:  "mailjet",
+			Kind:      kind,
+			Recipient: stringField(fields, "email"),
+			MessageID: messageID,
+			Timestamp: timeField(fields, "time"),
+			Reason:    reason,
+			Metadata:  eventMetadata(fields, "email", "Message_GUID", "time", "error", "error_related_to"),
+		})
+	}
+	return events, nil
+}
+
+// mailerSendEvents maps the MailerSend webhook event types
+var mailerSendEvents = map[string]DeliveryEventKind{
+	"activity.sent":           EventProcessed,
+	"activity.delivered":      EventDelivered,
+	"activity.soft_bounced":   EventSoftBounce,
+	"activity.hard_bounced":   EventHardBounce,
+	"activity.opened":         EventOpen,
+	"activity.opened_unique":  EventOpen,
+	"activity.clicked":        EventClick,
+	"activity.clicked_unique": EventClick,
+	"activity.unsubscribed":   EventUnsubscribe,
+	"activity.spam_complaint": EventSpamComplaint,
+}
+
+// mailerSendWebhook is the MailerSend activity webhook payload
+// https://developers.mailersend.com/api/v1/webhooks.html
+type mailerSendWebhook struct {
+	Type      string `json:"type"`
+	WebhookID string `json:"webhook_id"`
+	CreatedAt string `json:"created_at"`
+	Data      struct {
+		ID        string `json:"id"`
+		CreatedAt string `json:"created_at"`
+		Email     struct {
+			Subject string   `json:"subject"`
+			Tags    []string `json:"tags"`
+			Message struct {
+				ID string `json:"id"`
+			} `json:"message"`
+			Recipient struct {
+				Email string `json:"email"`
+			} `json:"recipient"`
+		} `json:"email"`
+		Morph *struct {
+			Object         string `json:"object"`
+			URL            string `json:"url"`
+			IP             string `json:"ip"`
+			Reason         string `json:"reason"`
+			ReadableReason string `json:"readable_reason"`
+		} `json:"morph"`
+	} `json:"data"`
+}
+
+// parseMailerSendEvents parses a MailerSend activity webhook, one event per request
+func parseMailerSendEvents(body []byte) ([]*DeliveryEvent, error) {
+	payload := mailerSendWebhook{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("MailerSend events: %w", err)
+	}
+
+	metadata := map[string]string{"event": payload.Type}
+	setMetadata(metadata, "activity_id", payload.Data.ID)
+	setMetadata(metadata, "webhook_id", payload.WebhookID)
+	setMetadata(metadata, "subject", payload.Data.Email.Subject)
+	setMetadata(metadata, "tags", strings.Join(payload.Data.Email.Tags, ","))
+	reason := ""
+	if morph := payload.Data.Morph; morph != nil {
+		setMetadata(metadata, "url", morph.URL)
+		setMetadata(metadata, "ip", morph.IP)
+		reason = morph.ReadableReason
+		if reason == "" {
+			reason = morph.Reason
+		}
+	}
+
+	created := payload.Data.CreatedAt
+	if created == "" {
+		created = payload.CreatedAt
+	}
+	return []*DeliveryEvent{{
+		Provider:  "mailersend",
+		Kind:      eventKind(mailerSendEvents, payload.Type),
+		Recipient: payload.Data.Email.Recipient.Email,
+		MessageID: payload.Data.Email.Message.ID,
+		Timestamp: parseEventTime(created),
+		Reason:    reason,
+		Metadata:  metadata,
+	}}, nil
+}
+
+// mailTrapEvents maps the Mailtrap webhook event names
+var mailTrapEvents = map[string]DeliveryEventKind{
+	"delivery":    EventDelivered,
+	"soft bounce": EventSoftBounce,
+	"bounce":      EventHardBounce,
+	"reject":      EventDropped,
+	"suspension":  EventDropped,
+	"open":        EventOpen,
+	"click":       EventClick,
+	"spam":        EventSpamComplaint,
+	"unsubscribe": EventUnsubscribe,
+}
+
+// parseMailTrapEvents parses a Mailtrap webhook, events are batched under "events"
+// https://api-docs.mailtrap.io/docs/mailtrap-api-docs/016fe2a1efd5a-receive-events-json-format
+func parseMailTrapEvents(body []byte) ([]*DeliveryEvent, error) {
+	payload := struct {
+		Events []map[string]interface{} `json:"events"`
+	}{}
+	if err := decodeEvents(body, &payload); err != nil {
+		return nil, fmt.Errorf("Mailtrap events: %w", err)
+	}
+
+	events := make([]*DeliveryEvent, 0, len(payload.Events))
+	for _, fields := range payload.Events {
+		reason := stringField(fields, "response")
+		if reason == "" {
+			reason = stringField(fields, "reason")
+		}
+		events = append(events, &DeliveryEvent{
+			Provider:  "mailtrap",
+			Kind:      eventKind(mailTrapEvents, stringField(fields, "event")),
+			Recipient: stringField(fields, "email"),
+			MessageID: stringField(fields, "message_id"),
+			Timestamp: timeField(fields, "timestamp"),
+			Reason:    reason,
+			Metadata:  eventMetadata(fields, "email", "message_id", "timestamp", "response", "reason"),
+		})
+	}
+	return events, nil
+}
+
+// smtp2goEvents maps the Smtp2go webhook event names
+var smtp2goEvents = map[string]DeliveryEventKind{
+	"processed":   EventProcessed,
+	"delivered":   EventDelivered,
+	"bounce":      EventHardBounce,
+	"reject":      EventDropped,
+	"rejected":    EventDropped,
+	"open":        EventOpen,
+	"click":       EventClick,
+	"spam":        EventSpamComplaint,
+	"unsubscribe": EventUnsubscribe,
+}
+
+// parseSmtp2goEvents parses a Smtp2go webhook, posted either as JSON or as a form, one event per request
+// https://support.smtp2go.com/hc/en-gb/articles/360001323574-Webhooks
+func parseSmtp2goEvents(body []byte) ([]*DeliveryEvent, error) {
+	fields := map[string]interface{}{}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := decodeEvents(trimmed, &fields); err != nil {
+			return nil, fmt.Errorf("Smtp2go events: %w", err)
+		}
+	} else {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("Smtp2go events: %w", err)
+		}
+		for key := range form {
+			fields[key] = form.Get(key)
+		}
+	}
+
+	kind := eventKind(smtp2goEvents, stringField(fields, "event"))
+	if kind == EventHardBounce && stringField(fields, "bounce") == "soft" {
+		kind = EventSoftBounce
+	}
+	reason := ""
+	if kind == EventHardBounce || kind == EventSoftBounce || kind == EventDropped {
+		reason = stringField(fields, "message")
+		if reason == "" {
+			reason = stringField(fields, "context")
+		}
+	}
+	return []*DeliveryEvent{{
+		Provider:  "smtp2go",
+		Kind:      kind,
+		Recipient: stringField(fields, "rcpt"),
+		MessageID: stringField(fields, "email_id"),
+		Timestamp: timeField(fields, "time"),
+		Reason:    reason,
+		Metadata:  eventMetadata(fields, "rcpt", "email_id", "time"),
+	}}, nil
+}
+
+func eventKind(kinds map[string]DeliveryEventKind, name string) DeliveryEventKind {
+	if kind, ok := kinds[name]; ok {
+		return kind
+	}
+	return EventUnknown
+}
+
+// decodeEvents decodes JSON keeping numbers as json.Number, message ids can exceed the float64 precision
+func decodeEvents(body []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// stringField returns a JSON field as a string
+func stringField(fields map[string]interface{}, key string) string {
+	switch value := fields[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}
+}
+
+func timeField(fields map[string]interface{}, key string) time.Time {
+	return parseEventTime(stringField(fields, key))
+}
+
+// parseEventTime parses a unix timestamp or a RFC 3339 time, a zero time is returned when neither matches
+func parseEventTime(value string) time.Time {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC()
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// eventMetadata returns the fields not mapped onto the DeliveryEvent
+func eventMetadata(fields map[string]interface{}, mapped ...string) map[string]string {
+	metadata := map[string]string{}
+	for key := range fields {
+		setMetadata(metadata, key, stringField(fields, key))
+	}
+	for _, key := range mapped {
+		delete(metadata, key)
+	}
+	return metadata
+}
+
+func setMetadata(metadata map[string]string, key, value string) {
+	if value != "" {
+		metadata[key] = value
+	}
+}
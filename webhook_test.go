@@ -0,0 +1,165 @@
+package sendmail
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeliveryEvents_SendGrid(t *testing.T) {
+	body := `[
+		{"email":"jane@example.com","timestamp":1700000000,"event":"bounce","type":"bounce","reason":"550 5.1.1 unknown user","sg_message_id":"abc123.filterdrecv-1","category":["orders"]},
+		{"email":"joe@example.com","timestamp":1700000001,"event":"bounce","type":"blocked","reason":"421 try again later","sg_message_id":"def456.filter"},
+		{"email":"jane@example.com","timestamp":1700000002,"event":"click","url":"https://example.com","order_id":"42"},
+		{"email":"jane@example.com","timestamp":1700000003,"event":"group_resubscribe"}
+	]`
+	events, err := ParseDeliveryEvents("sendgrid", []byte(body))
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+
+	assert.Equal(t, &DeliveryEvent{
+		Provider:  "sendgrid",
+		Kind:      EventHardBounce,
+		Recipient: "jane@example.com",
+		MessageID: "abc123",
+		Timestamp: time.Unix(1700000000, 0).UTC(),
+		Reason:    "550 5.1.1 unknown user",
+		Metadata:  map[string]string{"event": "bounce", "type": "bounce", "category": `["orders"]`},
+	}, events[0])
+	assert.Equal(t, EventSoftBounce, events[1].Kind)
+	assert.Equal(t, EventClick, events[2].Kind)
+	assert.Equal(t, "https://example.com", events[2].Metadata["url"])
+	assert.Equal(t, "42", events[2].Metadata["order_id"])
+	assert.Equal(t, EventUnknown, events[3].Kind)
+	assert.Equal(t, "group_resubscribe", events[3].Metadata["event"])
+}
+
+func TestParseDeliveryEvents_MailJet(t *testing.T) {
+	single := `{"event":"bounce","time":1700000000,"MessageID":19421777396190490,"Message_GUID":"1ab23cd4-e567-8901-2345-6789f0gh1i2j","email":"jane@example.com","blocked":false,"hard_bounce":true,"error_related_to":"recipient","error":"user unknown"}`
+	events, err := ParseDeliveryEvents("mailjet", []byte(single))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventHardBounce, events[0].Kind)
+	assert.Equal(t, "1ab23cd4-e567-8901-2345-6789f0gh1i2j", events[0].MessageID)
+	assert.Equal(t, "recipient: user unknown", events[0].Reason)
+	assert.Equal(t, "19421777396190490", events[0].Metadata["MessageID"])
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), events[0].Timestamp)
+
+	grouped := `[{"event":"bounce","email":"joe@example.com","hard_bounce":false},{"event":"spam","email":"jim@example.com","source":"JMRPP"}]`
+	events, err = ParseDeliveryEvents("mailjet", []byte(grouped))
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, EventSoftBounce, events[0].Kind)
+	assert.Equal(t, EventSpamComplaint, events[1].Kind)
+	assert.Equal(t, "jim@example.com", events[1].Recipient)
+}
+
+func TestParseDeliveryEvents_MailerSend(t *testing.T) {
+	body := `{
+		"type":"activity.hard_bounced","created_at":"2024-01-01T10:00:01.000000Z","webhook_id":"wh1",
+		"data":{"object":"activity","id":"act1","type":"hard_bounced","created_at":"2024-01-01T10:00:00.000000Z",
+			"email":{"subject":"Order","tags":["orders","eu"],"message":{"id":"msg1"},"recipient":{"email":"jane@example.com"}},
+			"morph":{"object":"recipient_bounce","reason":"550 unknown","readable_reason":"The mailbox does not exist"}}
+	}`
+	events, err := ParseDeliveryEvents("mailersend", []byte(body))
+	require.NoError(t, err)
+	assert.Equal(t, []*DeliveryEvent{{
+		Provider:  "mailersend",
+		Kind:      EventHardBounce,
+		Recipient: "jane@example.com",
+		MessageID: "msg1",
+		Timestamp: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		Reason:    "The mailbox does not exist",
+		Metadata: map[string]string{
+			"event":       "activity.hard_bounced",
+			"activity_id": "act1",
+			"webhook_id":  "wh1",
+			"subject":     "Order",
+			"tags":        "orders,eu",
+		},
+	}}, events)
+}
+
+func TestParseDeliveryEvents_MailTrap(t *testing.T) {
+	body := `{"events":[
+		{"event":"soft bounce","message_id":"m1","email":"jane@example.com","timestamp":1700000000,"response":"452 mailbox full","response_code":452,"category":"welcome"},
+		{"event":"delivery","message_id":"m2","email":"joe@example.com","timestamp":1700000001,"custom_variables":{"user_id":"7"}}
+	]}`
+	events, err := ParseDeliveryEvents("mailtrap", []byte(body))
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, EventSoftBounce, events[0].Kind)
+	assert.Equal(t, "m1", events[0].MessageID)
+	assert.Equal(t, "452 mailbox full", events[0].Reason)
+	assert.Equal(t, "452", events[0].Metadata["response_code"])
+	assert.Equal(t, EventDelivered, events[1].Kind)
+	assert.Equal(t, `{"user_id":"7"}`, events[1].Metadata["custom_variables"])
+}
+
+func TestParseDeliveryEvents_Smtp2go(t *testing.T) {
+	form := "event=bounce&bounce=hard&rcpt=jane%40example.com&email_id=1abc-2def&time=2024-01-01T10%3A00%3A00Z&message=550+no+such+user"
+	events, err := ParseDeliveryEvents("smtp2go", []byte(form))
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventHardBounce, events[0].Kind)
+	assert.Equal(t, "jane@example.com", events[0].Recipient)
+	assert.Equal(t, "1abc-2def", events[0].MessageID)
+	assert.Equal(t, "550 no such user", events[0].Reason)
+	assert.Equal(t, time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC), events[0].Timestamp)
+
+	events, err = ParseDeliveryEvents("smtp2go", []byte(`{"event":"open","rcpt":"joe@example.com","email_id":"x","time":"1700000000"}`))
+	require.NoError(t, err)
+	assert.Equal(t, EventOpen, events[0].Kind)
+	assert.Empty(t, events[0].Reason)
+	assert.Equal(t, time.Unix(1700000000, 0).UTC(), events[0].Timestamp)
+}
+
+func TestParseDeliveryEvents_Errors(t *testing.T) {
+	_, err := ParseDeliveryEvents("postmark", []byte("{}"))
+	assert.ErrorIs(t, err, ErrUnknownWebhookProvider)
+
+	_, err = ParseDeliveryEvents("sendgrid", []byte("{"))
+	assert.Error(t, err)
+}
+
+func TestWebhookHandler(t *testing.T) {
+	var received []*DeliveryEvent
+	fail := false
+	handler, err := NewWebhookHandler("sendgrid", func(ctx context.Context, events []*DeliveryEvent) error {
+		if fail {
+			return errors.New("database unavailable")
+		}
+		received = append(received, events...)
+		return nil
+	}, WithWebhookBodyLimit(1024))
+	require.NoError(t, err)
+
+	post := func(body string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/webhooks/sendgrid", strings.NewReader(body)))
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post(`[{"event":"delivered","email":"jane@example.com"}]`))
+	require.Len(t, received, 1)
+	assert.Equal(t, EventDelivered, received[0].Kind)
+
+	assert.Equal(t, http.StatusBadRequest, post("not json"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("["+strings.Repeat(" ", 2048)+"]"))
+	fail = true
+	assert.Equal(t, http.StatusInternalServerError, post(`[]`))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/webhooks/sendgrid", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+
+	_, err = NewWebhookHandler("unknown", func(context.Context, []*DeliveryEvent) error { return nil })
+	assert.ErrorIs(t, err, ErrUnknownWebhookProvider)
+}
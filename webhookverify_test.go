@@ -0,0 +1,179 @@
+package sendmail
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signSendGrid(t *testing.T, key *ecdsa.PrivateKey, timestamp time.Time, body string) *http.Request {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	digest := sha256.Sum256([]byte(ts + body))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodPost, "/webhooks/sendgrid", strings.NewReader(body))
+	request.Header.Set("X-Twilio-Email-Event-Webhook-Signature", base64.StdEncoding.EncodeToString(signature))
+	request.Header.Set("X-Twilio-Email-Event-Webhook-Timestamp", ts)
+	return request
+}
+
+func TestSendGridVerifier(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	verifier, err := NewSendGridVerifier(base64.StdEncoding.EncodeToString(der))
+	require.NoError(t, err)
+
+	body := `[{"event":"delivered","email":"jane@example.com"}]`
+	assert.NoError(t, verifier.Verify(signSendGrid(t, key, time.Now(), body), []byte(body)))
+
+	tampered := `[{"event":"delivered","email":"joe@example.com"}]`
+	assert.ErrorIs(t, verifier.Verify(signSendGrid(t, key, time.Now(), body), []byte(tampered)), ErrInvalidSignature)
+	assert.ErrorIs(t, verifier.Verify(signSendGrid(t, key, time.Now().Add(-time.Hour), body), []byte(body)), ErrStaleWebhook)
+	assert.ErrorIs(t, verifier.Verify(httptest.NewRequest(http.MethodPost, "/", nil), []byte(body)), ErrMissingSignature)
+
+	verifier.Tolerance = 0
+	assert.NoError(t, verifier.Verify(signSendGrid(t, key, time.Now().Add(-time.Hour), body), []byte(body)))
+
+	_, err = NewSendGridVerifier("not a key")
+	assert.Error(t, err)
+}
+
+func signHMAC(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHMACVerifier(t *testing.T) {
+	body := `{"type":"activity.delivered","created_at":"` + time.Now().UTC().Format(time.RFC3339) + `"}`
+
+	verifier, err := NewMailerSendVerifier("secret")
+	require.NoError(t, err)
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	assert.ErrorIs(t, verifier.Verify(request, []byte(body)), ErrMissingSignature)
+	request.Header.Set("Signature", signHMAC("other", body))
+	assert.ErrorIs(t, verifier.Verify(request, []byte(body)), ErrInvalidSignature)
+	request.Header.Set("Signature", signHMAC("secret", body))
+	assert.NoError(t, verifier.Verify(request, []byte(body)))
+
+	mailTrap, err := NewMailTrapVerifier("secret")
+	require.NoError(t, err)
+	body = `{"events":[{"event":"delivery","timestamp":` + strconv.FormatInt(time.Now().Unix()-600, 10) + `},` +
+		`{"event":"open","timestamp":` + strconv.FormatInt(time.Now().Unix(), 10) + `}]}`
+	request = httptest.NewRequest(http.MethodPost, "/", nil)
+	request.Header.Set("Mailtrap-Signature", signHMAC("secret", body))
+	assert.NoError(t, mailTrap.Verify(request, []byte(body)))
+
+	_, err = NewMailerSendVerifier("")
+	assert.ErrorIs(t, err, ErrMissingWebhookSecret)
+	_, err = NewMailTrapVerifier("")
+	assert.ErrorIs(t, err, ErrMissingWebhookSecret)
+	_, err = NewMailJetVerifier("", "")
+	assert.ErrorIs(t, err, ErrMissingWebhookSecret)
+	_, err = NewMailJetVerifier("hooks", "")
+	assert.ErrorIs(t, err, ErrMissingWebhookSecret)
+	request = httptest.NewRequest(http.MethodPost, "/", nil)
+	request.SetBasicAuth("", "")
+	assert.ErrorIs(t, (&BasicAuthVerifier{}).Verify(request, nil), ErrMissingWebhookSecret)
+}
+
+func TestHMACVerifier_Replay(t *testing.T) {
+	created := time.Now().UTC()
+	body := `{"type":"activity.delivered","data":{"created_at":"` + created.Format(time.RFC3339) + `"}}`
+	signature := signHMAC("secret", body)
+
+	verifier, err := NewMailerSendVerifier("secret")
+	require.NoError(t, err)
+	now := created
+	verifier.now = func() time.Time { return now }
+
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	request.Header.Set("Signature", strings.ToUpper(signature))
+	require.NoError(t, verifier.Verify(request, []byte(body)))
+	request.Header.Set("Signature", signature)
+	assert.ErrorIs(t, verifier.Verify(request, []byte(body)), ErrDuplicateWebhook)
+
+	// past the tolerance the signed time rejects the request, after a restart too
+	now = created.Add(5*time.Minute + time.Second)
+	assert.ErrorIs(t, verifier.Verify(request, []byte(body)), ErrStaleWebhook)
+	restarted, err := NewMailerSendVerifier("secret")
+	require.NoError(t, err)
+	restarted.now = verifier.now
+	assert.ErrorIs(t, restarted.Verify(request, []byte(body)), ErrStaleWebhook)
+
+	undated := `{"type":"activity.delivered"}`
+	request.Header.Set("Signature", signHMAC("secret", undated))
+	assert.ErrorIs(t, verifier.Verify(request, []byte(undated)), ErrInvalidSignature)
+
+	// a retry is acknowledged without calling the handler again, unless the handler failed
+	now = created
+	verifier, err = NewMailerSendVerifier("secret")
+	require.NoError(t, err)
+	verifier.now = func() time.Time { return now }
+	calls := 0
+	handler, err := NewWebhookHandler("mailersend", func(ctx context.Context, events []*DeliveryEvent) error {
+		calls++
+		if calls == 1 {
+			return errors.New("database down")
+		}
+		return nil
+	}, WithWebhookVerifier(verifier), WithWebhookLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+	post := func() int {
+		request := httptest.NewRequest(http.MethodPost, "/webhooks/mailersend", strings.NewReader(body))
+		request.Header.Set("Signature", signature)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	assert.Equal(t, http.StatusInternalServerError, post())
+	assert.Equal(t, http.StatusOK, post())
+	assert.Equal(t, http.StatusOK, post())
+	assert.Equal(t, 2, calls)
+}
+
+func TestWebhookHandler_Verifier(t *testing.T) {
+	verifier, err := NewMailJetVerifier("hooks", "s3cret")
+	require.NoError(t, err)
+	calls := 0
+	handler, err := NewWebhookHandler("mailjet", func(ctx context.Context, events []*DeliveryEvent) error {
+		calls++
+		return nil
+	}, WithWebhookVerifier(verifier), WithWebhookLogger(func(string, ...interface{}) {}))
+	require.NoError(t, err)
+
+	post := func(username, password string) int {
+		request := httptest.NewRequest(http.MethodPost, "/webhooks/mailjet", strings.NewReader(`{"event":"open"}`))
+		if username != "" {
+			request.SetBasicAuth(username, password)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, post("", ""))
+	assert.Equal(t, http.StatusUnauthorized, post("hooks", "wrong"))
+	assert.Equal(t, 0, calls)
+	assert.Equal(t, http.StatusOK, post("hooks", "s3cret"))
+	assert.Equal(t, 1, calls)
+}